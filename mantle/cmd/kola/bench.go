@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+var (
+	cmdBench = &cobra.Command{
+		Use:   "bench [glob pattern...]",
+		Short: "Run kola benchmarks",
+		Long: `Run all kola benchmarks (default) or related groups.
+
+Each benchmark runs for --iterations iterations; the mean, median, 95th
+percentile and standard deviation of its duration and of the metrics it
+reports are recorded in the reports. With --baseline, the results are
+compared to those of an earlier report and the command exits non-zero if
+a mean got worse by more than --regression-threshold percent.
+`,
+		RunE:         runBench,
+		PreRunE:      preRun,
+		SilenceUsage: true,
+	}
+
+	benchBaseline  string
+	benchThreshold float64
+	benchJSON      bool
+)
+
+func init() {
+	root.AddCommand(cmdBench)
+	cmdBench.Flags().IntVar(&kola.BenchmarkIterations, "iterations", 5, "number of iterations of each benchmark")
+	cmdBench.Flags().StringVar(&benchBaseline, "baseline", "", "JSON report of an earlier kola bench run to compare the results to")
+	cmdBench.Flags().Float64Var(&benchThreshold, "regression-threshold", 10, "percentage by which a mean must get worse to be a regression")
+	cmdBench.Flags().BoolVar(&benchJSON, "json", false, "format the comparison to the baseline in JSON")
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	var err error
+	outputDir, err = kola.SetupOutputDir(outputDir, kolaPlatform)
+	if err != nil {
+		return err
+	}
+
+	var patterns []string
+	if len(args) == 0 {
+		patterns = []string{"*"} // run all benchmarks by default
+	} else {
+		patterns = args
+	}
+
+	runErr := kola.RunBenchmarks(patterns, kolaPlatform, outputDir)
+
+	// needs to be after RunBenchmarks() because harness empties the directory
+	if err := writeProps(); err != nil {
+		return err
+	}
+	if runErr != nil || benchBaseline == "" {
+		return runErr
+	}
+
+	c, err := kola.CompareBenchmarks(benchBaseline, filepath.Join(outputDir, "reports", "report.json"), benchThreshold)
+	if err != nil {
+		return err
+	}
+	if benchJSON {
+		out, err := json.MarshalIndent(c, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "marshalling benchmark comparison")
+		}
+		fmt.Println(string(out))
+	} else {
+		printBenchmarkComparison(c)
+	}
+	if c.Regressed() {
+		return fmt.Errorf("benchmarks regressed from %s", c.Baseline)
+	}
+	return nil
+}
+
+func printBenchmarkComparison(c *kola.BenchmarkComparison) {
+	fmt.Printf("Comparing to baseline %s\n", c.Baseline)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Benchmark\tUnit\tBaseline\tMean\tStddev\tChange\t")
+	for _, change := range c.Changes {
+		regressed := ""
+		if change.Regressed {
+			regressed = "REGRESSED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.3g\t%.3g\t%.3g\t%+.1f%%\t%s\n", change.Benchmark, change.Unit,
+			change.Baseline, change.Mean, change.Stddev, change.Change, regressed)
+	}
+	w.Flush()
+	for _, name := range c.Missing {
+		fmt.Printf("no result for %s\n", name)
+	}
+}
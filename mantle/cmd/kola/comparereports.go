@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+var (
+	cmdCompareReports = &cobra.Command{
+		Use:   "compare-reports <old.json> <new.json>",
+		Short: "Compare two kola JSON reports",
+		Long: `Compare the tests of two kola JSON reports.
+
+Lists the tests newly failing, newly passing and still failing in the new
+report, the tests added and removed, and the tests which took significantly
+longer. Exits non-zero if any test is newly failing.
+`,
+		Args:         cobra.ExactArgs(2),
+		RunE:         runCompareReports,
+		SilenceUsage: true,
+	}
+
+	compareJSON              bool
+	compareDurationThreshold float64
+	compareMinDurationDelta  time.Duration
+)
+
+func init() {
+	cmdCompareReports.Flags().BoolVarP(&compareJSON, "json", "", false, "format output in JSON")
+	cmdCompareReports.Flags().Float64Var(&compareDurationThreshold, "duration-threshold", 50, "percentage by which a test must slow down to be a duration regression")
+	cmdCompareReports.Flags().DurationVar(&compareMinDurationDelta, "min-duration-delta", 30*time.Second, "minimum time by which a test must slow down to be a duration regression")
+	root.AddCommand(cmdCompareReports)
+}
+
+func runCompareReports(cmd *cobra.Command, args []string) error {
+	c, err := kola.CompareReports(args[0], args[1], kola.CompareOptions{
+		DurationThreshold: compareDurationThreshold,
+		MinDurationDelta:  compareMinDurationDelta,
+	})
+	if err != nil {
+		return err
+	}
+
+	if compareJSON {
+		out, err := json.MarshalIndent(c, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "marshalling report comparison")
+		}
+		fmt.Println(string(out))
+	} else {
+		printReportComparison(c)
+	}
+
+	if c.Regressed() {
+		return fmt.Errorf("%d tests newly failing", len(c.NewlyFailing))
+	}
+	return nil
+}
+
+func printReportComparison(c *kola.ReportComparison) {
+	fmt.Printf("Comparing %s to %s\n", c.New, c.Old)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	printChanges := func(title string, changes []kola.TestChange) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(changes))
+		for _, t := range changes {
+			from, to := string(t.OldResult), string(t.NewResult)
+			if from == "" {
+				from = "-"
+			}
+			if to == "" {
+				to = "-"
+			}
+			fmt.Fprintf(w, "  %s\t%s -> %s\n", t.Name, from, to)
+		}
+	}
+	printChanges("Newly failing", c.NewlyFailing)
+	printChanges("Newly passing", c.NewlyPassing)
+	printChanges("Still failing", c.StillFailing)
+	printChanges("Added", c.Added)
+	printChanges("Removed", c.Removed)
+	if len(c.DurationRegressions) > 0 {
+		fmt.Fprintf(w, "\nDuration regressions (%d):\n", len(c.DurationRegressions))
+		for _, d := range c.DurationRegressions {
+			fmt.Fprintf(w, "  %s\t%v -> %v\t+%.0f%%\n", d.Name, d.Old.Round(time.Second), d.New.Round(time.Second), d.Increase)
+		}
+	}
+	w.Flush()
+}
@@ -36,6 +36,9 @@ Check console output for expressions matching failure messages logged
 by a Container Linux instance.
 
 If no files are specified as arguments, stdin is checked.
+
+Checks from src/config/kola-console-checks.yaml in the cosa workdir are
+merged with the built-in ones, as in kola run.
 `,
 
 		SilenceUsage: true,
@@ -55,6 +58,10 @@ func runCheckConsole(cmd *cobra.Command, args []string) error {
 		args = append(args, "-")
 	}
 
+	if err := kola.LoadConsoleChecks(kolaPlatform); err != nil {
+		return err
+	}
+
 	errorcount := 0
 	for _, arg := range args {
 		var console []byte
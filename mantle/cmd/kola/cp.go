@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+var (
+	cmdCp = &cobra.Command{
+		Use:   "cp <[user@]host[:port]|id>:/remote/path /local/path",
+		Short: "Copy files or directories from a machine",
+		Long: `Copy a file or directory from a running machine, e.g. one started with
+'kola spawn --detach', over SFTP as root.
+
+The machine is given by its SSH address, or its ID in 'kola ps'; the user
+defaults to nest and the port to 22. kola logs in with the keys of the SSH
+agent and of ~/.ssh/id_{rsa,ecdsa,ed25519}, the keys 'kola spawn --keys'
+adds.
+Directories are copied recursively. An interrupted copy resumes where it
+stopped when run again, and copied data is verified with SHA-256.`,
+		Example: `  kola cp 127.0.0.1:2222:/var/log/messages .
+  kola cp --exclude '*.gz' 127.0.0.1:2222:/var/log ./logs
+  kola cp 1b2c:/var/log/messages .`,
+		Args:         cobra.ExactArgs(2),
+		RunE:         runCp,
+		SilenceUsage: true,
+	}
+
+	cpExclude  []string
+	cpProgress bool
+)
+
+func init() {
+	cmdCp.Flags().StringSliceVar(&cpExclude, "exclude", nil, "skip remote paths or base names matching this glob; can be repeated")
+	cmdCp.Flags().BoolVar(&cpProgress, "progress", false, "print the progress of each file")
+	root.AddCommand(cmdCp)
+}
+
+func runCp(cmd *cobra.Command, args []string) error {
+	source := args[0]
+	// a machine of kola ps, given by its ID; a host name without domain
+	// looks the same, so it's only taken as a host name if no machine
+	// matches and it doesn't look like a machine ID
+	if i := strings.Index(source, ":/"); i > 0 && !strings.ContainsAny(source[:i], "@.:[") {
+		s, err := findSpawned(source[:i])
+		switch {
+		case err == nil:
+			source = s.sshAddress() + source[i:]
+		case !isNoSpawnedMachine(err) || looksLikeMachineID(source[:i]):
+			return err
+		}
+	}
+	username, addr, remote, err := parseMachinePath(source)
+	if err != nil {
+		return err
+	}
+	client, err := dialMachine(username, addr)
+	if err != nil {
+		return err
+	}
+	c, err := platform.NewSFTPClient(client)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	local := args[1]
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, filepath.Base(remote))
+	}
+	opts := &platform.TransferOptions{Exclude: cpExclude}
+	if cpProgress {
+		opts.Progress = func(p platform.TransferProgress) {
+			fmt.Fprintf(os.Stderr, "\r%s: %d/%d bytes", p.Path, p.Done, p.Total)
+			if p.Done >= p.Total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	return c.Fetch(remote, local, opts)
+}
+
+// looksLikeMachineID returns whether id could be a prefix of a UUID, the
+// IDs of qemu machines.
+func looksLikeMachineID(id string) bool {
+	return strings.Trim(id, "0123456789abcdef-") == ""
+}
+
+// parseMachinePath splits [user@]host[:port]:/path.
+func parseMachinePath(arg string) (string, string, string, error) {
+	i := strings.Index(arg, ":/")
+	if i <= 0 {
+		return "", "", "", fmt.Errorf("%q isn't of the form [user@]host[:port]:/path", arg)
+	}
+	addr, remote := arg[:i], arg[i+1:]
+	username := "nest"
+	if at := strings.LastIndex(addr, "@"); at >= 0 {
+		username, addr = addr[:at], addr[at+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	return username, addr, remote, nil
+}
+
+// dialMachine logs in to addr with the keys of the SSH agent and the
+// default key files of the user.
+func dialMachine(username, addr string) (*ssh.Client, error) {
+	var auth []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, errors.Wrapf(err, "connecting to SSH agent")
+		}
+		defer conn.Close()
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	userInfo, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+		buf, err := os.ReadFile(filepath.Join(userInfo.HomeDir, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// keys with a passphrase must be in the agent
+		if signer, err := ssh.ParsePrivateKey(buf); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no SSH agent or unencrypted keys in ~/.ssh to log in with")
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: username,
+		Auth: auth,
+		// kola machines are ephemeral, like in the network package
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to %s", addr)
+	}
+	return client, nil
+}
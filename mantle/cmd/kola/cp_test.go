@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseMachinePath(t *testing.T) {
+	for _, tt := range []struct {
+		arg      string
+		username string
+		addr     string
+		remote   string
+		err      bool
+	}{
+		{arg: "127.0.0.1:2222:/var/log/messages", username: "nest", addr: "127.0.0.1:2222", remote: "/var/log/messages"},
+		{arg: "10.0.0.5:/var/log", username: "nest", addr: "10.0.0.5:22", remote: "/var/log"},
+		{arg: "core@10.0.0.5:/", username: "core", addr: "10.0.0.5:22", remote: "/"},
+		{arg: "core@host.example.com:2222:/etc/os-release", username: "core", addr: "host.example.com:2222", remote: "/etc/os-release"},
+		{arg: "[fd00::1]:2222:/var/log", username: "nest", addr: "[fd00::1]:2222", remote: "/var/log"},
+		{arg: "[fd00::1]:/var/log", username: "nest", addr: "[fd00::1]:22", remote: "/var/log"},
+		{arg: "10.0.0.5:var/log", err: true},
+		{arg: ":/var/log", err: true},
+		{arg: "/var/log", err: true},
+	} {
+		username, addr, remote, err := parseMachinePath(tt.arg)
+		if (err != nil) != tt.err {
+			t.Errorf("parseMachinePath(%q) error = %v, want error %v", tt.arg, err, tt.err)
+			continue
+		}
+		if username != tt.username || addr != tt.addr || remote != tt.remote {
+			t.Errorf("parseMachinePath(%q) = %q, %q, %q, want %q, %q, %q", tt.arg, username, addr, remote, tt.username, tt.addr, tt.remote)
+		}
+	}
+}
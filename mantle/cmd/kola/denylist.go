@@ -0,0 +1,127 @@
+// Copyright 2024 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+var (
+	cmdDenyList = &cobra.Command{
+		Use:   "denylist",
+		Short: "Inspect kola-denylist.yaml",
+	}
+
+	cmdDenyListCheck = &cobra.Command{
+		Use:   "check",
+		Short: "Validate kola-denylist.yaml",
+		Long: `Validate kola-denylist.yaml in the cosa workdir.
+
+Reports unknown keys, unknown streams, arches and platforms, invalid
+snooze dates and patterns which match no registered or external test,
+and exits non-zero if any are found. Also lists snoozes expiring soon
+and the entries which apply to the current build, stream, arch and
+platform together with what they do.
+`,
+		RunE:         runDenyListCheck,
+		PreRunE:      preRun,
+		SilenceUsage: true,
+	}
+
+	denyListJSON           bool
+	denyListExpiringWithin int
+)
+
+func init() {
+	cmdDenyListCheck.Flags().BoolVarP(&denyListJSON, "json", "", false, "format output in JSON")
+	cmdDenyListCheck.Flags().StringArrayVarP(&runExternals, "exttest", "E", nil, "Externally defined tests in directory")
+	cmdDenyListCheck.Flags().IntVar(&denyListExpiringWithin, "expiring-within", 7, "report snoozes expiring within this many days")
+	cmdDenyList.AddCommand(cmdDenyListCheck)
+	root.AddCommand(cmdDenyList)
+}
+
+func runDenyListCheck(cmd *cobra.Command, args []string) error {
+	if err := registerExternals(); err != nil {
+		return err
+	}
+	check, err := kola.CheckDenyList(kola.DenyListCheckOptions{
+		Platform:       kolaPlatform,
+		KnownPlatforms: kolaPlatforms,
+		ExpiringWithin: time.Duration(denyListExpiringWithin) * 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+
+	if denyListJSON {
+		out, err := json.MarshalIndent(check, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "marshalling denylist check")
+		}
+		fmt.Println(string(out))
+	} else {
+		printDenyListCheck(check)
+	}
+
+	if check.Failed() {
+		return fmt.Errorf("%s has problems", check.Path)
+	}
+	return nil
+}
+
+func printDenyListCheck(check *kola.DenyListCheck) {
+	fmt.Printf("Checked %s against stream=%s osversion=%s arch=%s platform=%s\n",
+		check.Path, check.Stream, check.OsVersion, check.Arch, check.Platform)
+	for _, e := range check.Errors {
+		fmt.Printf("error: %s\n", e)
+	}
+	for _, e := range check.Entries {
+		for _, p := range e.Problems {
+			fmt.Printf("error: %s: %s\n", e.Pattern, p)
+		}
+	}
+	for _, e := range check.Entries {
+		if e.Expiring {
+			fmt.Printf("snooze expiring: %s on %s (%s)\n", e.Pattern, e.SnoozeDate, e.Tracker)
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Pattern\tApplies\tAction\tTests\tSnooze\tTracker")
+	for _, e := range check.Entries {
+		snooze := e.SnoozeDate
+		if snooze == "" {
+			snooze = "-"
+		}
+		applies := "no"
+		if e.Applies {
+			applies = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", e.Pattern, applies, e.Action,
+			e.MatchedTests, snooze, strings.TrimSpace(e.Tracker))
+	}
+	w.Flush()
+}
@@ -100,9 +100,15 @@ This can be useful for e.g. serving locally built OSTree repos to qemu.
 	}
 
 	cmdRerun = &cobra.Command{
-		Use:     "rerun",
-		Short:   "Rerun tests that failed in the last run",
-		PreRunE: preRun,
+		Use:   "rerun",
+		Short: "Rerun tests that failed in the last run",
+		Long: `Rerun the tests that failed in the last run, or in the run of the
+report given with --from; with --all, rerun all the tests of that run.
+
+The command line flags, the build and the external tests of the original
+run are reused unless given again.
+`,
+		PreRunE: preRerun,
 		RunE:    runRerun,
 
 		SilenceUsage: true,
@@ -129,7 +135,7 @@ This can be useful for e.g. serving locally built OSTree repos to qemu.
 	runRerunFlag      bool
 	allowRerunSuccess string
 
-	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]$`)
+	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]+$`)
 )
 
 func init() {
@@ -155,6 +161,9 @@ func init() {
 	cmdRunUpgrade.Flags().StringVar(&allowRerunSuccess, "allow-rerun-success", "", "Allow kola test run to be successful when tests with given 'tags=...[,...]' pass during re-run")
 
 	root.AddCommand(cmdRerun)
+	cmdRerun.Flags().StringArrayVarP(&runExternals, "exttest", "E", nil, "Externally defined tests (will be found in DIR/tests/kola)")
+	cmdRerun.Flags().StringVar(&rerunReport, "from", "", "JSON report of the run to rerun (default: the last run in the workdir)")
+	cmdRerun.Flags().BoolVar(&rerunAll, "all", false, "Rerun all the tests of the run, not only the failed ones")
 
 	root.AddCommand(cmdNcpu)
 }
@@ -166,6 +175,8 @@ func main() {
 }
 
 func preRun(cmd *cobra.Command, args []string) error {
+	recordInvocationFlags(cmd)
+
 	err := syncOptions()
 	if err != nil {
 		return err
@@ -214,8 +225,11 @@ func runRun(cmd *cobra.Command, args []string) error {
 }
 
 func runRerun(cmd *cobra.Command, args []string) error {
+	if rerunAll {
+		return kolaRunPatterns(rerunProvenance.Invocation.Patterns, false)
+	}
 	var patterns []string
-	data, err := reporters.DeserialiseReport(filepath.Join(kola.Options.CosaWorkdir, "tmp/kola/reports/report.json"))
+	data, err := reporters.DeserialiseReport(rerunReportPath)
 	if err != nil {
 		return err
 	}
@@ -422,6 +436,7 @@ func runList(cmd *cobra.Command, args []string) error {
 			test.Distros,
 			test.ExcludeDistros,
 			test.Tags,
+			test.Requires,
 			test.Description}
 		item.updateValues()
 		testlist = append(testlist, item)
@@ -461,7 +476,7 @@ func runList(cmd *cobra.Command, args []string) error {
 	if !listJSON {
 		var w = tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
 
-		fmt.Fprintln(w, "Test Name\tPlatforms\tArchitectures\tDistributions\tTags")
+		fmt.Fprintln(w, "Test Name\tPlatforms\tArchitectures\tDistributions\tTags\tRequires")
 		fmt.Fprintln(w, "\t")
 		for _, item := range newtestlist {
 			fmt.Fprintf(w, "%v\n", item)
@@ -487,6 +502,7 @@ type item struct {
 	Distros              []string
 	ExcludeDistros       []string `json:"-"`
 	Tags                 []string
+	Requires             []string
 	Description          string
 }
 
@@ -527,7 +543,7 @@ func (i *item) updateValues() {
 }
 
 func (i item) String() string {
-	return fmt.Sprintf("%v\t%v\t%v\t%v\t%v", i.Name, i.Platforms, i.Architectures, i.Distros, i.Tags)
+	return fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v", i.Name, i.Platforms, i.Architectures, i.Distros, i.Tags, i.Requires)
 }
 
 func runHTTPServer(cmd *cobra.Command, args []string) error {
@@ -28,6 +28,7 @@ import (
 
 	// "github.com/coreos/coreos-assembler/mantle/auth"
 	"github.com/coreos/coreos-assembler/mantle/fcos"
+	"github.com/coreos/coreos-assembler/mantle/harness"
 	"github.com/coreos/coreos-assembler/mantle/kola"
 	"github.com/coreos/coreos-assembler/mantle/platform"
 	"github.com/coreos/coreos-assembler/mantle/rhcos"
@@ -37,8 +38,10 @@ import (
 
 var (
 	outputDir         string
+	spawnStateDir     string
 	kolaPlatform      string
 	kolaParallelArg   string
+	kolaResourcesArg  string
 	kolaArchitectures = []string{"amd64"}
 	kolaPlatforms     = []string{"aws", "azure", "do", "esx", "gcp", "openstack", "packet", "qemu", "qemu-iso"}
 	kolaDistros       = []string{"fcos", "rhcos", "scos", "nestos"}
@@ -52,9 +55,11 @@ func init() {
 
 	// general options
 	sv(&outputDir, "output-dir", "", "Temporary output directory for test data and logs")
+	sv(&spawnStateDir, "spawn-state-dir", defaultSpawnStateDir(), "Directory recording the machines left running by kola spawn --detach")
 	root.PersistentFlags().StringVarP(&kolaPlatform, "platform", "p", "", "VM platform: "+strings.Join(kolaPlatforms, ", "))
 	root.PersistentFlags().StringVarP(&kola.Options.Distribution, "distro", "b", "", "Distribution: "+strings.Join(kolaDistros, ", "))
 	root.PersistentFlags().StringVarP(&kolaParallelArg, "parallel", "j", "1", "number of tests to run in parallel, or \"auto\" to match CPU count")
+	sv(&kolaResourcesArg, "resource-budget", "", "Host resources parallel QEMU tests may use: \"auto\" to detect, \"none\", or e.g. \"memory=64G,cpus=32,disk=500G\" (default \"auto\" with -j auto, else \"none\")")
 	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to")
 	root.PersistentFlags().BoolVarP(&kola.Options.UseWarnExitCode77, "on-warn-failure-exit-77", "", false, "Exit with code 77 if 'warn: true' tests fail")
 	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
@@ -63,7 +68,11 @@ func init() {
 	bv(&kola.NoNet, "no-net", false, "Don't run tests that require an Internet connection")
 	bv(&kola.ForceRunPlatformIndependent, "run-platform-independent", false, "Run tests that claim platform independence")
 	ssv(&kola.Tags, "tag", []string{}, "Test tag to run. Can be specified multiple times.")
+	sv(&kola.DurationHistory, "duration-history", "", "JSON report of an earlier run used to estimate test durations (default: last report in the output dir)")
+	sv(&kola.AffectedBy, "affected-by", "", "Only run smoke tests and tests covering packages changed since this build ID")
+	root.PersistentFlags().DurationVar(&kola.TimeBudget, "time-budget", 0, "Only run the tests expected to finish within this duration (e.g. 30m), by priority; the others are reported as deferred")
 	sv(&kola.Sharding, "sharding", "", "Provide e.g. 'hash:m/n' where m and n are integers, 1 <= m <= n.  Only tests hashing to m will be run.")
+	root.PersistentFlags().UintVar(&kola.CoredumpMaxSize, "coredump-max-size", 256, "Most MiB of coredumps to copy from each machine after a crash")
 	bv(&kola.Options.SSHOnTestFailure, "ssh-on-test-failure", false, "SSH into a machine when tests fail")
 	//sv(&kola.Options.Stream, "stream", "", "CoreOS stream ID (e.g. for Fedora CoreOS: stable, testing, next)")
 	sv(&kola.Options.CosaWorkdir, "workdir", "", "nestos-assembler working directory")
@@ -159,6 +168,7 @@ func init() {
 	bv(&kola.QEMUOptions.Native4k, "qemu-native-4k", false, "Force 4k sectors for main disk")
 	bv(&kola.QEMUOptions.Nvme, "qemu-nvme", false, "Use NVMe for main disk")
 	bv(&kola.QEMUOptions.Swtpm, "qemu-swtpm", true, "Create temporary software TPM")
+	root.PersistentFlags().IntVar(&kola.QEMUOptions.PoolSize, "qemu-pool-size", 0, "Keep this many machines with the default config booted for tests which can use them")
 	ssv(&kola.QEMUOptions.BindRO, "qemu-bind-ro", nil, "Inject a host directory; this does not automatically mount in the guest")
 
 	sv(&kola.QEMUIsoOptions.IsoPath, "qemu-iso", "", "path to NestOS ISO image")
@@ -212,6 +222,20 @@ func syncOptionsImpl(useCosa bool) error {
 		kola.TestParallelism = int(parallel)
 	}
 
+	// host resource budget
+	if kolaResourcesArg == "" {
+		if kolaParallelArg == "auto" {
+			kolaResourcesArg = "auto"
+		} else {
+			kolaResourcesArg = "none"
+		}
+	}
+	budget, err := parseResourceBudget(kolaResourcesArg)
+	if err != nil {
+		return fmt.Errorf("parsing --resource-budget argument: %w", err)
+	}
+	kola.ResourceBudget = budget
+
 	// native 4k requires a UEFI bootloader
 	if kola.QEMUOptions.Native4k && kola.QEMUOptions.Firmware == "bios" {
 		return fmt.Errorf("native 4k requires uefi firmware")
@@ -350,6 +374,68 @@ func syncOptionsImpl(useCosa bool) error {
 	return nil
 }
 
+// parseResourceBudget parses the --resource-budget argument.
+func parseResourceBudget(spec string) (harness.Resources, error) {
+	var budget harness.Resources
+	switch spec {
+	case "none":
+		return budget, nil
+	case "auto":
+		memory, err := system.GetMemoryMiB()
+		if err != nil {
+			return budget, fmt.Errorf("detecting available memory: %w", err)
+		}
+		ncpu, err := system.GetProcessors()
+		if err != nil {
+			return budget, fmt.Errorf("detecting CPU count: %w", err)
+		}
+		// QEMU disk images are created in /var/tmp
+		disk, err := system.GetDiskSpaceGiB("/var/tmp")
+		if err != nil {
+			return budget, fmt.Errorf("detecting available disk space: %w", err)
+		}
+		budget = harness.Resources{
+			MemoryMiB: int(memory),
+			CPUs:      int(ncpu),
+			DiskGiB:   int(disk),
+		}
+		fmt.Printf("Using detected resource budget %v\n", budget)
+		return budget, nil
+	}
+
+	for _, kv := range strings.Split(spec, ",") {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return budget, fmt.Errorf("invalid resource %q", kv)
+		}
+		var n int
+		var err error
+		switch key {
+		case "memory":
+			// MiB, or GiB with a G suffix
+			if strings.HasSuffix(val, "G") {
+				n, err = strconv.Atoi(strings.TrimSuffix(val, "G"))
+				n *= 1024
+			} else {
+				n, err = strconv.Atoi(strings.TrimSuffix(val, "M"))
+			}
+			budget.MemoryMiB = n
+		case "cpus":
+			n, err = strconv.Atoi(val)
+			budget.CPUs = n
+		case "disk":
+			n, err = strconv.Atoi(strings.TrimSuffix(val, "G"))
+			budget.DiskGiB = n
+		default:
+			return budget, fmt.Errorf("unknown resource %q", key)
+		}
+		if err != nil || n < 0 {
+			return budget, fmt.Errorf("invalid %s %q", key, val)
+		}
+	}
+	return budget, nil
+}
+
 // syncOptions updates default values of options based on provided ones
 func syncOptions() error {
 	return syncOptionsImpl(true)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+var (
+	rerunReport string
+	rerunAll    bool
+
+	// rerunReportPath and rerunProvenance are set by preRerun
+	rerunReportPath string
+	rerunProvenance *kola.Provenance
+)
+
+// recordInvocationFlags saves the flags given on the command line for the
+// provenance of the run.
+func recordInvocationFlags(cmd *cobra.Command) {
+	flags := make(map[string][]string)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			flags[f.Name] = sv.GetSlice()
+		} else {
+			flags[f.Name] = []string{f.Value.String()}
+		}
+	})
+	kola.InvocationFlags = flags
+}
+
+// applyRecordedFlags sets the flags recorded in a report which weren't
+// given on the command line. The output dir isn't reused so the original
+// run's output isn't wiped.
+func applyRecordedFlags(cmd *cobra.Command, flags map[string][]string) error {
+	for name, values := range flags {
+		if name == "output-dir" {
+			continue
+		}
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			if err := sv.Replace(values); err != nil {
+				return fmt.Errorf("setting --%s from the report: %w", name, err)
+			}
+			f.Changed = true
+		} else if len(values) == 1 {
+			if err := cmd.Flags().Set(name, values[0]); err != nil {
+				return fmt.Errorf("setting --%s from the report: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func preRerun(cmd *cobra.Command, args []string) error {
+	path := rerunReport
+	if path == "" {
+		path = filepath.Join(kola.Options.CosaWorkdir, "tmp/kola/reports/report.json")
+	}
+	data, err := reporters.DeserialiseReport(path)
+	if err != nil {
+		return err
+	}
+	rerunReportPath = path
+
+	if len(data.Provenance) > 0 {
+		var p kola.Provenance
+		if err := json.Unmarshal(data.Provenance, &p); err != nil {
+			return fmt.Errorf("parsing provenance of %s: %w", path, err)
+		}
+		rerunProvenance = &p
+		if err := applyRecordedFlags(cmd, p.Invocation.Flags); err != nil {
+			return err
+		}
+		// pin the build the report was made against, if it's still there
+		if p.Build != nil && p.Build.ID != "" && !cmd.Flags().Changed("build") {
+			workdir := kola.Options.CosaWorkdir
+			if workdir == "" {
+				workdir = "."
+			}
+			if _, err := os.Stat(filepath.Join(workdir, "builds", p.Build.ID)); err == nil {
+				if err := cmd.Flags().Set("build", p.Build.ID); err != nil {
+					return err
+				}
+			} else {
+				fmt.Printf("warning: build %s of the report isn't in the workdir, using the default build\n", p.Build.ID)
+			}
+		}
+	} else if rerunAll {
+		return fmt.Errorf("%s has no provenance to rerun all its tests from", path)
+	}
+
+	if err := preRun(cmd, args); err != nil {
+		return err
+	}
+
+	if rerunProvenance != nil && rerunProvenance.Build != nil && kola.CosaBuild != nil {
+		if b := rerunProvenance.Build; b.OstreeCommit != "" && b.OstreeCommit != kola.CosaBuild.Meta.OstreeCommit {
+			fmt.Printf("warning: the report was made against ostree commit %s, rerunning against %s\n", b.OstreeCommit, kola.CosaBuild.Meta.OstreeCommit)
+		}
+	}
+	return nil
+}
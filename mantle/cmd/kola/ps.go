@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+var (
+	cmdPs = &cobra.Command{
+		Use:   "ps",
+		Short: "List machines left running by kola spawn --detach",
+		Long: `List the machines started with 'kola spawn --detach'. Machines whose
+QEMU process stopped are dropped from the list, and expired qemu machines
+are destroyed.`,
+		Args:         cobra.NoArgs,
+		RunE:         runPs,
+		SilenceUsage: true,
+	}
+
+	cmdSSH = &cobra.Command{
+		Use:   "ssh <id> [command...]",
+		Short: "Log in to a machine started by kola spawn --detach",
+		Long: `Open a shell on a machine started with 'kola spawn --detach', or run a
+command on it. The ID may be abbreviated to a unique prefix.`,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         runSSH,
+		SilenceUsage: true,
+	}
+
+	cmdConsole = &cobra.Command{
+		Use:   "console <id>",
+		Short: "Show the serial console of a machine started by kola spawn --detach",
+		Long: `Print the serial console log of a qemu machine started with
+'kola spawn --detach'; with --follow, keep printing it as it grows until the
+machine stops or Ctrl-C.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         runConsole,
+		SilenceUsage: true,
+	}
+
+	cmdLogs = &cobra.Command{
+		Use:   "logs <id> [-- journalctl options...]",
+		Short: "Show the journal of a machine started by kola spawn --detach",
+		Example: `  kola logs 1b2c
+  kola logs 1b2c -- -f -u sshd`,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         runLogs,
+		SilenceUsage: true,
+	}
+
+	cmdDestroy = &cobra.Command{
+		Use:   "destroy <id...> | --all",
+		Short: "Destroy machines started by kola spawn --detach",
+		Long: `Stop machines started with 'kola spawn --detach' and forget them. kola
+can only stop qemu machines; terminate machines of other platforms with the
+platform's tools, then run 'kola destroy --forget' to forget them.`,
+		RunE:         runDestroy,
+		SilenceUsage: true,
+	}
+
+	psJSON        bool
+	consoleFollow bool
+	destroyAll    bool
+	destroyForget bool
+)
+
+// consolePollInterval is how often kola console --follow checks for new
+// output.
+const consolePollInterval = 500 * time.Millisecond
+
+func init() {
+	cmdPs.Flags().BoolVar(&psJSON, "json", false, "print the machine records as JSON")
+	root.AddCommand(cmdPs)
+	// options after the ID go to the remote command
+	cmdSSH.Flags().SetInterspersed(false)
+	root.AddCommand(cmdSSH)
+	cmdConsole.Flags().BoolVarP(&consoleFollow, "follow", "f", false, "keep printing the console as it grows")
+	root.AddCommand(cmdConsole)
+	cmdLogs.Flags().SetInterspersed(false)
+	root.AddCommand(cmdLogs)
+	cmdDestroy.Flags().BoolVar(&destroyAll, "all", false, "destroy all spawned machines")
+	cmdDestroy.Flags().BoolVar(&destroyForget, "forget", false, "only forget the machines, without stopping them")
+	root.AddCommand(cmdDestroy)
+}
+
+func runPs(cmd *cobra.Command, args []string) error {
+	machines, err := loadSpawned()
+	if err != nil {
+		return err
+	}
+	if psJSON {
+		if machines == nil {
+			machines = []*spawnedMachine{}
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(machines)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPLATFORM\tSSH\tPID\tCREATED\tEXPIRES")
+	for _, s := range machines {
+		pid := "-"
+		if s.PID > 0 {
+			pid = fmt.Sprintf("%d", s.PID)
+		}
+		expires := "never"
+		if s.Expires != nil {
+			expires = "in " + time.Until(*s.Expires).Round(time.Minute).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s ago\t%s\n", s.ID, s.Platform, s.sshAddress(), pid,
+			time.Since(s.Created).Round(time.Second), expires)
+	}
+	return w.Flush()
+}
+
+// dialSpawned logs in to a spawned machine as the default user.
+func dialSpawned(id string) (*ssh.Client, error) {
+	s, err := findSpawned(id)
+	if err != nil {
+		return nil, err
+	}
+	return dialMachine("nest", s.sshAddress())
+}
+
+func runSSH(cmd *cobra.Command, args []string) error {
+	client, err := dialSpawned(args[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if len(args) == 1 {
+		return platform.ManholeClient(client)
+	}
+	return runRemote(client, shellquote.Join(args[1:]...))
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	client, err := dialSpawned(args[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return runRemote(client, "journalctl --no-pager "+shellquote.Join(args[1:]...))
+}
+
+// runRemote runs command on client with the standard streams of kola. A
+// non-zero exit status becomes the exit status of kola.
+func runRemote(client *ssh.Client, command string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrapf(err, "failed creating SSH session")
+	}
+	defer session.Close()
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	err = session.Run(command)
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		os.Exit(exitErr.ExitStatus())
+	}
+	return err
+}
+
+func runConsole(cmd *cobra.Command, args []string) error {
+	s, err := findSpawned(args[0])
+	if err != nil {
+		return err
+	}
+	if s.ConsoleFile == "" {
+		return fmt.Errorf("no console log for %s machines", s.Platform)
+	}
+	f, err := os.Open(s.ConsoleFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return err
+		}
+		if !consoleFollow || !s.running() {
+			return nil
+		}
+		time.Sleep(consolePollInterval)
+	}
+}
+
+func runDestroy(cmd *cobra.Command, args []string) error {
+	if destroyAll == (len(args) > 0) {
+		return fmt.Errorf("pass either machine IDs or --all")
+	}
+	var machines []*spawnedMachine
+	if destroyAll {
+		var err error
+		if machines, err = loadSpawned(); err != nil {
+			return err
+		}
+	} else {
+		for _, id := range args {
+			s, err := findSpawned(id)
+			if err != nil {
+				return err
+			}
+			machines = append(machines, s)
+		}
+	}
+	var errs []string
+	for _, s := range machines {
+		if err := s.destroy(destroyForget); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		fmt.Println(s.ID)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -56,6 +56,7 @@ var (
 	spawnSetSSHKeys     bool
 	spawnSSHKeys        []string
 	spawnJSONInfoFd     int
+	spawnTTL            time.Duration
 )
 
 func init() {
@@ -69,6 +70,7 @@ func init() {
 	cmdSpawn.Flags().BoolVarP(&spawnVerbose, "verbose", "v", false, "output information about spawned instances")
 	cmdSpawn.Flags().StringVar(&spawnMachineOptions, "qemu-options", "", "experimental: path to QEMU machine options JSON")
 	cmdSpawn.Flags().IntVarP(&spawnJSONInfoFd, "json-info-fd", "", -1, "experimental: write JSON information about spawned machines")
+	cmdSpawn.Flags().DurationVar(&spawnTTL, "ttl", 0, "with --remove=false, destroy the machines after this long (default: never); see kola ps")
 	cmdSpawn.Flags().BoolVarP(&spawnSetSSHKeys, "keys", "k", false, "add SSH keys from --key options")
 	cmdSpawn.Flags().StringSliceVar(&spawnSSHKeys, "key", nil, "path to SSH public key (default: SSH agent + ~/.ssh/id_{rsa,dsa,ecdsa,ed25519}.pub)")
 	root.AddCommand(cmdSpawn)
@@ -88,6 +90,13 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 		spawnShell = false
 	}
 
+	if !spawnRemove {
+		// clean up after earlier detached spawns
+		if _, err := loadSpawned(); err != nil {
+			plog.Warningf("Listing spawned machines: %v", err)
+		}
+	}
+
 	if spawnNodeCount <= 0 {
 		return fmt.Errorf("Cluster Failed: nodecount must be one or more")
 	}
@@ -193,6 +202,14 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 		if spawnVerbose {
 			fmt.Printf("Machine %v spawned at %v\n", mach.ID(), mach.IP())
 		}
+		if !spawnRemove {
+			if err := recordSpawned(mach, spawnTTL); err != nil {
+				return errors.Wrapf(err, "Recording machine failed")
+			}
+			if spawnVerbose {
+				fmt.Printf("Log in with: kola ssh %v\n", mach.ID())
+			}
+		}
 		if jsonInfoFile != nil {
 			if err := platform.WriteJSONInfo(mach, jsonInfoFile); err != nil {
 				return fmt.Errorf("Failed writing JSON info: %v", err)
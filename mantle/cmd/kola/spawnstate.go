@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// spawnKillTimeout is how long kola destroy waits for QEMU to exit after
+// SIGTERM before killing it.
+const spawnKillTimeout = 10 * time.Second
+
+// spawnedMachine is the record of a machine left running by
+// 'kola spawn --detach', stored as <id>.json in the spawn state directory.
+type spawnedMachine struct {
+	Platform  string `json:"platform"`
+	ID        string `json:"id"`
+	IP        string `json:"ip"`
+	PrivateIP string `json:"privateIp"`
+	SSHPort   int    `json:"sshPort"`
+	// PID and ConsoleFile are only known on qemu
+	PID         int        `json:"pid,omitempty"`
+	ConsoleFile string     `json:"consoleFile,omitempty"`
+	OutputDir   string     `json:"outputDir"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+// defaultSpawnStateDir returns $XDG_STATE_HOME/kola/spawn, defaulting to
+// ~/.local/state/kola/spawn.
+func defaultSpawnStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "kola", "spawn")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "kola", "spawn")
+}
+
+// splitSSHAddress splits a machine IP, which includes the SSH port on qemu.
+func splitSSHAddress(ip string) (string, int) {
+	host, port, err := net.SplitHostPort(ip)
+	if err != nil {
+		return ip, 22
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return ip, 22
+	}
+	return host, p
+}
+
+// recordSpawned adds m to the spawn state directory. A positive ttl makes
+// the machine expire after it.
+func recordSpawned(m platform.Machine, ttl time.Duration) error {
+	ip, port := splitSSHAddress(m.IP())
+	privateIP, _ := splitSSHAddress(m.PrivateIP())
+	s := &spawnedMachine{
+		Platform:  kolaPlatform,
+		ID:        m.ID(),
+		IP:        ip,
+		PrivateIP: privateIP,
+		SSHPort:   port,
+		OutputDir: filepath.Join(m.RuntimeConf().OutputDir, m.ID()),
+		Created:   time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := s.Created.Add(ttl)
+		s.Expires = &expires
+	}
+	if qm, ok := m.(platform.QEMUMachine); ok {
+		s.PID = qm.Pid()
+		s.ConsoleFile = qm.ConsoleFile()
+	}
+
+	if spawnStateDir == "" {
+		return fmt.Errorf("no spawn state directory; set --spawn-state-dir")
+	}
+	if err := os.MkdirAll(spawnStateDir, 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write atomically, other kola commands may be reading the directory
+	tmp := s.path() + ".tmp"
+	if err := os.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path())
+}
+
+func (s *spawnedMachine) path() string {
+	return filepath.Join(spawnStateDir, s.ID+".json")
+}
+
+// sshAddress returns the host:port to SSH to.
+func (s *spawnedMachine) sshAddress() string {
+	return net.JoinHostPort(s.IP, strconv.Itoa(s.SSHPort))
+}
+
+func (s *spawnedMachine) expired() bool {
+	return s.Expires != nil && time.Now().After(*s.Expires)
+}
+
+// running returns false if the QEMU process of the machine is gone. It
+// returns true if the platform isn't qemu, as kola can't tell then.
+func (s *spawnedMachine) running() bool {
+	if s.PID <= 0 {
+		return true
+	}
+	// the PID may have been reused since; QEMU has the console file on
+	// its command line
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", s.PID))
+	if err != nil {
+		return false
+	}
+	return s.ConsoleFile == "" || strings.Contains(string(cmdline), s.ConsoleFile)
+}
+
+// destroy stops the machine and removes its record. Only qemu machines can
+// be stopped; others must be terminated with the tools of their platform,
+// unless forget is set, which only removes the record.
+func (s *spawnedMachine) destroy(forget bool) error {
+	if !forget {
+		if s.PID <= 0 {
+			return fmt.Errorf("kola can't destroy %s machines; terminate %s with the %s tools, then run kola destroy --forget %s", s.Platform, s.ID, s.Platform, s.ID)
+		}
+		if err := s.kill(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(s.path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// kill stops the QEMU process, politely first.
+func (s *spawnedMachine) kill() error {
+	if !s.running() {
+		return nil
+	}
+	if err := syscall.Kill(s.PID, syscall.SIGTERM); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return errors.Wrapf(err, "stopping QEMU of %s", s.ID)
+	}
+	deadline := time.Now().Add(spawnKillTimeout)
+	for time.Now().Before(deadline) {
+		if !s.running() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := syscall.Kill(s.PID, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return errors.Wrapf(err, "killing QEMU of %s", s.ID)
+	}
+	return nil
+}
+
+// loadSpawned returns the machines of the spawn state directory, oldest
+// first. Records of qemu machines which stopped are removed, and expired
+// machines destroyed.
+func loadSpawned() ([]*spawnedMachine, error) {
+	if spawnStateDir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(spawnStateDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var machines []*spawnedMachine
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			// destroyed meanwhile
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var s spawnedMachine
+		if err := json.Unmarshal(buf, &s); err != nil {
+			plog.Warningf("Skipping %s: %v", path, err)
+			continue
+		}
+		switch {
+		case !s.running():
+			plog.Infof("Machine %s stopped, removing it", s.ID)
+			if err := s.destroy(true); err != nil {
+				plog.Warningf("Removing %s: %v", path, err)
+			}
+			continue
+		case s.expired() && s.PID > 0:
+			plog.Infof("Machine %s expired, destroying it", s.ID)
+			if err := s.destroy(false); err != nil {
+				plog.Warningf("Destroying expired machine %s: %v", s.ID, err)
+			}
+			continue
+		case s.expired():
+			plog.Warningf("Machine %s on %s expired; terminate it with the %s tools, then run kola destroy --forget %s", s.ID, s.Platform, s.Platform, s.ID)
+		}
+		machines = append(machines, &s)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].Created.Before(machines[j].Created)
+	})
+	return machines, nil
+}
+
+// findSpawned returns the spawned machine whose ID is id, or starts with
+// it if that's unique.
+func findSpawned(id string) (*spawnedMachine, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty machine ID")
+	}
+	machines, err := loadSpawned()
+	if err != nil {
+		return nil, err
+	}
+	var found []*spawnedMachine
+	for _, s := range machines {
+		if s.ID == id {
+			return s, nil
+		}
+		if strings.HasPrefix(s.ID, id) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, noSpawnedMachineError(id)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%q matches %d spawned machines", id, len(found))
+}
+
+// noSpawnedMachineError is returned by findSpawned if no machine matches.
+type noSpawnedMachineError string
+
+func (e noSpawnedMachineError) Error() string {
+	return fmt.Sprintf("no spawned machine %q; see kola ps", string(e))
+}
+
+func isNoSpawnedMachine(err error) bool {
+	_, ok := err.(noSpawnedMachineError)
+	return ok
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitSSHAddress(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		host string
+		port int
+	}{
+		{"127.0.0.1:2222", "127.0.0.1", 2222},
+		{"10.0.0.5", "10.0.0.5", 22},
+		{"[fd00::1]:2222", "fd00::1", 2222},
+		{"fd00::1", "fd00::1", 22},
+		{"127.0.0.1:ssh", "127.0.0.1:ssh", 22},
+	} {
+		host, port := splitSSHAddress(tt.ip)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitSSHAddress(%q) = %q, %d, want %q, %d", tt.ip, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+// useSpawnStateDir points the spawn state directory to a temporary one
+// for the test.
+func useSpawnStateDir(t *testing.T) {
+	old := spawnStateDir
+	spawnStateDir = t.TempDir()
+	t.Cleanup(func() { spawnStateDir = old })
+}
+
+func writeSpawned(t *testing.T, s *spawnedMachine) {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path(), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startFakeQEMU starts a process standing in for the QEMU of a spawned
+// machine, with consoleFile on its command line.
+func startFakeQEMU(t *testing.T, consoleFile string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", "sleep 300; exit 0", consoleFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func TestFindSpawned(t *testing.T) {
+	useSpawnStateDir(t)
+	now := time.Now().UTC()
+	for _, id := range []string{"1b2c3d4e", "1b2cffff", "9a8b7c6d"} {
+		writeSpawned(t, &spawnedMachine{Platform: "aws", ID: id, Created: now})
+	}
+	writeSpawned(t, &spawnedMachine{Platform: "aws", ID: "1b2c", Created: now})
+
+	for _, tt := range []struct {
+		id       string
+		found    string
+		notFound bool
+		err      bool
+	}{
+		{id: "9a8b7c6d", found: "9a8b7c6d"},
+		{id: "9a", found: "9a8b7c6d"},
+		{id: "1b2c3", found: "1b2c3d4e"},
+		// an exact match wins over longer IDs
+		{id: "1b2c", found: "1b2c"},
+		{id: "1b", err: true},
+		{id: "ffff", err: true, notFound: true},
+		{id: "", err: true},
+	} {
+		s, err := findSpawned(tt.id)
+		if (err != nil) != tt.err {
+			t.Errorf("findSpawned(%q) error = %v, want error %v", tt.id, err, tt.err)
+			continue
+		}
+		if isNoSpawnedMachine(err) != tt.notFound {
+			t.Errorf("findSpawned(%q) error = %v, want not found %v", tt.id, err, tt.notFound)
+		}
+		if err == nil && s.ID != tt.found {
+			t.Errorf("findSpawned(%q) = %s, want %s", tt.id, s.ID, tt.found)
+		}
+	}
+}
+
+func TestLoadSpawnedExpiry(t *testing.T) {
+	useSpawnStateDir(t)
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	consoleFile := func(id string) string {
+		return filepath.Join(spawnStateDir, id, "console.txt")
+	}
+	live := startFakeQEMU(t, consoleFile("live"))
+	writeSpawned(t, &spawnedMachine{Platform: "qemu", ID: "live", PID: live.Process.Pid,
+		ConsoleFile: consoleFile("live"), Created: now, Expires: &future})
+	// spawned without --ttl
+	forever := startFakeQEMU(t, consoleFile("forever"))
+	writeSpawned(t, &spawnedMachine{Platform: "qemu", ID: "forever", PID: forever.Process.Pid,
+		ConsoleFile: consoleFile("forever"), Created: now.Add(-48 * time.Hour)})
+	expired := startFakeQEMU(t, consoleFile("expired"))
+	writeSpawned(t, &spawnedMachine{Platform: "qemu", ID: "expired", PID: expired.Process.Pid,
+		ConsoleFile: consoleFile("expired"), Created: now, Expires: &past})
+	// the PID now belongs to another process
+	writeSpawned(t, &spawnedMachine{Platform: "qemu", ID: "stopped", PID: live.Process.Pid,
+		ConsoleFile: consoleFile("stopped"), Created: now})
+	// kola can't stop machines of other platforms, so they're kept
+	writeSpawned(t, &spawnedMachine{Platform: "aws", ID: "cloud", Created: now.Add(-time.Hour), Expires: &past})
+
+	machines, err := loadSpawned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range machines {
+		ids = append(ids, s.ID)
+	}
+	if len(ids) != 3 || ids[0] != "forever" || ids[1] != "cloud" || ids[2] != "live" {
+		t.Errorf("loadSpawned() = %v, want [forever cloud live]", ids)
+	}
+	for _, id := range []string{"expired", "stopped"} {
+		if _, err := os.Stat(filepath.Join(spawnStateDir, id+".json")); !os.IsNotExist(err) {
+			t.Errorf("record of %s not removed: %v", id, err)
+		}
+	}
+
+	// the expired machine was killed
+	done := make(chan error, 1)
+	go func() { done <- expired.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(spawnKillTimeout):
+		t.Errorf("QEMU of expired machine still running")
+	}
+}
+
+func TestLooksLikeMachineID(t *testing.T) {
+	for _, tt := range []struct {
+		id string
+		ok bool
+	}{
+		{"1b2c", true},
+		{"0f4a3c5e-8d1b-4b6c-9a2f-7e3d1c0b9a8f", true},
+		{"localhost", false},
+		{"builder", false},
+	} {
+		if ok := looksLikeMachineID(tt.id); ok != tt.ok {
+			t.Errorf("looksLikeMachineID(%q) = %v, want %v", tt.id, ok, tt.ok)
+		}
+	}
+}
@@ -353,6 +353,15 @@ func getAllTests(build *util.LocalBuild) []string {
 	return tests
 }
 
+// testIsoRequirements returns the host capabilities test needs, see
+// register.Test.Requires.
+func testIsoRequirements(test string) []string {
+	if kola.HasString("uefi-secure", strings.Split(test, ".")) {
+		return []string{kola.RequireUEFISecure}
+	}
+	return nil
+}
+
 func newBaseQemuBuilder(outdir string) (*platform.QemuBuilder, error) {
 	builder := platform.NewMetalQemuBuilderDefault()
 	if enableUefiSecure {
@@ -528,6 +537,11 @@ func runTestIso(cmd *cobra.Command, args []string) error {
 		isOffline = false
 		inst := baseInst // Pretend this is Rust and I wrote .copy()
 
+		if unmet := kola.UnmetRequirements(testIsoRequirements(test)); len(unmet) > 0 {
+			fmt.Printf("SKIP: %s\n    host doesn't meet the test's requirements: %s\n", test, strings.Join(unmet, "; "))
+			continue
+		}
+
 		fmt.Printf("Running test: %s\n", test)
 		components := strings.Split(test, ".")
 
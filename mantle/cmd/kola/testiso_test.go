@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+func TestTestIsoRequirements(t *testing.T) {
+	for _, tt := range []struct {
+		test     string
+		requires []string
+	}{
+		{"iso-live-login.uefi-secure", []string{kola.RequireUEFISecure}},
+		{"iso-as-disk.uefi-secure", []string{kola.RequireUEFISecure}},
+		{"iso-live-login.uefi", nil},
+		{"iso-offline-install.4k.uefi", nil},
+		{"pxe-online-install.bios", nil},
+	} {
+		if requires := testIsoRequirements(tt.test); !reflect.DeepEqual(requires, tt.requires) {
+			t.Errorf("testIsoRequirements(%q) = %q, want %q", tt.test, requires, tt.requires)
+		}
+	}
+}
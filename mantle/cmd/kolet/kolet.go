@@ -109,6 +109,9 @@ exec ~nest/kolet reboot-request "$1"
 
 	// File used to communicate between the script and the kolet runner internally
 	rebootRequestFifo = "/run/kolet-reboot"
+
+	// Drop-in passing the --env variables to the test unit
+	envDropinName = "10-kolet-env.conf"
 )
 
 var (
@@ -259,6 +262,25 @@ func initiateReboot(mark string) error {
 	return nil
 }
 
+// writeEnvDropin adds the KEY=VALUE pairs in env to the environment of
+// unitname with a runtime drop-in.
+func writeEnvDropin(unitname string, env []string) error {
+	var buf strings.Builder
+	buf.WriteString("[Service]\n")
+	for _, kv := range env {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", kv)
+		}
+		quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(kv)
+		fmt.Fprintf(&buf, "Environment=\"%s\"\n", quoted)
+	}
+	dir := fmt.Sprintf("/run/systemd/system/%s.d", unitname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dir+"/"+envDropinName, []byte(buf.String()), 0644)
+}
+
 func runExtUnit(cmd *cobra.Command, args []string) error {
 	rebootOff, _ := cmd.Flags().GetBool("deny-reboots")
 	// Write the autopkgtest wrappers
@@ -306,6 +328,16 @@ func runExtUnit(cmd *cobra.Command, args []string) error {
 		return errors.Wrapf(err, "systemd connection")
 	}
 
+	env, _ := cmd.Flags().GetStringArray("env")
+	if len(env) > 0 {
+		if err := writeEnvDropin(unitname, env); err != nil {
+			return err
+		}
+		if err := sdconn.ReloadContext(ctx); err != nil {
+			return errors.Wrapf(err, "reloading systemd")
+		}
+	}
+
 	// Start the unit; it's not started by default because we need to
 	// do some preparatory work above (and some is done in the harness)
 	if _, err := sdconn.StartUnitContext(ctx, unitname, "fail", nil); err != nil {
@@ -400,6 +432,7 @@ func main() {
 	registerTestMap(register.UpgradeTests)
 	root.AddCommand(cmdRun)
 	cmdRunExtUnit.Flags().Bool("deny-reboots", false, "disable reboot requests")
+	cmdRunExtUnit.Flags().StringArray("env", nil, "set KEY=VALUE in the environment of the unit")
 	root.AddCommand(cmdRunExtUnit)
 	cmdReboot.Args = cobra.ExactArgs(1)
 	root.AddCommand(cmdReboot)
@@ -0,0 +1,211 @@
+package harness
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// B is passed to benchmarks run with H.Benchmark. It embeds the H of the
+// test running the benchmark, so it logs and fails like a test. Each
+// iteration is timed, and metrics reported with ReportMetric are recorded
+// per iteration; the harness summarises them once all iterations ran.
+type B struct {
+	*H
+	N int // the number of iterations to run
+
+	i           int // iterations started
+	timerOn     bool
+	start       time.Time
+	elapsed     time.Duration
+	samples     []float64
+	metrics     map[string][]float64
+	iterMetrics map[string]float64
+}
+
+// BenchmarkStats summarises the values of a measurement over the
+// iterations of a benchmark.
+type BenchmarkStats struct {
+	Unit    string    `json:"unit"`
+	Samples []float64 `json:"samples"`
+	Mean    float64   `json:"mean"`
+	P50     float64   `json:"p50"`
+	P95     float64   `json:"p95"`
+	Stddev  float64   `json:"stddev"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+}
+
+// BenchmarkResult is the outcome of a benchmark; it's recorded in the
+// reports as the "benchmark" detail of the test.
+type BenchmarkResult struct {
+	Iterations int `json:"iterations"`
+	// Duration is the timed part of each iteration, in seconds
+	Duration BenchmarkStats `json:"duration"`
+	// Metrics are the metrics reported by the benchmark, by unit
+	Metrics map[string]BenchmarkStats `json:"metrics,omitempty"`
+}
+
+// NewBenchmarkStats computes the statistics of samples.
+func NewBenchmarkStats(unit string, samples []float64) BenchmarkStats {
+	s := BenchmarkStats{Unit: unit, Samples: samples}
+	if len(samples) == 0 {
+		return s
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	s.Mean = sum / float64(len(sorted))
+	if len(sorted) > 1 {
+		var sq float64
+		for _, v := range sorted {
+			sq += (v - s.Mean) * (v - s.Mean)
+		}
+		s.Stddev = math.Sqrt(sq / float64(len(sorted)-1))
+	}
+	s.P50 = percentile(sorted, 50)
+	s.P95 = percentile(sorted, 95)
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	return s
+}
+
+// percentile returns the p-th percentile of sorted, by nearest rank.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Benchmark runs f, which must loop over its iterations with B.Loop, for
+// the given number of iterations. The result is logged and recorded in the
+// reports, also when the benchmark fails part way.
+func (t *H) Benchmark(iterations int, f func(b *B)) {
+	if iterations < 1 {
+		iterations = 1
+	}
+	b := &B{
+		H:       t,
+		N:       iterations,
+		metrics: make(map[string][]float64),
+	}
+	defer func() {
+		b.endIteration()
+		result := b.result()
+		t.SetDetail("benchmark", result)
+		t.Logf("benchmark: %s", result)
+		if b.i < b.N && !t.Failed() && !t.Skipped() {
+			t.Errorf("benchmark ran %d of %d iterations", b.i, b.N)
+		}
+	}()
+	f(b)
+}
+
+// Loop reports whether there are iterations left to run, ending the
+// previous iteration and starting the timer for the next one:
+//
+//	for b.Loop() {
+//		// timed code
+//	}
+//
+// Code before the first call, e.g. setup, isn't timed.
+func (b *B) Loop() bool {
+	b.endIteration()
+	if b.i >= b.N {
+		return false
+	}
+	b.i++
+	b.iterMetrics = make(map[string]float64)
+	b.elapsed = 0
+	b.StartTimer()
+	return true
+}
+
+// endIteration records the sample and the metrics of the running
+// iteration, if any.
+func (b *B) endIteration() {
+	if b.iterMetrics == nil {
+		return
+	}
+	b.StopTimer()
+	b.samples = append(b.samples, b.elapsed.Seconds())
+	for unit, v := range b.iterMetrics {
+		b.metrics[unit] = append(b.metrics[unit], v)
+	}
+	b.iterMetrics = nil
+}
+
+// StartTimer starts timing the iteration; Loop starts the timer already.
+func (b *B) StartTimer() {
+	if !b.timerOn {
+		b.start = time.Now()
+		b.timerOn = true
+	}
+}
+
+// StopTimer stops timing the iteration, e.g. to leave out checking the
+// outcome of the timed operation.
+func (b *B) StopTimer() {
+	if b.timerOn {
+		b.elapsed += time.Since(b.start)
+		b.timerOn = false
+	}
+}
+
+// ResetTimer discards the time of the iteration measured so far.
+func (b *B) ResetTimer() {
+	if b.timerOn {
+		b.start = time.Now()
+	}
+	b.elapsed = 0
+}
+
+// ReportMetric records n for unit, e.g. "boot-sec" or "MB/s", in the
+// current iteration; reporting the same unit again in an iteration
+// replaces the value. Metrics reported outside Loop are recorded as is.
+func (b *B) ReportMetric(n float64, unit string) {
+	if b.iterMetrics != nil {
+		b.iterMetrics[unit] = n
+	} else {
+		b.metrics[unit] = append(b.metrics[unit], n)
+	}
+}
+
+func (b *B) result() BenchmarkResult {
+	r := BenchmarkResult{
+		Iterations: len(b.samples),
+		Duration:   NewBenchmarkStats("sec", b.samples),
+	}
+	if len(b.metrics) > 0 {
+		r.Metrics = make(map[string]BenchmarkStats)
+		for unit, samples := range b.metrics {
+			r.Metrics[unit] = NewBenchmarkStats(unit, samples)
+		}
+	}
+	return r
+}
+
+func (r BenchmarkResult) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d iterations, %s", r.Iterations, r.Duration)
+	units := make([]string, 0, len(r.Metrics))
+	for unit := range r.Metrics {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+	for _, unit := range units {
+		fmt.Fprintf(&buf, "; %s", r.Metrics[unit])
+	}
+	return buf.String()
+}
+
+func (s BenchmarkStats) String() string {
+	return fmt.Sprintf("%s mean %.3g p50 %.3g p95 %.3g stddev %.3g", s.Unit, s.Mean, s.P50, s.P95, s.Stddev)
+}
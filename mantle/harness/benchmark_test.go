@@ -0,0 +1,82 @@
+package harness
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+)
+
+func TestBenchmarkStats(t *testing.T) {
+	s := NewBenchmarkStats("sec", []float64{5, 1, 4, 2, 3})
+	expect := BenchmarkStats{
+		Unit:    "sec",
+		Samples: []float64{5, 1, 4, 2, 3},
+		Mean:    3,
+		P50:     3,
+		P95:     5,
+		Stddev:  1.5811388300841898,
+		Min:     1,
+		Max:     5,
+	}
+	if !reflect.DeepEqual(s, expect) {
+		t.Errorf("got %+v wanted %+v", s, expect)
+	}
+
+	if s := NewBenchmarkStats("sec", nil); s.Mean != 0 || s.Stddev != 0 {
+		t.Errorf("stats of no samples: %+v", s)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	rep := detailsReporter{}
+	suite := NewSuite(Options{
+		OutputDir: filepath.Join(t.TempDir(), "_test_temp"),
+		Reporters: reporters.Reporters{rep},
+	}, Tests{
+		"bench": &HarnessTest{
+			run: func(h *H) {
+				h.Benchmark(3, func(b *B) {
+					n := 0.0
+					for b.Loop() {
+						n++
+						b.ReportMetric(n, "count")
+						b.ReportMetric(n*2, "count")
+					}
+				})
+			},
+			timeout: DefaultTimeoutFlag,
+		},
+		"short": &HarnessTest{
+			run: func(h *H) {
+				h.Benchmark(3, func(b *B) {
+					b.Loop()
+				})
+			},
+			timeout: DefaultTimeoutFlag,
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Log("\n" + buf.String())
+		t.Fatalf("got %v, want %v", err, SuiteFailed)
+	}
+
+	result, ok := rep["bench"]["benchmark"].(BenchmarkResult)
+	if !ok {
+		t.Fatalf("no benchmark result in %v", rep["bench"])
+	}
+	if result.Iterations != 3 || len(result.Duration.Samples) != 3 {
+		t.Errorf("got %d iterations, wanted 3", result.Iterations)
+	}
+	if samples := result.Metrics["count"].Samples; !reflect.DeepEqual(samples, []float64{2, 4, 6}) {
+		t.Errorf("got metric samples %v, wanted [2 4 6]", samples)
+	}
+
+	if result, ok := rep["short"]["benchmark"].(BenchmarkResult); !ok || result.Iterations != 1 {
+		t.Errorf("short benchmark: got %v", rep["short"])
+	}
+}
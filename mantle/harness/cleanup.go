@@ -0,0 +1,103 @@
+package harness
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// defaultCleanupTimeout is how long each cleanup function may run if
+// Options.CleanupTimeout isn't set.
+const defaultCleanupTimeout = 5 * time.Minute
+
+type cleanup struct {
+	f      func() error
+	caller string // where the cleanup was registered
+}
+
+// Cleanup registers f to be called when the test and all its subtests
+// complete, also if the test stopped with FailNow or timed out. Cleanup
+// functions run last registered first, each limited to
+// Options.CleanupTimeout; one which panics or doesn't finish in time is
+// reported as a warning and doesn't fail the test.
+func (c *H) Cleanup(f func()) {
+	c.addCleanup(func() error {
+		f()
+		return nil
+	})
+}
+
+// CleanupErr is like Cleanup, with the error returned by f reported as a
+// warning.
+func (c *H) CleanupErr(f func() error) {
+	c.addCleanup(f)
+}
+
+func (c *H) addCleanup(f func() error) {
+	caller := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanups = append(c.cleanups, cleanup{f: f, caller: caller})
+}
+
+// RunCleanups runs the cleanup functions registered so far, e.g. before
+// tearing down what they use. It's called when the test completes.
+func (c *H) RunCleanups() {
+	for {
+		c.mu.Lock()
+		n := len(c.cleanups)
+		if n == 0 {
+			c.mu.Unlock()
+			return
+		}
+		cl := c.cleanups[n-1]
+		c.cleanups = c.cleanups[:n-1]
+		c.mu.Unlock()
+
+		if err := c.runCleanup(cl); err != nil {
+			c.cleanupWarning(fmt.Sprintf("cleanup registered at %s: %v", cl.caller, err))
+		}
+	}
+}
+
+// runCleanup runs a cleanup function in its own goroutine so it can be
+// abandoned if it hangs.
+func (c *H) runCleanup(cl cleanup) error {
+	done := make(chan error, 1)
+	go func() {
+		finished := false
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			} else if !finished {
+				// FailNow or SkipNow; the test's state already says so
+				done <- nil
+			}
+		}()
+		err := cl.f()
+		finished = true
+		done <- err
+	}()
+
+	timeout := c.suite.opts.CleanupTimeout
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("didn't finish within %v, abandoned it", timeout)
+	}
+}
+
+func (c *H) cleanupWarning(msg string) {
+	c.Logf("WARNING: %s", msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanupWarnings = append(c.cleanupWarnings, msg)
+	if c.details == nil {
+		c.details = make(map[string]interface{})
+	}
+	c.details["cleanupWarnings"] = c.cleanupWarnings
+}
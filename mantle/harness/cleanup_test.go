@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+)
+
+func TestCleanup(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, s)
+		}
+	}
+	block := make(chan struct{})
+	defer close(block)
+
+	rep := detailsReporter{}
+	suite := NewSuite(Options{
+		OutputDir:      filepath.Join(t.TempDir(), "_test_temp"),
+		CleanupTimeout: 100 * time.Millisecond,
+		Reporters:      reporters.Reporters{rep},
+	}, Tests{
+		"fatal": &HarnessTest{
+			run: func(h *H) {
+				h.Cleanup(record("first"))
+				h.Run("sub", func(h *H) {
+					h.Cleanup(record("sub"))
+				})
+				h.Cleanup(record("second"))
+				h.FailNow()
+			},
+			timeout: DefaultTimeoutFlag,
+		},
+		"warnings": &HarnessTest{
+			run: func(h *H) {
+				h.Cleanup(func() { <-block })
+				h.Cleanup(func() { panic("oops") })
+				h.CleanupErr(func() error { return errors.New("failed") })
+			},
+			timeout: DefaultTimeoutFlag,
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Log("\n" + buf.String())
+		t.Fatalf("got %v, want %v", err, SuiteFailed)
+	}
+
+	if expect := []string{"sub", "second", "first"}; !reflect.DeepEqual(order, expect) {
+		t.Errorf("cleanups ran in order %v, want %v", order, expect)
+	}
+	warnings, _ := rep["warnings"]["cleanupWarnings"].([]string)
+	if len(warnings) != 3 {
+		t.Errorf("got cleanup warnings %v, want 3", warnings)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("--- FAIL: warnings")) {
+		t.Errorf("cleanup warnings failed the test:\n%s", buf.String())
+	}
+}
@@ -47,7 +47,7 @@ const defaultTimeout = 10 * time.Minute
 // The other reporting methods, such as the variations of Log and Error,
 // may be called simultaneously from multiple goroutines.
 type H struct {
-	mu       sync.RWMutex // guards output, failed, and done.
+	mu       sync.RWMutex // guards output, failed, done, start and duration.
 	output   bytes.Buffer // Output generated by test.
 	w        io.Writer    // For flushToParent.
 	tap      io.Writer    // Optional TAP log of test results.
@@ -77,6 +77,11 @@ type H struct {
 	isParallel               bool
 	nonExclusiveTestsStarted bool
 	warningOnFailure         bool
+	interrupted              bool      // Test was interrupted by a signal before it finished, guarded by mu
+	resources                Resources // Host resources reserved via AcquireResources
+	locks                    []string  // Named locks held via AcquireLocks
+	cleanups                 []cleanup // Registered via Cleanup, guarded by mu
+	cleanupWarnings          []string  // Failed cleanups, guarded by mu
 
 	timeout   time.Duration // Duration for which the test will be allowed to run
 	timedout  bool          // A timeout was reached
@@ -85,6 +90,7 @@ type H struct {
 	timeoutContext context.Context
 
 	reporters reporters.Reporters
+	details   map[string]interface{} // Extra data for reporters, guarded by mu
 }
 
 // Run f so that it times out if needed, output errMsg in case of timeout
@@ -93,20 +99,39 @@ func (t *H) runTimeoutCheck(ctx context.Context, timeout time.Duration, f func()
 		panic("context not initialized (was StartExecTimer called?)")
 	}
 
-	ioCompleted := make(chan bool)
+	// buffered so f's goroutine can exit if we stopped waiting for it
+	ioCompleted := make(chan bool, 1)
 	go func() {
 		f()
 		ioCompleted <- true
 	}()
 
+	var interrupted <-chan struct{}
+	if t.suite != nil {
+		interrupted = t.suite.ctx.Done()
+	}
+
 	// Timeout if call to function f takes too long
-	select {
-	case <-ctx.Done():
-		t.timedout = true
-		t.Fatalf("TIMEOUT[%v]: %s\n", timeout, errMsg)
-	case <-ioCompleted:
-		// Finish the test
-		return
+	for {
+		select {
+		case <-ctx.Done():
+			t.timedout = true
+			t.Fatalf("TIMEOUT[%v]: %s\n", timeout, errMsg)
+		case <-interrupted:
+			// As for timeouts, tests running subtests leave it
+			// to the subtests.
+			t.subLock.RLock()
+			hasSub := t.hasSub
+			t.subLock.RUnlock()
+			if !hasSub {
+				t.setInterrupted()
+				t.Fatalf("INTERRUPTED: %s\n", errMsg)
+			}
+			interrupted = nil
+		case <-ioCompleted:
+			// Finish the test
+			return
+		}
 	}
 }
 
@@ -119,6 +144,8 @@ func (t *H) runTimeoutCheck(ctx context.Context, timeout time.Duration, f func()
 func (t *H) Release() {
 	if !t.released {
 		t.released = true
+		t.suite.releaseResources(t.resources)
+		t.suite.releaseLocks(t.locks)
 		t.suite.release()
 	}
 }
@@ -155,6 +182,9 @@ func (t *H) StopExecTimer() {
 }
 
 func (c *H) parentContext() context.Context {
+	if c != nil && c.parent == nil && c.suite != nil && c.suite.ctx != nil {
+		return c.suite.ctx
+	}
 	if c == nil || c.parent == nil || c.parent.ctx == nil {
 		return context.Background()
 	}
@@ -167,6 +197,10 @@ func (h *H) Verbose() bool {
 }
 
 func (c *H) status() testresult.TestResult {
+	// tests which got to pass despite the interruption keep their result
+	if c.Interrupted() && (c.Failed() || c.Skipped()) {
+		return testresult.Interrupted
+	}
 	if c.Failed() {
 		if c.warningOnFailure {
 			return testresult.Warn
@@ -194,6 +228,8 @@ func (c *H) flushToParent(format string, args ...interface{}) {
 		name := strings.Replace(c.name, "#", "", -1)
 		if status == testresult.Fail {
 			fmt.Fprintf(p.tap, "not ok - %s\n", name)
+		} else if status == testresult.Interrupted {
+			fmt.Fprintf(p.tap, "not ok - %s # INTERRUPTED\n", name)
 		} else if status == testresult.Skip {
 			fmt.Fprintf(p.tap, "ok - %s # SKIP\n", name)
 		} else {
@@ -263,6 +299,18 @@ func (c *H) Context() context.Context {
 	return c.ctx
 }
 
+// SetDetail records structured data about the test under key, which
+// reporters include alongside the test's result and output. value must
+// be serializable to JSON. Setting a key again replaces its value.
+func (c *H) SetDetail(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.details == nil {
+		c.details = make(map[string]interface{})
+	}
+	c.details[key] = value
+}
+
 func (c *H) setRan() {
 	if c.parent != nil {
 		c.parent.setRan()
@@ -486,7 +534,7 @@ func (t *H) Parallel() {
 	// We don't want to include the time we spend waiting for serial tests
 	// in the test duration. Record the elapsed time thus far and reset the
 	// timer afterwards. We will also reset any timeouts.
-	t.duration += time.Since(t.start)
+	t.stopTimer()
 
 	// Add to the list of tests to be released by the parent.
 	t.parent.sub = append(t.parent.sub, t)
@@ -494,6 +542,22 @@ func (t *H) Parallel() {
 	t.signal <- true   // Release calling test.
 	<-t.parent.barrier // Wait for the parent test to complete.
 	t.suite.waitParallel()
+	t.startTimer()
+	t.checkInterrupted()
+}
+
+// stopTimer adds the time since the test started or resumed to its
+// duration.
+func (t *H) stopTimer() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.duration += time.Since(t.start)
+}
+
+// startTimer starts or resumes counting the duration of the test.
+func (t *H) startTimer() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.start = time.Now()
 }
 
@@ -506,7 +570,7 @@ func tRunner(t *H, fn func(t *H)) {
 	// a call to runtime.Goexit, record the duration and send
 	// a signal saying that the test is done.
 	defer func() {
-		t.duration += time.Since(t.start)
+		t.stopTimer()
 		// If the test panicked, print any test output before dying.
 		err := recover()
 
@@ -515,6 +579,7 @@ func tRunner(t *H, fn func(t *H)) {
 		}
 		if err != nil {
 			t.Fail()
+			t.RunCleanups()
 			t.report()
 			panic(err)
 		}
@@ -535,16 +600,23 @@ func tRunner(t *H, fn func(t *H)) {
 			for _, sub := range t.sub {
 				<-sub.signal
 			}
+			t.RunCleanups()
 			if !t.isParallel {
 				// Reacquire the count for sequential tests. See comment in Run.
 				t.suite.waitParallel()
 			}
-		} else if t.isParallel {
-			// Only release the count for this test if it was run as a parallel
-			// test. See comment in Run method.
-			t.Release()
+		} else {
+			t.RunCleanups()
+			if t.isParallel {
+				// Only release the count for this test if it was run as a parallel
+				// test. See comment in Run method.
+				t.Release()
+			}
 		}
 		t.report() // Report after all subtests have finished.
+		if t.parent != nil {
+			t.suite.removeActive(t)
+		}
 
 		// Do not lock t.done to allow race detector to detect race in case
 		// the user does not appropriately synchronize a goroutine.
@@ -557,7 +629,11 @@ func tRunner(t *H, fn func(t *H)) {
 		t.signal <- true
 	}()
 
-	t.start = time.Now()
+	t.startTimer()
+	if t.parent != nil {
+		t.suite.addActive(t)
+		t.checkInterrupted()
+	}
 	fn(t)
 	t.finished = true
 
@@ -642,7 +718,10 @@ func (t *H) report() {
 	t.subLock.Lock()
 	subtests := t.subtests
 	t.subLock.Unlock()
-	t.reporters.ReportTest(t.name, subtests, status, t.duration, t.output.Bytes())
+	t.mu.RLock()
+	details := t.details
+	t.mu.RUnlock()
+	t.reporters.ReportTest(t.name, subtests, status, t.duration, t.output.Bytes(), details)
 }
 
 // CleanOutputDir creates/empties an output directory and returns the cleaned path.
@@ -27,6 +27,9 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
 )
 
 func TestMain(m *testing.M) {
@@ -490,3 +493,45 @@ func TestTempFile(t *testing.T) {
 		t.Errorf("%q missing %q prefix", second, "second")
 	}
 }
+
+type detailsReporter map[string]map[string]interface{}
+
+func (r detailsReporter) ReportTest(name string, subtests []string, result testresult.TestResult, duration time.Duration, b []byte, details map[string]interface{}) {
+	r[name] = details
+}
+func (r detailsReporter) Output(string) error             { return nil }
+func (r detailsReporter) SetResult(testresult.TestResult) {}
+
+func TestSetDetail(t *testing.T) {
+	rep := detailsReporter{}
+	suite := NewSuite(Options{
+		OutputDir: filepath.Join(t.TempDir(), "_test_temp"),
+		Reporters: reporters.Reporters{rep},
+	}, Tests{
+		"detail": &HarnessTest{
+			run: func(h *H) {
+				h.SetDetail("a", 1)
+				h.SetDetail("b", "x")
+				h.SetDetail("a", 2)
+			},
+			timeout: DefaultTimeoutFlag,
+		},
+		"nodetail": &HarnessTest{
+			run:     func(h *H) {},
+			timeout: DefaultTimeoutFlag,
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatal(err)
+	}
+
+	if expect := map[string]interface{}{"a": 2, "b": "x"}; !reflect.DeepEqual(rep["detail"], expect) {
+		t.Errorf("details %v != %v", rep["detail"], expect)
+	}
+	if rep["nodetail"] != nil {
+		t.Errorf("unexpected details %v", rep["nodetail"])
+	}
+}
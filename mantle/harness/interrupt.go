@@ -0,0 +1,147 @@
+package harness
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+// defaultGracePeriod is how long tests get to tear down after the suite
+// is interrupted if Options.GracePeriod isn't set.
+const defaultGracePeriod = 2 * time.Minute
+
+// handleSignals interrupts the suite on SIGINT or SIGTERM: the context of
+// all tests is cancelled, tests which haven't started yet don't run, and
+// running tests get the grace period to tear down. If they don't finish
+// in time, or on a second signal, the tests still running are reported as
+// interrupted, the reports are written and the process exits. The
+// returned function stops handling signals.
+func (s *Suite) handleSignals(tap io.Writer, writeReports func()) (stop func()) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-sigs:
+		case <-done:
+			return
+		}
+		fmt.Fprintf(os.Stderr, "harness: received %v; interrupting tests and waiting up to %v for them to tear down (signal again to exit now)\n", sig, s.opts.GracePeriod)
+		s.interrupt()
+
+		select {
+		case sig = <-sigs:
+			fmt.Fprintf(os.Stderr, "harness: received %v again; exiting\n", sig)
+		case <-time.After(s.opts.GracePeriod):
+			fmt.Fprintf(os.Stderr, "harness: tests didn't tear down within %v; exiting\n", s.opts.GracePeriod)
+		case <-done:
+			return
+		}
+		s.reportActive(tap)
+		s.opts.Reporters.SetResult(testresult.Interrupted)
+		writeReports()
+		code := 1
+		if sig, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(sig)
+		}
+		os.Exit(code)
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// interrupt cancels the suite: tests still running are marked as
+// interrupted and tests waiting for a parallel slot or resources are
+// woken up so they can give up.
+func (s *Suite) interrupt() {
+	s.interrupted.Store(true)
+	s.activeMu.Lock()
+	for h := range s.active {
+		h.setInterrupted()
+	}
+	s.activeMu.Unlock()
+	s.cancel()
+
+	s.resMu.Lock()
+	s.resMu.Unlock()
+	s.resCond.Broadcast()
+}
+
+// Interrupted reports whether the suite was interrupted by a signal.
+func (s *Suite) Interrupted() bool {
+	return s.interrupted.Load()
+}
+
+func (s *Suite) addActive(h *H) {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if s.active == nil {
+		s.active = make(map[*H]bool)
+	}
+	s.active[h] = true
+}
+
+func (s *Suite) removeActive(h *H) {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	delete(s.active, h)
+}
+
+// reportActive reports the tests which haven't finished as interrupted,
+// with the output they produced so far.
+func (s *Suite) reportActive(tap io.Writer) {
+	s.activeMu.Lock()
+	var active []*H
+	for h := range s.active {
+		active = append(active, h)
+	}
+	s.activeMu.Unlock()
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].name < active[j].name
+	})
+
+	for _, h := range active {
+		h.mu.RLock()
+		output := append([]byte(nil), h.output.Bytes()...)
+		details := h.details
+		duration := h.duration + time.Since(h.start)
+		h.mu.RUnlock()
+		fmt.Printf("--- %s: %s (%s)\n", testresult.Interrupted.Display(), h.name, fmtDuration(duration))
+		if tap != nil && h.level == 1 {
+			fmt.Fprintf(tap, "not ok - %s # INTERRUPTED\n", h.name)
+		}
+		h.reporters.ReportTest(h.name, h.Subtests(), testresult.Interrupted, duration, output, details)
+	}
+}
+
+// checkInterrupted ends a test which is about to start if the suite has
+// been interrupted.
+func (t *H) checkInterrupted() {
+	if t.suite.Interrupted() {
+		t.setInterrupted()
+		t.log("harness: not running test, the suite was interrupted\n")
+		t.SkipNow()
+	}
+}
+
+func (c *H) setInterrupted() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interrupted = true
+}
+
+// Interrupted reports whether the test was interrupted before it finished.
+func (c *H) Interrupted() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.interrupted
+}
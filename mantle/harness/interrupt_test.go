@@ -0,0 +1,149 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+type resultReporter struct {
+	mu      sync.Mutex
+	results map[string]testresult.TestResult
+	result  testresult.TestResult
+}
+
+func (r *resultReporter) ReportTest(name string, subtests []string, result testresult.TestResult, duration time.Duration, b []byte, details map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[name] = result
+}
+func (r *resultReporter) Output(string) error                    { return nil }
+func (r *resultReporter) SetResult(result testresult.TestResult) { r.result = result }
+
+func TestInterrupt(t *testing.T) {
+	// With one parallel slot, the first test to start waits until the
+	// suite is interrupted and the second one must not run.
+	started := make(chan struct{})
+	var once sync.Once
+	run := func(h *H) {
+		h.Parallel()
+		first := false
+		once.Do(func() { first = true })
+		if !first {
+			t.Errorf("%s ran after the suite was interrupted", h.Name())
+			return
+		}
+		close(started)
+		h.RunWithExecTimeoutCheck(func() {
+			<-h.Context().Done()
+		}, "waiting for the context")
+	}
+	tests := Tests{}
+	tests.Add("test1", run, DefaultTimeoutFlag)
+	tests.Add("test2", run, DefaultTimeoutFlag)
+
+	rep := &resultReporter{results: make(map[string]testresult.TestResult)}
+	suite := NewSuite(Options{Parallel: 1, Reporters: reporters.Reporters{rep}}, tests)
+	go func() {
+		<-started
+		suite.interrupt()
+	}()
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteInterrupted {
+		t.Log("\n" + buf.String())
+		t.Fatalf("got %v, want %v", err, SuiteInterrupted)
+	}
+	for _, name := range []string{"test1", "test2"} {
+		if got := rep.results[name]; got != testresult.Interrupted {
+			t.Errorf("%s: got %v, want %v", name, got, testresult.Interrupted)
+		}
+	}
+	if rep.result != testresult.Interrupted {
+		t.Errorf("suite: got %v, want %v", rep.result, testresult.Interrupted)
+	}
+}
+
+func TestInterruptWaitingTests(t *testing.T) {
+	// The first test to get the lock holds it until the suite is
+	// interrupted; the others resume from waiting for it while the suite
+	// reports them.
+	started := make(chan struct{})
+	var once sync.Once
+	run := func(h *H) {
+		h.Parallel()
+		h.AcquireLocks("disk")
+		first := false
+		once.Do(func() { first = true })
+		if !first {
+			t.Errorf("%s got the lock after the suite was interrupted", h.Name())
+			return
+		}
+		close(started)
+		h.RunWithExecTimeoutCheck(func() {
+			<-h.Context().Done()
+		}, "waiting for the context")
+	}
+	names := []string{"test1", "test2", "test3", "test4"}
+	tests := Tests{}
+	for _, name := range names {
+		tests.Add(name, run, DefaultTimeoutFlag)
+	}
+
+	rep := &resultReporter{results: make(map[string]testresult.TestResult)}
+	suite := NewSuite(Options{Parallel: len(names), Reporters: reporters.Reporters{rep}}, tests)
+	go func() {
+		<-started
+		suite.interrupt()
+	}()
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteInterrupted {
+		t.Log("\n" + buf.String())
+		t.Fatalf("got %v, want %v", err, SuiteInterrupted)
+	}
+	for _, name := range names {
+		if got := rep.results[name]; got != testresult.Interrupted {
+			t.Errorf("%s: got %v, want %v", name, got, testresult.Interrupted)
+		}
+	}
+}
+
+func TestReportActiveRace(t *testing.T) {
+	// Tests start, wait for a lock and finish while the suite reports
+	// the active ones, as after the grace period; run with -race.
+	tests := Tests{}
+	for i := 0; i < 8; i++ {
+		tests.Add(fmt.Sprintf("test%d", i), func(h *H) {
+			h.Parallel()
+			h.AcquireLocks("disk")
+			time.Sleep(time.Millisecond)
+		}, DefaultTimeoutFlag)
+	}
+	rep := &resultReporter{results: make(map[string]testresult.TestResult)}
+	suite := NewSuite(Options{Parallel: 2, Reporters: reporters.Reporters{rep}}, tests)
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				suite.reportActive(nil)
+			}
+		}
+	}()
+	buf := &bytes.Buffer{}
+	err := suite.runTests(buf, nil)
+	close(done)
+	<-reported
+	if err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package harness
+
+import (
+	"sort"
+	"strings"
+)
+
+// lockHolders returns the tests holding any of names, which must be called
+// with resMu held.
+func (c *Suite) lockHolders(names []string) []string {
+	var holders []string
+	for _, name := range names {
+		if h, ok := c.locks[name]; ok {
+			holders = append(holders, name+" (held by "+h.name+")")
+		}
+	}
+	return holders
+}
+
+// takeLocks takes names for t, which must be called with resMu held.
+func (c *Suite) takeLocks(t *H, names []string) {
+	if c.locks == nil {
+		c.locks = make(map[string]*H)
+	}
+	for _, name := range names {
+		c.locks[name] = t
+	}
+}
+
+// tryAcquireLocks takes names if none of them is held and returns whether
+// it did.
+func (c *Suite) tryAcquireLocks(t *H, names []string) bool {
+	c.resMu.Lock()
+	defer c.resMu.Unlock()
+	if len(c.lockHolders(names)) > 0 {
+		return false
+	}
+	c.takeLocks(t, names)
+	return true
+}
+
+// acquireLocks waits until none of names is held and takes them all at
+// once, so tests taking several locks can't deadlock. It returns false
+// without taking anything if the suite is interrupted.
+func (c *Suite) acquireLocks(t *H, names []string) bool {
+	c.resMu.Lock()
+	defer c.resMu.Unlock()
+	if holders := c.lockHolders(names); len(holders) > 0 {
+		t.Logf("waiting for locks %s", strings.Join(holders, ", "))
+	}
+	for len(c.lockHolders(names)) > 0 {
+		if c.Interrupted() {
+			return false
+		}
+		c.resCond.Wait()
+	}
+	c.takeLocks(t, names)
+	return true
+}
+
+func (c *Suite) releaseLocks(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	c.resMu.Lock()
+	for _, name := range names {
+		delete(c.locks, name)
+	}
+	c.resMu.Unlock()
+	c.resCond.Broadcast()
+}
+
+// AcquireLocks blocks until none of the named locks is held by another
+// test and holds them until the test releases its parallel slot, so tests
+// sharing a lock never run at the same time while other tests still run
+// in parallel. While waiting, the test gives up its parallel slot and
+// takes one again once it has the locks. It must be called after
+// Parallel, before AcquireResources, and at most once.
+func (t *H) AcquireLocks(names ...string) {
+	if !t.isParallel {
+		panic("harness: AcquireLocks called before Parallel")
+	}
+	if t.locks != nil {
+		panic("harness: AcquireLocks called multiple times")
+	}
+	if !t.resources.IsZero() {
+		panic("harness: AcquireLocks called after AcquireResources")
+	}
+	if len(names) == 0 {
+		return
+	}
+	// deduplicate, a test may list a lock twice
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	var unique []string
+	for i, name := range sorted {
+		if i == 0 || name != sorted[i-1] {
+			unique = append(unique, name)
+		}
+	}
+
+	// As in Parallel, time spent waiting for other tests to finish
+	// doesn't count towards this test's duration.
+	t.stopTimer()
+	if t.suite.tryAcquireLocks(t, unique) {
+		t.locks = unique
+	} else {
+		// Tests queued on a lock would otherwise keep the slots of
+		// tests which could run.
+		t.suite.release()
+		if t.suite.acquireLocks(t, unique) {
+			t.locks = unique
+		}
+		t.suite.waitParallel()
+	}
+	t.startTimer()
+	t.checkInterrupted()
+}
@@ -0,0 +1,95 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLocks(t *testing.T) {
+	var mu sync.Mutex
+	holding := make(map[string]int)
+	peak := make(map[string]int)
+	tests := Tests{}
+	for i := 0; i < 6; i++ {
+		// tests share "a" or "b"; test0 takes both
+		locks := []string{"a"}
+		if i%2 == 1 {
+			locks = []string{"b", "b"}
+		}
+		if i == 0 {
+			locks = []string{"b", "a"}
+		}
+		tests.Add(fmt.Sprintf("test%d", i), func(h *H) {
+			h.Parallel()
+			h.AcquireLocks(locks...)
+			held := map[string]bool{}
+			for _, l := range locks {
+				held[l] = true
+			}
+			mu.Lock()
+			for l := range held {
+				holding[l]++
+				if holding[l] > peak[l] {
+					peak[l] = holding[l]
+				}
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			for l := range held {
+				holding[l]--
+			}
+			mu.Unlock()
+		}, DefaultTimeoutFlag)
+	}
+
+	suite := NewSuite(Options{Parallel: 6}, tests)
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatal(err)
+	}
+	for _, l := range []string{"a", "b"} {
+		if peak[l] != 1 {
+			t.Errorf("lock %s was held by %d tests at once", l, peak[l])
+		}
+	}
+	if len(suite.locks) != 0 {
+		t.Errorf("locks %v still held after the suite finished", suite.locks)
+	}
+}
+
+func TestAcquireLocksReleasesSlot(t *testing.T) {
+	// With two slots, the tests waiting for the lock held by another
+	// must let free run. Which tests get the slots first varies, so try
+	// a few times.
+	for run := 0; run < 10; run++ {
+		started := make(chan struct{})
+		tests := Tests{}
+		tests.Add("free", func(h *H) {
+			h.Parallel()
+			close(started)
+		}, DefaultTimeoutFlag)
+		for i := 0; i < 4; i++ {
+			tests.Add(fmt.Sprintf("locked%d", i), func(h *H) {
+				h.Parallel()
+				h.AcquireLocks("a")
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					h.Errorf("free didn't start while holding the lock")
+				}
+			}, DefaultTimeoutFlag)
+		}
+
+		suite := NewSuite(Options{Parallel: 2}, tests)
+		buf := &bytes.Buffer{}
+		if err := suite.runTests(buf, nil); err != nil {
+			t.Log("\n" + buf.String())
+			t.Fatal(err)
+		}
+	}
+}
@@ -33,6 +33,9 @@ type jsonReporter struct {
 	// Context variables
 	Platform string `json:"platform"`
 	Version  string `json:"version"`
+	// Provenance describes how the run was invoked and what it ran
+	// against, see SetProvenance
+	Provenance json.RawMessage `json:"provenance,omitempty"`
 
 	mutex sync.Mutex
 }
@@ -43,6 +46,8 @@ type jsonTest struct {
 	Result   testresult.TestResult `json:"result"`
 	Duration time.Duration         `json:"duration"`
 	Output   string                `json:"output"`
+	// Details holds structured data recorded by the test, see H.SetDetail
+	Details map[string]interface{} `json:"details,omitempty"`
 }
 
 func DeserialiseReport(filename string) (*jsonReporter, error) {
@@ -71,7 +76,7 @@ func NewJSONReporter(filename, platform, version string) *jsonReporter {
 	}
 }
 
-func (r *jsonReporter) ReportTest(name string, subtests []string, result testresult.TestResult, duration time.Duration, b []byte) {
+func (r *jsonReporter) ReportTest(name string, subtests []string, result testresult.TestResult, duration time.Duration, b []byte, details map[string]interface{}) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
@@ -81,6 +86,7 @@ func (r *jsonReporter) ReportTest(name string, subtests []string, result testres
 		Result:   result,
 		Duration: duration,
 		Output:   string(b),
+		Details:  details,
 	})
 }
 
@@ -94,6 +100,18 @@ func (r *jsonReporter) Output(path string) error {
 	return json.NewEncoder(f).Encode(r)
 }
 
+// SetProvenance stores p, which must marshal to JSON, in the report.
+func (r *jsonReporter) SetProvenance(p interface{}) error {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Provenance = buf
+	return nil
+}
+
 func (r *jsonReporter) SetResult(result testresult.TestResult) {
 	r.Result = result
 }
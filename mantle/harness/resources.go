@@ -0,0 +1,135 @@
+package harness
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resources describes host resources used by a test, or the total amount
+// a Suite may hand out to tests running in parallel. For a test a zero
+// field means nothing is required, for a budget it means unlimited.
+type Resources struct {
+	MemoryMiB int
+	CPUs      int
+	DiskGiB   int
+}
+
+// IsZero reports whether no resource is set.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+func (r Resources) String() string {
+	var parts []string
+	if r.MemoryMiB > 0 {
+		parts = append(parts, fmt.Sprintf("memory=%dM", r.MemoryMiB))
+	}
+	if r.CPUs > 0 {
+		parts = append(parts, fmt.Sprintf("cpus=%d", r.CPUs))
+	}
+	if r.DiskGiB > 0 {
+		parts = append(parts, fmt.Sprintf("disk=%dG", r.DiskGiB))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r Resources) add(o Resources) Resources {
+	return Resources{
+		MemoryMiB: r.MemoryMiB + o.MemoryMiB,
+		CPUs:      r.CPUs + o.CPUs,
+		DiskGiB:   r.DiskGiB + o.DiskGiB,
+	}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{
+		MemoryMiB: r.MemoryMiB - o.MemoryMiB,
+		CPUs:      r.CPUs - o.CPUs,
+		DiskGiB:   r.DiskGiB - o.DiskGiB,
+	}
+}
+
+// fits reports whether req can be added to inuse without exceeding
+// the budget r.
+func (r Resources) fits(inuse, req Resources) bool {
+	within := func(limit, used, want int) bool {
+		return limit <= 0 || used+want <= limit
+	}
+	return within(r.MemoryMiB, inuse.MemoryMiB, req.MemoryMiB) &&
+		within(r.CPUs, inuse.CPUs, req.CPUs) &&
+		within(r.DiskGiB, inuse.DiskGiB, req.DiskGiB)
+}
+
+// clamp caps req to the budget r so that a test asking for more than
+// the whole budget can still run, alone.
+func (r Resources) clamp(req Resources) Resources {
+	min := func(limit, want int) int {
+		if limit > 0 && want > limit {
+			return limit
+		}
+		return want
+	}
+	return Resources{
+		MemoryMiB: min(r.MemoryMiB, req.MemoryMiB),
+		CPUs:      min(r.CPUs, req.CPUs),
+		DiskGiB:   min(r.DiskGiB, req.DiskGiB),
+	}
+}
+
+// acquireResources waits for req to fit in the budget and reserves it. It
+// returns false without reserving anything if the suite is interrupted.
+func (c *Suite) acquireResources(req Resources) bool {
+	c.resMu.Lock()
+	defer c.resMu.Unlock()
+	for !c.opts.Resources.fits(c.inUse, req) {
+		if c.Interrupted() {
+			return false
+		}
+		c.resCond.Wait()
+	}
+	c.inUse = c.inUse.add(req)
+	return true
+}
+
+func (c *Suite) releaseResources(req Resources) {
+	if req.IsZero() {
+		return
+	}
+	c.resMu.Lock()
+	c.inUse = c.inUse.sub(req)
+	c.resMu.Unlock()
+	c.resCond.Broadcast()
+}
+
+// AcquireResources blocks until req fits in the Suite's resource budget
+// (see Options.Resources) and reserves it until the test releases its
+// parallel slot. It must be called after Parallel and at most once. A
+// request larger than the whole budget is reduced to the budget, so such
+// a test runs once everything else has drained.
+func (t *H) AcquireResources(req Resources) {
+	if !t.isParallel {
+		panic("harness: AcquireResources called before Parallel")
+	}
+	if !t.resources.IsZero() {
+		panic("harness: AcquireResources called multiple times")
+	}
+	if t.suite.opts.Resources.IsZero() || req.IsZero() {
+		return
+	}
+	clamped := t.suite.opts.Resources.clamp(req)
+	if clamped != req {
+		t.Logf("requested resources %v exceed the budget %v; waiting to run alone", req, t.suite.opts.Resources)
+	}
+
+	// As in Parallel, time spent waiting for other tests to finish
+	// doesn't count towards this test's duration.
+	t.stopTimer()
+	if t.suite.acquireResources(clamped) {
+		t.resources = clamped
+	}
+	t.startTimer()
+	t.checkInterrupted()
+}
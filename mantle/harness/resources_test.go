@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResourcesFits(t *testing.T) {
+	budget := Resources{MemoryMiB: 4096, CPUs: 4}
+	for _, tc := range []struct {
+		inuse, req Resources
+		fits       bool
+	}{
+		{Resources{}, Resources{MemoryMiB: 4096, CPUs: 4}, true},
+		{Resources{MemoryMiB: 2048}, Resources{MemoryMiB: 2048}, true},
+		{Resources{MemoryMiB: 2048}, Resources{MemoryMiB: 2049}, false},
+		{Resources{CPUs: 4}, Resources{CPUs: 1}, false},
+		// unlimited disk
+		{Resources{DiskGiB: 1000}, Resources{DiskGiB: 1000}, true},
+	} {
+		if got := budget.fits(tc.inuse, tc.req); got != tc.fits {
+			t.Errorf("fits(%v, %v) = %v, want %v", tc.inuse, tc.req, got, tc.fits)
+		}
+	}
+
+	req := Resources{MemoryMiB: 8192, CPUs: 2, DiskGiB: 10}
+	if got, want := budget.clamp(req), (Resources{MemoryMiB: 4096, CPUs: 2, DiskGiB: 10}); got != want {
+		t.Errorf("clamp(%v) = %v, want %v", req, got, want)
+	}
+}
+
+func TestAcquireResources(t *testing.T) {
+	var running, peak int32
+	tests := Tests{}
+	for i := 0; i < 6; i++ {
+		// one test asks for more than the whole budget
+		req := Resources{MemoryMiB: 1024}
+		if i == 0 {
+			req.MemoryMiB = 8192
+		}
+		tests.Add(fmt.Sprintf("test%d", i), func(h *H) {
+			h.Parallel()
+			h.AcquireResources(req)
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}, DefaultTimeoutFlag)
+	}
+
+	suite := NewSuite(Options{Parallel: 6, Resources: Resources{MemoryMiB: 2048}}, tests)
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatal(err)
+	}
+	if peak > 2 {
+		t.Errorf("%d tests ran concurrently with a budget for 2", peak)
+	}
+	if suite.inUse != (Resources{}) {
+		t.Errorf("resources %v still reserved after the suite finished", suite.inUse)
+	}
+}
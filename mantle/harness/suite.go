@@ -16,6 +16,7 @@
 package harness
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -27,6 +28,7 @@ import (
 	"runtime/trace"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -42,6 +44,9 @@ const (
 var (
 	SuiteEmpty  = errors.New("harness: no tests to run")
 	SuiteFailed = errors.New("harness: test suite failed")
+	// SuiteInterrupted is returned when the suite was interrupted by
+	// SIGINT or SIGTERM.
+	SuiteInterrupted = errors.New("harness: test suite interrupted")
 )
 
 // Options
@@ -75,9 +80,21 @@ type Options struct {
 	// Limit number of tests to run in parallel (0 means GOMAXPROCS).
 	Parallel int
 
+	// Limit host resources reserved by tests running in parallel via
+	// H.AcquireResources (zero fields mean unlimited).
+	Resources Resources
+
 	// Sharding splits tests across runners
 	Sharding string
 
+	// Time tests get to tear down after SIGINT or SIGTERM before
+	// the suite exits (0 means 2 minutes).
+	GracePeriod time.Duration
+
+	// Time each function registered with H.Cleanup may run (0 means
+	// 5 minutes).
+	CleanupTimeout time.Duration
+
 	Reporters reporters.Reporters
 }
 
@@ -127,6 +144,12 @@ func (o *Options) init() {
 	if o.Parallel < 1 {
 		o.Parallel = runtime.GOMAXPROCS(0)
 	}
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = defaultGracePeriod
+	}
+	if o.CleanupTimeout <= 0 {
+		o.CleanupTimeout = defaultCleanupTimeout
+	}
 }
 
 // Suite is a type passed to a TestMain function to run the actual tests.
@@ -149,6 +172,25 @@ type Suite struct {
 
 	// waiting is the number tests waiting to be run in parallel.
 	waiting int
+
+	// resMu protects inUse, the sum of resources reserved by running
+	// tests, and locks, the tests holding each named lock; resCond is
+	// signalled whenever resources or locks are returned.
+	resMu   sync.Mutex
+	resCond *sync.Cond
+	inUse   Resources
+	locks   map[string]*H
+
+	// ctx is the parent of the contexts of all tests; it is cancelled
+	// when the suite is interrupted.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	interrupted atomic.Bool
+
+	// activeMu protects active, the tests which have started but not
+	// reported yet.
+	activeMu sync.Mutex
+	active   map[*H]bool
 }
 
 func (c *Suite) waitParallel() {
@@ -179,12 +221,15 @@ func (c *Suite) release() {
 // All parameters in Options cannot be modified once given to Suite.
 func NewSuite(opts Options, tests Tests) *Suite {
 	opts.init()
-	return &Suite{
+	s := &Suite{
 		opts:          opts,
 		tests:         tests,
 		match:         newMatcher(opts.Match, "Match"),
 		startParallel: make(chan bool),
 	}
+	s.resCond = sync.NewCond(&s.resMu)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
 }
 
 // Run runs the tests. Returns SuiteFailed for any test failure.
@@ -216,11 +261,16 @@ func (s *Suite) Run() (err error) {
 	if err := os.Mkdir(reportDir, 0777); err != nil {
 		return err
 	}
-	defer func() {
-		if reportErr := s.opts.Reporters.Output(reportDir); reportErr != nil && err != nil {
-			err = reportErr
-		}
-	}()
+	var reportOnce sync.Once
+	writeReports := func() {
+		reportOnce.Do(func() {
+			if reportErr := s.opts.Reporters.Output(reportDir); reportErr != nil && err != nil {
+				err = reportErr
+			}
+		})
+	}
+	defer writeReports()
+	defer s.handleSignals(tap, writeReports)()
 
 	if s.opts.MemProfile {
 		runtime.MemProfileRate = s.opts.MemProfileRate
@@ -306,6 +356,10 @@ func (s *Suite) runTests(out, tap io.Writer) error {
 			return SuiteEmpty
 		}
 	}
+	if s.Interrupted() {
+		s.opts.Reporters.SetResult(testresult.Interrupted)
+		return SuiteInterrupted
+	}
 	if t.Failed() {
 		s.opts.Reporters.SetResult(testresult.Fail)
 		return SuiteFailed
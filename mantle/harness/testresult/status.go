@@ -21,6 +21,9 @@ const (
 	Warn TestResult = "WARN"
 	Skip TestResult = "SKIP"
 	Pass TestResult = "PASS"
+	// Interrupted is the result of tests which hadn't finished when the
+	// run was interrupted by a signal.
+	Interrupted TestResult = "INTERRUPTED"
 )
 
 type TestResult string
@@ -38,7 +41,7 @@ func (s TestResult) Display() string {
 
 	if s == Fail {
 		return red + string(s) + reset
-	} else if s == Warn {
+	} else if s == Warn || s == Interrupted {
 		return yellow + string(s) + reset
 	} else if s == Skip {
 		return blue + string(s) + reset
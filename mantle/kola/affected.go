@@ -0,0 +1,137 @@
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/lang/rpmver"
+	"github.com/coreos/coreos-assembler/mantle/util"
+	cosa "github.com/coreos/coreos-assembler/pkg/builds"
+)
+
+// AffectedBy, if set, is the ID of an earlier build; only the tests
+// covering packages which changed since that build, and the tests tagged
+// with SmokeTag, are run.
+var AffectedBy string
+
+// changedPackages returns the names of the packages added, removed or
+// changed between the build parentID and the build under test. If parentID
+// is the parent recorded in meta.json, the pkgdiff against it from there
+// is used; otherwise the rpmdb package lists of both builds are compared.
+func changedPackages(parentID string) ([]string, error) {
+	if CosaBuild == nil {
+		return nil, fmt.Errorf("a cosa build is required to find changed packages")
+	}
+	meta := CosaBuild.Meta
+	// an empty pkgdiff is a rebuild with no package changes, only a
+	// missing one means it's unknown
+	if parentID == meta.FedoraCoreOsParentVersion && meta.PkgdiffAgainstParent != nil {
+		changed, err := pkgdiffNames(meta.PkgdiffAgainstParent)
+		if err == nil {
+			if changed == nil {
+				changed = []string{}
+			}
+			return changed, nil
+		}
+		plog.Warningf("Comparing package lists instead of using the parent pkgdiff of build %s: %v", meta.BuildID, err)
+	}
+
+	parent, err := util.GetLocalBuild(Options.CosaWorkdir, parentID, CosaBuild.Arch)
+	if err != nil {
+		return nil, fmt.Errorf("finding build %s: %w", parentID, err)
+	}
+	oldPackages, err := readBuildPackages(parent.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading packages of build %s: %w", parentID, err)
+	}
+	newPackages, err := readBuildPackages(CosaBuild.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading packages of build %s: %w", meta.BuildID, err)
+	}
+
+	var changed []string
+	for name, evrs := range newPackages {
+		if !sameEVRs(evrs, oldPackages[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldPackages {
+		if _, ok := newPackages[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// pkgdiffNames returns the sorted names of the packages of a pkgdiff of
+// meta.json, whose items are [name, type, details] arrays as printed by
+// rpm-ostree db diff --format=json.
+func pkgdiffNames(diff cosa.PackageSetDifferences) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, item := range diff {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) == 0 {
+			return nil, fmt.Errorf("invalid pkgdiff item %v", item)
+		}
+		name, ok := fields[0].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid pkgdiff item %v", item)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// sameEVRs returns whether a and b hold the same versions, in any order;
+// packages such as kernel may be installed several times.
+func sameEVRs(a, b []rpmver.EVR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[rpmver.EVR]int)
+	for _, evr := range a {
+		count[evr]++
+	}
+	for _, evr := range b {
+		count[evr]--
+		if count[evr] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// testAffected returns whether one of the packages covered by t, which
+// may be glob patterns, is in changed.
+func testAffected(t *register.Test, changed []string) bool {
+	for _, pattern := range t.Packages {
+		for _, name := range changed {
+			if match, _ := filepath.Match(pattern, name); match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterAffectedTests keeps the tests covering a package in changed, and
+// the smoke tests.
+func filterAffectedTests(tests map[string]*register.Test, changed []string) map[string]*register.Test {
+	r := make(map[string]*register.Test)
+	for name, t := range tests {
+		if HasString(SmokeTag, t.Tags) || testAffected(t, changed) {
+			r[name] = t
+		} else {
+			plog.Debugf("Skipping test not affected by changed packages: %s", name)
+		}
+	}
+	return r
+}
@@ -0,0 +1,141 @@
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+)
+
+// BenchmarkIterations is the number of iterations each benchmark runs.
+var BenchmarkIterations = 5
+
+// BenchmarkChange compares a measurement of a benchmark to the baseline.
+type BenchmarkChange struct {
+	Benchmark string  `json:"benchmark"`
+	Unit      string  `json:"unit"`
+	Baseline  float64 `json:"baseline"`
+	Mean      float64 `json:"mean"`
+	Stddev    float64 `json:"stddev"`
+	// Change is the change of the mean from the baseline, in percent
+	Change    float64 `json:"change"`
+	Regressed bool    `json:"regressed"`
+}
+
+// BenchmarkComparison is the comparison of the benchmarks of a report to
+// a baseline report.
+type BenchmarkComparison struct {
+	Baseline string            `json:"baseline"`
+	Report   string            `json:"report"`
+	Changes  []BenchmarkChange `json:"changes"`
+	// Missing lists the benchmarks of the baseline without results
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Regressed returns whether a measurement regressed from the baseline.
+func (c *BenchmarkComparison) Regressed() bool {
+	for _, change := range c.Changes {
+		if change.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// higherIsBetter returns whether larger values of unit are better; rates
+// such as "MB/s" are, durations and sizes aren't.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// readBenchmarkResults returns the benchmark results of a JSON report, by
+// test name.
+func readBenchmarkResults(path string) (map[string]harness.BenchmarkResult, error) {
+	report, err := reporters.DeserialiseReport(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading report %s: %w", path, err)
+	}
+	results := make(map[string]harness.BenchmarkResult)
+	for _, t := range report.Tests {
+		detail, ok := t.Details["benchmark"]
+		if !ok {
+			continue
+		}
+		// details are decoded as generic JSON values
+		buf, err := json.Marshal(detail)
+		if err != nil {
+			return nil, err
+		}
+		var result harness.BenchmarkResult
+		if err := json.Unmarshal(buf, &result); err != nil {
+			return nil, fmt.Errorf("parsing benchmark result of %s in %s: %w", t.Name, path, err)
+		}
+		if result.Iterations > 0 {
+			results[t.Name] = result
+		}
+	}
+	return results, nil
+}
+
+// CompareBenchmarks compares the benchmark results of the JSON report
+// reportPath to those of the JSON report baselinePath. A measurement
+// regresses if its mean got worse by more than threshold percent.
+func CompareBenchmarks(baselinePath, reportPath string, threshold float64) (*BenchmarkComparison, error) {
+	baseline, err := readBenchmarkResults(baselinePath)
+	if err != nil {
+		return nil, err
+	}
+	results, err := readBenchmarkResults(reportPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &BenchmarkComparison{
+		Baseline: baselinePath,
+		Report:   reportPath,
+		Changes:  []BenchmarkChange{},
+	}
+	compare := func(name string, base, cur harness.BenchmarkStats) {
+		change := BenchmarkChange{
+			Benchmark: name,
+			Unit:      cur.Unit,
+			Baseline:  base.Mean,
+			Mean:      cur.Mean,
+			Stddev:    cur.Stddev,
+		}
+		if base.Mean != 0 {
+			change.Change = (cur.Mean - base.Mean) / base.Mean * 100
+			if higherIsBetter(cur.Unit) {
+				change.Regressed = -change.Change > threshold
+			} else {
+				change.Regressed = change.Change > threshold
+			}
+		}
+		c.Changes = append(c.Changes, change)
+	}
+	for name, base := range baseline {
+		cur, ok := results[name]
+		if !ok {
+			c.Missing = append(c.Missing, name)
+			continue
+		}
+		compare(name, base.Duration, cur.Duration)
+		for unit, stats := range cur.Metrics {
+			if baseStats, ok := base.Metrics[unit]; ok {
+				compare(name, baseStats, stats)
+			}
+		}
+	}
+	sort.Strings(c.Missing)
+	sort.Slice(c.Changes, func(i, j int) bool {
+		a, b := c.Changes[i], c.Changes[j]
+		if a.Benchmark != b.Benchmark {
+			return a.Benchmark < b.Benchmark
+		}
+		return a.Unit < b.Unit
+	})
+	return c, nil
+}
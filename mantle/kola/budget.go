@@ -0,0 +1,87 @@
+package kola
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// TimeBudget, if set, limits a run to the tests expected to finish within
+// it given TestParallelism and ResourceBudget; see selectWithinBudget.
+var TimeBudget time.Duration
+
+// selectWithinBudget splits tests into those which fit in budget and
+// those deferred to a later run. Tests are picked by descending Priority,
+// then shortest expected duration first so more of them fit, until the
+// budget is used up. A test expected to take longer than the budget by
+// itself is always deferred.
+//
+// How many tests run at once is limited by parallel and by the host
+// resources they need out of resources, so each test uses up its
+// duration times its share of the host; see hostShare.
+func selectWithinBudget(tests map[string]*register.Test, budget time.Duration, parallel int, resources harness.Resources, pltfrm string, durations testDurations) (map[string]*register.Test, []*register.Test) {
+	var sorted []*register.Test
+	for _, t := range tests {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		da, db := durations.get(GetBaseTestName(a.Name)), durations.get(GetBaseTestName(b.Name))
+		if da != db {
+			return da < db
+		}
+		return a.Name < b.Name
+	})
+
+	var used time.Duration
+	selected := make(map[string]*register.Test)
+	var deferred []*register.Test
+	for _, t := range sorted {
+		d := durations.get(GetBaseTestName(t.Name))
+		cost := time.Duration(float64(d) * hostShare(testResources(t, pltfrm), parallel, resources))
+		if d > budget || used+cost > budget {
+			deferred = append(deferred, t)
+			continue
+		}
+		used += cost
+		selected[t.Name] = t
+	}
+	plog.Debugf("Time budget %v with %d slots and resources %v: %d tests expected to use %v, %d deferred", budget, parallel, resources, len(selected), used, len(deferred))
+	return selected, deferred
+}
+
+// hostShare returns the share of the host taken by a test needing r: one
+// of parallel slots, or its part of the most limiting of resources if
+// that's larger, so that a run of such tests has
+// min(parallel, resources / r) of them at once. A test needing more than
+// resources takes the whole host.
+func hostShare(r harness.Resources, parallel int, resources harness.Resources) float64 {
+	if parallel < 1 {
+		parallel = 1
+	}
+	share := 1 / float64(parallel)
+	for _, l := range []struct{ used, limit int }{
+		{r.MemoryMiB, resources.MemoryMiB},
+		{r.CPUs, resources.CPUs},
+		{r.DiskGiB, resources.DiskGiB},
+	} {
+		if l.limit > 0 && float64(l.used)/float64(l.limit) > share {
+			share = float64(l.used) / float64(l.limit)
+		}
+	}
+	if share > 1 {
+		share = 1
+	}
+	return share
+}
+
+// deferredReason describes why a test was left out of a time-budgeted run.
+func deferredReason(t *register.Test, budget time.Duration, durations testDurations) string {
+	return fmt.Sprintf("Deferred: doesn't fit in the %v time budget (priority %d, expected duration %v)", budget, t.Priority, durations.get(GetBaseTestName(t.Name)))
+}
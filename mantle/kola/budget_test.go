@@ -0,0 +1,111 @@
+package kola
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+func TestSelectWithinBudget(t *testing.T) {
+	durations := testDurations{
+		"short":  5 * time.Minute,
+		"medium": 10 * time.Minute,
+		"long":   20 * time.Minute,
+		"huge":   2 * time.Hour,
+	}
+	test := func(name string, priority int) *register.Test {
+		return &register.Test{Name: name, Priority: priority, ClusterSize: 1, MinMemory: 4096}
+	}
+	for _, tt := range []struct {
+		name      string
+		tests     []*register.Test
+		parallel  int
+		resources harness.Resources
+		selected  []string
+		deferred  []string
+	}{
+		{
+			name:     "shortest first",
+			tests:    []*register.Test{test("long", 0), test("medium", 0), test("short", 0)},
+			parallel: 1,
+			selected: []string{"medium", "short"},
+			deferred: []string{"long"},
+		},
+		{
+			name:     "priority first",
+			tests:    []*register.Test{test("long", 1), test("medium", 0), test("short", 0)},
+			parallel: 1,
+			selected: []string{"long"},
+			deferred: []string{"short", "medium"},
+		},
+		{
+			name:     "parallel slots",
+			tests:    []*register.Test{test("long", 0), test("medium", 0), test("short", 0)},
+			parallel: 2,
+			selected: []string{"long", "medium", "short"},
+		},
+		{
+			name:     "longer than the budget",
+			tests:    []*register.Test{test("huge", 5), test("short", 0)},
+			parallel: 8,
+			selected: []string{"short"},
+			deferred: []string{"huge"},
+		},
+		{
+			// memory only allows one test at a time
+			name:      "resource budget",
+			tests:     []*register.Test{test("long", 0), test("medium", 0), test("short", 0)},
+			parallel:  8,
+			resources: harness.Resources{MemoryMiB: 6144},
+			selected:  []string{"medium", "short"},
+			deferred:  []string{"long"},
+		},
+		{
+			name:      "resources allow more than parallel",
+			tests:     []*register.Test{test("long", 0), test("medium", 0), test("short", 0)},
+			parallel:  2,
+			resources: harness.Resources{MemoryMiB: 65536},
+			selected:  []string{"long", "medium", "short"},
+		},
+	} {
+		tests := make(map[string]*register.Test)
+		for _, test := range tt.tests {
+			tests[test.Name] = test
+		}
+		selected, deferred := selectWithinBudget(tests, 20*time.Minute, tt.parallel, tt.resources, "qemu", durations)
+		var selectedNames, deferredNames []string
+		for name := range selected {
+			selectedNames = append(selectedNames, name)
+		}
+		sort.Strings(selectedNames)
+		for _, test := range deferred {
+			deferredNames = append(deferredNames, test.Name)
+		}
+		if !reflect.DeepEqual(selectedNames, tt.selected) || !reflect.DeepEqual(deferredNames, tt.deferred) {
+			t.Errorf("%s: selected %q and deferred %q, want %q and %q", tt.name, selectedNames, deferredNames, tt.selected, tt.deferred)
+		}
+	}
+}
+
+func TestHostShare(t *testing.T) {
+	for _, tt := range []struct {
+		r         harness.Resources
+		parallel  int
+		resources harness.Resources
+		share     float64
+	}{
+		{harness.Resources{MemoryMiB: 2048}, 4, harness.Resources{}, 0.25},
+		{harness.Resources{MemoryMiB: 2048}, 0, harness.Resources{}, 1},
+		{harness.Resources{MemoryMiB: 2048, CPUs: 1}, 8, harness.Resources{MemoryMiB: 8192, CPUs: 16}, 0.25},
+		{harness.Resources{MemoryMiB: 2048, CPUs: 4}, 8, harness.Resources{MemoryMiB: 65536, CPUs: 8}, 0.5},
+		{harness.Resources{MemoryMiB: 16384}, 8, harness.Resources{MemoryMiB: 8192}, 1},
+	} {
+		if share := hostShare(tt.r, tt.parallel, tt.resources); share != tt.share {
+			t.Errorf("hostShare(%v, %d, %v) = %v, want %v", tt.r, tt.parallel, tt.resources, share, tt.share)
+		}
+	}
+}
@@ -35,7 +35,8 @@ var (
 )
 
 // TestCluster embedds a Cluster to provide platform independant helper
-// methods.
+// methods. Functions registered with Cleanup run before the machines of
+// the cluster are destroyed.
 type TestCluster struct {
 	*harness.H
 	platform.Cluster
@@ -0,0 +1,85 @@
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// collectTimeout bounds how long collecting artifacts may take, since
+// the machines of a failed or timed out test may not respond.
+const collectTimeout = 2 * time.Minute
+
+// validateCollectPath checks that a collect path is an absolute path
+// without .. components, which may contain glob characters but nothing
+// else the shell would interpret.
+func validateCollectPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("collect path %q must be absolute", path)
+	}
+	for _, component := range strings.Split(path, "/") {
+		if component == ".." {
+			return fmt.Errorf("collect path %q must not contain ..", path)
+		}
+	}
+	if strings.ContainsAny(path, " \t\n'\"`$\\;&|<>(){}!#~") {
+		return fmt.Errorf("collect path %q contains unsupported characters", path)
+	}
+	if _, err := filepath.Match(path, ""); err != nil {
+		return fmt.Errorf("collect path %q: %w", path, err)
+	}
+	return nil
+}
+
+// collectArtifacts copies the files matching the CollectPaths of t from
+// each machine of c into <machine output dir>/collect/. Directories are
+// copied recursively. Failures are only logged so they don't mask the
+// result of the test.
+func collectArtifacts(h *harness.H, t *register.Test, c platform.Cluster) {
+	if len(t.CollectPaths) == 0 {
+		return
+	}
+	withCollectTimeout(h, "artifacts", func() {
+		for _, m := range c.Machines() {
+			if err := collectFromMachine(m, t.CollectPaths); err != nil {
+				plog.Warningf("Collecting artifacts of %s from machine %s: %v", h.Name(), m.ID(), err)
+			}
+		}
+	})
+}
+
+// withCollectTimeout runs f, giving up on waiting for it after
+// collectTimeout. f keeps running in the background until the machines
+// are destroyed.
+func withCollectTimeout(h *harness.H, what string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(collectTimeout):
+		plog.Warningf("Collecting %s of %s timed out after %v", what, h.Name(), collectTimeout)
+	}
+}
+
+// collectExcludes are the virtual file systems collect paths never descend
+// into.
+var collectExcludes = []string{"/proc", "/sys", "/dev"}
+
+func collectFromMachine(m platform.Machine, globs []string) error {
+	for _, glob := range globs {
+		if err := validateCollectPath(glob); err != nil {
+			return err
+		}
+	}
+	destdir := filepath.Join(m.RuntimeConf().OutputDir, m.ID(), "collect")
+	_, err := platform.FetchFiles(m, globs, destdir, &platform.TransferOptions{Exclude: collectExcludes})
+	return err
+}
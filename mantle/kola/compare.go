@@ -0,0 +1,150 @@
+package kola
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+// CompareOptions controls what CompareReports considers a duration
+// regression: a test must take both DurationThreshold percent and
+// MinDurationDelta longer than before.
+type CompareOptions struct {
+	DurationThreshold float64
+	MinDurationDelta  time.Duration
+}
+
+// ReportComparison is the difference between two JSON reports.
+type ReportComparison struct {
+	Old                 string               `json:"old"`
+	New                 string               `json:"new"`
+	NewlyFailing        []TestChange         `json:"newlyFailing"`
+	NewlyPassing        []TestChange         `json:"newlyPassing"`
+	StillFailing        []TestChange         `json:"stillFailing"`
+	Added               []TestChange         `json:"added"`
+	Removed             []TestChange         `json:"removed"`
+	DurationRegressions []DurationRegression `json:"durationRegressions"`
+}
+
+// TestChange is the result of a test in the old and the new report; the
+// result of a test missing from a report is empty.
+type TestChange struct {
+	Name      string                `json:"name"`
+	OldResult testresult.TestResult `json:"oldResult,omitempty"`
+	NewResult testresult.TestResult `json:"newResult,omitempty"`
+}
+
+// DurationRegression is a test which took significantly longer in the
+// new report.
+type DurationRegression struct {
+	Name     string        `json:"name"`
+	Old      time.Duration `json:"old"`
+	New      time.Duration `json:"new"`
+	Increase float64       `json:"increase"`
+}
+
+// Regressed returns whether tests fail in the new report which didn't in
+// the old one.
+func (c *ReportComparison) Regressed() bool {
+	return len(c.NewlyFailing) > 0
+}
+
+type reportedTest struct {
+	result   testresult.TestResult
+	duration time.Duration
+}
+
+// readReportTests returns the tests of a JSON report by name, without the
+// non-exclusive test wrappers whose names differ from run to run. If a
+// test is listed several times the last entry wins.
+func readReportTests(path string) (map[string]reportedTest, error) {
+	report, err := reporters.DeserialiseReport(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading report %s: %w", path, err)
+	}
+	tests := make(map[string]reportedTest)
+	for _, t := range report.Tests {
+		name := GetBaseTestName(t.Name)
+		if name == "" {
+			continue // skip non-exclusive test wrapper
+		}
+		tests[name] = reportedTest{result: t.Result, duration: t.Duration}
+	}
+	return tests, nil
+}
+
+func resultFailed(r testresult.TestResult) bool {
+	return r == testresult.Fail || r == testresult.Interrupted
+}
+
+// CompareReports compares the tests of the JSON reports oldPath and
+// newPath.
+func CompareReports(oldPath, newPath string, opts CompareOptions) (*ReportComparison, error) {
+	oldTests, err := readReportTests(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newTests, err := readReportTests(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ReportComparison{
+		Old:                 oldPath,
+		New:                 newPath,
+		NewlyFailing:        []TestChange{},
+		NewlyPassing:        []TestChange{},
+		StillFailing:        []TestChange{},
+		Added:               []TestChange{},
+		Removed:             []TestChange{},
+		DurationRegressions: []DurationRegression{},
+	}
+	for name, n := range newTests {
+		o, ok := oldTests[name]
+		if !ok {
+			c.Added = append(c.Added, TestChange{Name: name, NewResult: n.result})
+			continue
+		}
+		change := TestChange{Name: name, OldResult: o.result, NewResult: n.result}
+		switch {
+		case resultFailed(n.result) && resultFailed(o.result):
+			c.StillFailing = append(c.StillFailing, change)
+		case resultFailed(n.result):
+			c.NewlyFailing = append(c.NewlyFailing, change)
+		case resultFailed(o.result) && n.result != testresult.Skip:
+			c.NewlyPassing = append(c.NewlyPassing, change)
+		}
+
+		if o.result == testresult.Skip || n.result == testresult.Skip || o.duration <= 0 {
+			continue
+		}
+		delta := n.duration - o.duration
+		increase := float64(delta) / float64(o.duration) * 100
+		if delta >= opts.MinDurationDelta && delta > 0 && increase >= opts.DurationThreshold {
+			c.DurationRegressions = append(c.DurationRegressions, DurationRegression{
+				Name:     name,
+				Old:      o.duration,
+				New:      n.duration,
+				Increase: increase,
+			})
+		}
+	}
+	for name, o := range oldTests {
+		if _, ok := newTests[name]; !ok {
+			c.Removed = append(c.Removed, TestChange{Name: name, OldResult: o.result})
+		}
+	}
+
+	for _, changes := range [][]TestChange{c.NewlyFailing, c.NewlyPassing, c.StillFailing, c.Added, c.Removed} {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Name < changes[j].Name
+		})
+	}
+	sort.Slice(c.DurationRegressions, func(i, j int) bool {
+		return c.DurationRegressions[i].Increase > c.DurationRegressions[j].Increase
+	})
+	return c, nil
+}
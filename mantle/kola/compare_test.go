@@ -0,0 +1,113 @@
+package kola
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+type reportFixtureTest struct {
+	Name     string                `json:"name"`
+	Result   testresult.TestResult `json:"result"`
+	Duration time.Duration         `json:"duration"`
+}
+
+func writeReportFixture(t *testing.T, tests []reportFixtureTest) string {
+	buf, err := json.Marshal(map[string]interface{}{"tests": tests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompareReports(t *testing.T) {
+	const (
+		pass        = testresult.Pass
+		fail        = testresult.Fail
+		skip        = testresult.Skip
+		interrupted = testresult.Interrupted
+	)
+	oldPath := writeReportFixture(t, []reportFixtureTest{
+		{"newly-failing", pass, time.Minute},
+		{"interrupted", pass, time.Minute},
+		{"still-failing", fail, time.Minute},
+		{"newly-passing", fail, time.Minute},
+		{"skipped-after-failing", fail, time.Minute},
+		{"passing-after-skip", skip, 0},
+		{"removed", pass, time.Minute},
+		{"non-exclusive-test-bucket-0", pass, 20 * time.Minute},
+		{"non-exclusive-test-bucket-0/ext.moved", pass, time.Minute},
+		// duration edge cases, with a 20% and 1 minute threshold
+		{"slower-at-threshold", pass, 10 * time.Minute},
+		{"slower-below-threshold", pass, 10 * time.Minute},
+		{"slower-below-delta", pass, time.Minute},
+		{"slower-at-delta", pass, 4 * time.Minute},
+		{"slower-after-skip", skip, time.Second},
+		{"no-old-duration", pass, 0},
+		{"faster", pass, 10 * time.Minute},
+	})
+	newPath := writeReportFixture(t, []reportFixtureTest{
+		{"newly-failing", fail, time.Minute},
+		{"interrupted", interrupted, time.Minute},
+		{"still-failing", fail, time.Minute},
+		{"newly-passing", pass, time.Minute},
+		{"skipped-after-failing", skip, 0},
+		{"passing-after-skip", pass, time.Minute},
+		{"added", fail, time.Minute},
+		{"non-exclusive-test-bucket-1", pass, 30 * time.Minute},
+		{"non-exclusive-test-bucket-1/ext.moved", pass, time.Minute},
+		{"slower-at-threshold", pass, 12 * time.Minute},
+		{"slower-below-threshold", pass, 12*time.Minute - time.Second},
+		{"slower-below-delta", pass, 110 * time.Second},
+		{"slower-at-delta", pass, 5 * time.Minute},
+		{"slower-after-skip", pass, 10 * time.Minute},
+		{"no-old-duration", pass, 10 * time.Minute},
+		{"faster", pass, 5 * time.Minute},
+	})
+
+	c, err := CompareReports(oldPath, newPath, CompareOptions{DurationThreshold: 20, MinDurationDelta: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		what    string
+		changes []TestChange
+		want    []TestChange
+	}{
+		{"newly failing", c.NewlyFailing, []TestChange{
+			{"interrupted", pass, interrupted},
+			{"newly-failing", pass, fail},
+		}},
+		{"still failing", c.StillFailing, []TestChange{{"still-failing", fail, fail}}},
+		{"newly passing", c.NewlyPassing, []TestChange{{"newly-passing", fail, pass}}},
+		{"added", c.Added, []TestChange{{Name: "added", NewResult: fail}}},
+		{"removed", c.Removed, []TestChange{{Name: "removed", OldResult: pass}}},
+	} {
+		if !reflect.DeepEqual(tc.changes, tc.want) {
+			t.Errorf("%s = %+v, want %+v", tc.what, tc.changes, tc.want)
+		}
+	}
+	want := []DurationRegression{
+		{"slower-at-delta", 4 * time.Minute, 5 * time.Minute, 25},
+		{"slower-at-threshold", 10 * time.Minute, 12 * time.Minute, 20},
+	}
+	if !reflect.DeepEqual(c.DurationRegressions, want) {
+		t.Errorf("duration regressions = %+v, want %+v", c.DurationRegressions, want)
+	}
+	if !c.Regressed() {
+		t.Errorf("comparison with newly failing tests didn't regress")
+	}
+
+	if _, err := CompareReports(oldPath, filepath.Join(t.TempDir(), "missing.json"), CompareOptions{}); err == nil {
+		t.Errorf("comparing with a missing report succeeded")
+	}
+}
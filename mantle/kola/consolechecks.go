@@ -0,0 +1,300 @@
+package kola
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/network/journal"
+)
+
+const (
+	// consoleFindingContext is the number of lines of context kept
+	// before and after a console check match.
+	consoleFindingContext = 5
+	// consoleFindingMaxMatch caps the matched text kept in a finding.
+	consoleFindingMaxMatch = 1024
+)
+
+// timestamps at the start of a journal.txt line or a kernel message
+var consoleTimestampRe = regexp.MustCompile(`^(?:[A-Z][a-z]{2} [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}\.[0-9]{6}|\[ *[0-9]+\.[0-9]+\])`)
+
+// consoleCheck is a pattern which, when found in the console or journal
+// of a machine, indicates a problem.
+type consoleCheck struct {
+	desc              string
+	match             *regexp.Regexp
+	warnOnly          bool
+	allowRerunSuccess bool
+	skipFlag          *register.Flag
+	// tests limits the check to tests matching these glob patterns
+	tests []string
+}
+
+// ConsoleCheckObj is a check defined in kola-console-checks.yaml.
+type ConsoleCheckObj struct {
+	Desc              string   `yaml:"desc"`
+	Match             string   `yaml:"match"`
+	WarnOnly          bool     `yaml:"warnOnly"`
+	AllowRerunSuccess bool     `yaml:"allowRerunSuccess"`
+	Arches            []string `yaml:"arches"`
+	Platforms         []string `yaml:"platforms"`
+	Tests             []string `yaml:"tests"`
+}
+
+// ConsoleCheckDisableObj disables a built-in check, on all streams or the
+// listed ones.
+type ConsoleCheckDisableObj struct {
+	Desc    string   `yaml:"desc"`
+	Streams []string `yaml:"streams"`
+}
+
+// ConsoleChecksConfig is the format of kola-console-checks.yaml.
+type ConsoleChecksConfig struct {
+	Checks  []ConsoleCheckObj        `yaml:"checks"`
+	Disable []ConsoleCheckDisableObj `yaml:"disable"`
+}
+
+// loadedConsoleChecks is the merged set of checks from LoadConsoleChecks;
+// if nil, the built-in consoleChecks are used.
+var loadedConsoleChecks []consoleCheck
+
+func activeConsoleChecks() []consoleCheck {
+	if loadedConsoleChecks != nil {
+		return loadedConsoleChecks
+	}
+	return consoleChecks
+}
+
+// LoadConsoleChecks merges the checks from src/config/kola-console-checks.yaml
+// with the built-in ones; CheckConsole then uses the merged set. Checks
+// limited to other arches or platforms are dropped; if pltfrm is empty,
+// checks aren't filtered by platform. It's fine for the file not to exist.
+func LoadConsoleChecks(pltfrm string) error {
+	path := filepath.Join(Options.CosaWorkdir, "src/config/kola-console-checks.yaml")
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		loadedConsoleChecks = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var config ConsoleChecksConfig
+	if err := yaml.UnmarshalStrict(buf, &config); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	disabled := make(map[string]bool)
+	if len(config.Disable) > 0 {
+		var stream string
+		for _, d := range config.Disable {
+			if !HasString(d.Desc, builtinConsoleCheckNames()) {
+				return fmt.Errorf("%s: cannot disable unknown built-in check %q", path, d.Desc)
+			}
+			if len(d.Streams) > 0 && stream == "" {
+				manifest, err := parseManifest()
+				if err != nil {
+					return fmt.Errorf("%s: getting stream to disable %q: %w", path, d.Desc, err)
+				}
+				stream = manifest.Variables.Stream
+			}
+			if len(d.Streams) == 0 || HasString(stream, d.Streams) {
+				plog.Debugf("Disabling console check %q", d.Desc)
+				disabled[d.Desc] = true
+			}
+		}
+	}
+
+	var checks []consoleCheck
+	for _, check := range consoleChecks {
+		if !disabled[check.desc] {
+			checks = append(checks, check)
+		}
+	}
+	for _, obj := range config.Checks {
+		if obj.Desc == "" || obj.Match == "" {
+			return fmt.Errorf("%s: checks need a desc and a match", path)
+		}
+		match, err := regexp.Compile(obj.Match)
+		if err != nil {
+			return fmt.Errorf("%s: check %q: %w", path, obj.Desc, err)
+		}
+		for _, pattern := range obj.Tests {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: check %q: invalid test pattern %q: %w", path, obj.Desc, pattern, err)
+			}
+		}
+		if len(obj.Arches) > 0 && !HasString(Options.CosaBuildArch, obj.Arches) {
+			continue
+		}
+		if pltfrm != "" && len(obj.Platforms) > 0 && !HasString(pltfrm, obj.Platforms) {
+			continue
+		}
+		checks = append(checks, consoleCheck{
+			desc:              obj.Desc,
+			match:             match,
+			warnOnly:          obj.WarnOnly,
+			allowRerunSuccess: obj.AllowRerunSuccess,
+			tests:             obj.Tests,
+		})
+	}
+	plog.Debugf("Loaded %d console checks from %s", len(config.Checks), path)
+
+	// keep it non-nil so disabling every check sticks
+	if checks == nil {
+		checks = []consoleCheck{}
+	}
+	loadedConsoleChecks = checks
+	return nil
+}
+
+func builtinConsoleCheckNames() []string {
+	var names []string
+	for _, check := range consoleChecks {
+		names = append(names, check.desc)
+	}
+	return names
+}
+
+// appliesTo returns whether the check should run for test t, which may
+// be nil when checking output outside of a test run. Checks scoped to
+// tests also apply to the bucket of non-exclusive tests running one of
+// them.
+func (check *consoleCheck) appliesTo(t *register.Test) bool {
+	if t == nil {
+		return true
+	}
+	if check.skipFlag != nil && t.HasFlag(*check.skipFlag) {
+		return false
+	}
+	if len(check.tests) == 0 {
+		return true
+	}
+	for _, pattern := range check.tests {
+		if match, _ := filepath.Match(pattern, t.Name); match {
+			return true
+		}
+		for _, name := range t.Subtests {
+			if match, _ := filepath.Match(pattern, name); match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConsoleFinding is a match of a console check in the console or
+// journal output of a machine, as stored in the test report.
+type ConsoleFinding struct {
+	Check string `json:"check"`
+	// Detail is the first subexpression of the match, if any
+	Detail string `json:"detail,omitempty"`
+	Match  string `json:"match"`
+	// Line is the line number of the start of the match
+	Line    int      `json:"line"`
+	Context []string `json:"context"`
+	// WarnOnly is true if the finding didn't fail the test
+	WarnOnly bool `json:"warnOnly"`
+	// Source is "console" or "journal"
+	Source    string `json:"source,omitempty"`
+	MachineID string `json:"machineId,omitempty"`
+	BootID    string `json:"bootId,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+
+	offset int
+}
+
+func (f ConsoleFinding) String() string {
+	if f.Detail != "" {
+		return fmt.Sprintf("%s (%s)", f.Check, f.Detail)
+	}
+	return f.Check
+}
+
+// newConsoleFinding builds the finding for a match of check in output;
+// match holds submatch indices as returned by FindSubmatchIndex.
+func newConsoleFinding(check consoleCheck, output []byte, match []int) ConsoleFinding {
+	f := ConsoleFinding{
+		Check:    check.desc,
+		Match:    string(output[match[0]:match[1]]),
+		Line:     bytes.Count(output[:match[0]], []byte{'\n'}) + 1,
+		WarnOnly: check.warnOnly,
+		offset:   match[0],
+	}
+	if len(match) > 2 && match[2] >= 0 {
+		f.Detail = string(output[match[2]:match[3]])
+	}
+	if len(f.Match) > consoleFindingMaxMatch {
+		f.Match = f.Match[:consoleFindingMaxMatch] + "..."
+	}
+
+	lines := strings.Split(string(output), "\n")
+	start := f.Line - 1 - consoleFindingContext
+	if start < 0 {
+		start = 0
+	}
+	// for matches spanning many lines, only keep the start
+	endLine := f.Line - 1 + strings.Count(f.Match, "\n")
+	if endLine > f.Line-1+consoleFindingContext {
+		endLine = f.Line - 1 + consoleFindingContext
+	}
+	end := endLine + consoleFindingContext + 1
+	if end > len(lines) {
+		end = len(lines)
+	}
+	f.Context = lines[start:end]
+
+	if ts := consoleTimestampRe.FindString(lines[f.Line-1]); ts != "" {
+		f.Timestamp = strings.Trim(ts, "[] ")
+	}
+	return f
+}
+
+// setJournalBoot fills in the boot ID of a finding in a journal.txt
+// output, using the boot IDs from the machine's raw journal. journal.txt
+// separates boots with "-- Reboot --" lines.
+func (f *ConsoleFinding) setJournalBoot(output []byte, bootIDs []string) {
+	boot := bytes.Count(output[:f.offset], []byte("-- Reboot --\n"))
+	if boot < len(bootIDs) {
+		f.BootID = bootIDs[boot]
+	}
+}
+
+// journalBootIDs returns the boot IDs in a journal-raw.txt.gz, in order.
+func journalBootIDs(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var ids []string
+	r := journal.NewExportReader(gz)
+	for {
+		entry, err := r.ReadEntry()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the journal may have been cut off when the machine died
+			return ids, nil
+		} else if err != nil {
+			return ids, err
+		}
+		id := string(entry[journal.FIELD_BOOT_ID])
+		if id != "" && (len(ids) == 0 || ids[len(ids)-1] != id) {
+			ids = append(ids, id)
+		}
+	}
+}
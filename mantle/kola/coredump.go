@@ -0,0 +1,248 @@
+package kola
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+const coredumpDir = "/var/lib/systemd/coredump"
+
+// CoredumpMaxSize is the most MiB of cores copied from one machine.
+var CoredumpMaxSize uint = 256
+
+// crashConsoleChecks are the console checks whose findings make kola
+// look for coredumps.
+var crashConsoleChecks = []string{"segfault", "core dump"}
+
+// MachineCoredumps describes the coredumps found on a machine, as stored
+// in the test report.
+type MachineCoredumps struct {
+	MachineID string `json:"machineId"`
+	// Reason is why kola looked for coredumps
+	Reason string `json:"reason"`
+	// List and Info are the paths of the coredumpctl list and info
+	// output, relative to the test's output dir
+	List  string     `json:"list"`
+	Info  string     `json:"info"`
+	Cores []Coredump `json:"cores"`
+}
+
+// Coredump is a core file in /var/lib/systemd/coredump.
+type Coredump struct {
+	File string `json:"file"`
+	// Comm and PID are parsed from the file name
+	Comm string `json:"comm,omitempty"`
+	PID  int    `json:"pid,omitempty"`
+	Size int64  `json:"size"`
+	// Path of the copy relative to the test's output dir, empty if the
+	// core wasn't copied because of CoredumpMaxSize
+	Collected string `json:"collected,omitempty"`
+}
+
+// parseCoreName extracts the command and PID from a systemd-coredump file
+// name, core.<comm>.<uid>.<boot id>.<pid>.<timestamp>[.<compression>].
+// The command may itself contain dots.
+func parseCoreName(name string) (string, int) {
+	fields := strings.Split(strings.TrimPrefix(name, "core."), ".")
+	// drop the compression suffix, if any
+	if n := len(fields); n > 0 {
+		if _, err := strconv.ParseUint(fields[n-1], 10, 64); err != nil {
+			fields = fields[:n-1]
+		}
+	}
+	if len(fields) < 5 {
+		return "", 0
+	}
+	n := len(fields)
+	pid, err := strconv.Atoi(fields[n-2])
+	if err != nil {
+		return "", 0
+	}
+	return strings.Join(fields[:n-4], "."), pid
+}
+
+// crashSignalsCmd prints the number of coredumpctl entries, then the Id
+// and Result of the failed units, if any.
+const crashSignalsCmd = `sudo coredumpctl list --no-pager --no-legend 2>/dev/null | wc -l
+units=$(systemctl list-units --failed --no-legend --plain | cut -d' ' -f1)
+[ -z "$units" ] || systemctl show -p Id -p Result $units`
+
+// parseCrashSignals parses the output of crashSignalsCmd into the number
+// of coredumpctl entries and the failed units which dumped core.
+func parseCrashSignals(out []byte) (int, []string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() {
+		return 0, nil, fmt.Errorf("no coredumpctl entry count")
+	}
+	entries, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing coredumpctl entry count: %v", err)
+	}
+	// systemctl show separates units with an empty line
+	var units []string
+	var id, result string
+	flush := func() {
+		if id != "" && result == "core-dump" {
+			units = append(units, id)
+		}
+		id, result = "", ""
+	}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "Id="):
+			id = strings.TrimPrefix(line, "Id=")
+		case strings.HasPrefix(line, "Result="):
+			result = strings.TrimPrefix(line, "Result=")
+		}
+	}
+	flush()
+	return entries, units, scanner.Err()
+}
+
+// crashReason returns why coredumps should be collected from m, or "" if
+// there's no sign of a crash: a segfault or core dump in the journal,
+// coredumpctl entries, or failed units which dumped core.
+func crashReason(m platform.Machine) string {
+	_, findings := FindConsoleBadness([]byte(m.JournalOutput()), nil)
+	for _, f := range findings {
+		if HasString(f.Check, crashConsoleChecks) {
+			return fmt.Sprintf("found %s in journal", f.Check)
+		}
+	}
+	out, stderr, err := m.SSH(crashSignalsCmd)
+	if err != nil {
+		plog.Warningf("Looking for crashes on machine %s: %v: %s", m.ID(), err, stderr)
+		return ""
+	}
+	entries, units, err := parseCrashSignals(out)
+	if err != nil {
+		plog.Warningf("Looking for crashes on machine %s: %v", m.ID(), err)
+		return ""
+	}
+	if len(units) > 0 {
+		return fmt.Sprintf("units dumped core: %s", strings.Join(units, ", "))
+	}
+	if entries > 0 {
+		return fmt.Sprintf("%d coredumpctl entries", entries)
+	}
+	return ""
+}
+
+// collectCoredumps looks for coredumps on the machines of c which show
+// signs of a crash, and copies them into
+// <machine output dir>/coredumps/ up to CoredumpMaxSize per machine,
+// newest first. What was found is recorded in the test's report.
+func collectCoredumps(h *harness.H, c platform.Cluster) {
+	withCollectTimeout(h, "coredumps", func() {
+		var all []MachineCoredumps
+		for _, m := range c.Machines() {
+			reason := crashReason(m)
+			if reason == "" {
+				continue
+			}
+			dumps, err := collectMachineCoredumps(h, m)
+			if err != nil {
+				plog.Warningf("Collecting coredumps of %s from machine %s: %v", h.Name(), m.ID(), err)
+				continue
+			}
+			if dumps != nil {
+				dumps.Reason = reason
+				all = append(all, *dumps)
+			}
+		}
+		if len(all) > 0 {
+			h.SetDetail("coredumps", all)
+		}
+	})
+}
+
+func collectMachineCoredumps(h *harness.H, m platform.Machine) (*MachineCoredumps, error) {
+	list, stderr, err := m.SSH("sudo coredumpctl list --no-pager 2>/dev/null || true")
+	if err != nil {
+		return nil, fmt.Errorf("coredumpctl list: %v: %s", err, stderr)
+	}
+	if len(bytes.TrimSpace(list)) == 0 {
+		return nil, nil
+	}
+
+	outdir := filepath.Join(m.RuntimeConf().OutputDir, m.ID(), "coredumps")
+	if err := os.MkdirAll(outdir, 0777); err != nil {
+		return nil, err
+	}
+	rel := func(path string) string {
+		if r, err := filepath.Rel(h.OutputDir(), path); err == nil {
+			return r
+		}
+		return path
+	}
+	dumps := &MachineCoredumps{
+		MachineID: m.ID(),
+		List:      rel(filepath.Join(outdir, "coredumpctl-list.txt")),
+		Info:      rel(filepath.Join(outdir, "coredumpctl-info.txt")),
+	}
+	if err := os.WriteFile(filepath.Join(outdir, "coredumpctl-list.txt"), list, 0644); err != nil {
+		return nil, err
+	}
+	info, stderr, err := m.SSH("sudo coredumpctl info --no-pager 2>&1 || true")
+	if err != nil {
+		return nil, fmt.Errorf("coredumpctl info: %v: %s", err, stderr)
+	}
+	if err := os.WriteFile(filepath.Join(outdir, "coredumpctl-info.txt"), info, 0644); err != nil {
+		return nil, err
+	}
+
+	// list cores, newest first
+	out, stderr, err := m.SSH(fmt.Sprintf("sudo find %s -maxdepth 1 -type f -name 'core.*' -printf '%%T@ %%s %%f\\n'", coredumpDir))
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %v: %s", coredumpDir, err, stderr)
+	}
+	type coreFile struct {
+		mtime float64
+		Coredump
+	}
+	var cores []coreFile
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		mtime, _ := strconv.ParseFloat(fields[0], 64)
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		comm, pid := parseCoreName(fields[2])
+		cores = append(cores, coreFile{mtime, Coredump{File: fields[2], Comm: comm, PID: pid, Size: size}})
+	}
+	sort.Slice(cores, func(i, j int) bool {
+		return cores[i].mtime > cores[j].mtime
+	})
+
+	var total int64
+	limit := int64(CoredumpMaxSize) << 20
+	for _, core := range cores {
+		if total+core.Size <= limit {
+			dest := filepath.Join(outdir, core.File)
+			if err := platform.CopyFromMachine(m, filepath.Join(coredumpDir, core.File), dest, nil); err != nil {
+				plog.Warningf("Copying coredump %s from machine %s: %v", core.File, m.ID(), err)
+			} else {
+				total += core.Size
+				core.Collected = rel(dest)
+			}
+		} else {
+			plog.Infof("Not copying coredump %s (%d bytes) from machine %s: over the %d MiB limit", core.File, core.Size, m.ID(), CoredumpMaxSize)
+		}
+		dumps.Cores = append(dumps.Cores, core.Coredump)
+	}
+	return dumps, nil
+}
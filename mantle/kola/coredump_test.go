@@ -0,0 +1,33 @@
+package kola
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCrashSignals(t *testing.T) {
+	for _, tt := range []struct {
+		out     string
+		entries int
+		units   []string
+		err     bool
+	}{
+		{"0\n", 0, nil, false},
+		{"2\n", 2, nil, false},
+		// failed units that didn't dump core aren't crashes
+		{"0\nResult=exit-code\nId=foo.service\n", 0, nil, false},
+		{"1\nResult=core-dump\nId=foo.service\n\nResult=exit-code\nId=bar.service\n\nResult=core-dump\nId=baz.service\n",
+			1, []string{"foo.service", "baz.service"}, false},
+		{"", 0, nil, true},
+		{"none\n", 0, nil, true},
+	} {
+		entries, units, err := parseCrashSignals([]byte(tt.out))
+		if (err != nil) != tt.err {
+			t.Errorf("parseCrashSignals(%q) error = %v, want error %v", tt.out, err, tt.err)
+			continue
+		}
+		if entries != tt.entries || !reflect.DeepEqual(units, tt.units) {
+			t.Errorf("parseCrashSignals(%q) = %d, %v, want %d, %v", tt.out, entries, units, tt.entries, tt.units)
+		}
+	}
+}
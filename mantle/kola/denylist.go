@@ -0,0 +1,459 @@
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/lang/rpmver"
+)
+
+// KnownArches are the architectures which may be listed in kola-denylist.yaml.
+var KnownArches = []string{"x86_64", "aarch64", "s390x", "ppc64le", "riscv64", "loongarch64"}
+
+// denyListBuild is what version constraints in kola-denylist.yaml are
+// evaluated against.
+type denyListBuild struct {
+	version string
+	buildID string
+	dir     string
+	// packages from commitmeta.json, by name; loaded on first use
+	packages map[string][]rpmver.EVR
+}
+
+// newDenyListBuild returns the build to evaluate constraints against, or
+// nil if there is no cosa build.
+func newDenyListBuild() *denyListBuild {
+	if CosaBuild == nil {
+		return nil
+	}
+	return &denyListBuild{
+		version: CosaBuild.Meta.OstreeVersion,
+		buildID: CosaBuild.Meta.BuildID,
+		dir:     CosaBuild.Dir,
+	}
+}
+
+// loadPackages reads the rpm list of the build from commitmeta.json.
+func (b *denyListBuild) loadPackages() error {
+	if b.packages != nil {
+		return nil
+	}
+	packages, err := readBuildPackages(b.dir)
+	if err != nil {
+		return err
+	}
+	b.packages = packages
+	return nil
+}
+
+// readBuildPackages reads the rpm list of the build in dir from its
+// commitmeta.json, by package name.
+func readBuildPackages(dir string) (map[string][]rpmver.EVR, error) {
+	path := filepath.Join(dir, "commitmeta.json")
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var commitmeta struct {
+		// entries are [name, epoch, version, release, arch]
+		PkgList [][]interface{} `json:"rpmostree.rpmdb.pkglist"`
+	}
+	if err := json.Unmarshal(buf, &commitmeta); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	packages := make(map[string][]rpmver.EVR)
+	for _, pkg := range commitmeta.PkgList {
+		if len(pkg) < 4 {
+			return nil, fmt.Errorf("parsing %s: invalid package entry %v", path, pkg)
+		}
+		name := fmt.Sprint(pkg[0])
+		epoch := ""
+		if pkg[1] != nil {
+			epoch = fmt.Sprint(pkg[1])
+		}
+		packages[name] = append(packages[name], rpmver.EVR{
+			Epoch:   epoch,
+			Version: fmt.Sprint(pkg[2]),
+			Release: fmt.Sprint(pkg[3]),
+		})
+	}
+	return packages, nil
+}
+
+type versionClause struct {
+	op      string
+	version string
+}
+
+// parseVersionConstraint parses a comma separated list of clauses such as
+// ">= 39.20240101.0, < 39.20240301.0", which must all hold.
+func parseVersionConstraint(constraint string) ([]versionClause, error) {
+	var clauses []versionClause
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+		op := strings.TrimRight(c[:len(c)-len(strings.TrimLeft(c, "<>=!"))], " ")
+		version := strings.TrimSpace(c[len(op):])
+		switch op {
+		case "<", "<=", ">", ">=", "=", "==", "!=":
+		default:
+			return nil, fmt.Errorf("invalid version constraint %q: expected one of <, <=, >, >=, ==, != followed by a version", c)
+		}
+		if version == "" || strings.ContainsAny(version, " \t") {
+			return nil, fmt.Errorf("invalid version constraint %q: expected a single version", c)
+		}
+		clauses = append(clauses, versionClause{op: op, version: version})
+	}
+	return clauses, nil
+}
+
+// holds returns whether the result c of comparing a version against the
+// clause's version satisfies the clause.
+func (v versionClause) holds(c int) bool {
+	switch v.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "!=":
+		return c != 0
+	default:
+		return c == 0
+	}
+}
+
+// matchVersion returns whether version satisfies constraint.
+func matchVersion(constraint, version string) (bool, error) {
+	clauses, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	for _, clause := range clauses {
+		if !clause.holds(rpmver.Compare(version, clause.version)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parsePackageConstraint splits "kernel < 6.6.0-28" into the package name
+// and the version constraint.
+func parsePackageConstraint(constraint string) (string, []versionClause, error) {
+	fields := strings.Fields(constraint)
+	if len(fields) < 2 {
+		return "", nil, fmt.Errorf("invalid package constraint %q: expected a package name followed by a version constraint", constraint)
+	}
+	name := fields[0]
+	clauses, err := parseVersionConstraint(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(constraint), name)))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid package constraint %q: %w", constraint, err)
+	}
+	return name, clauses, nil
+}
+
+// validateConstraints checks the syntax of the version constraints of an
+// entry.
+func (obj *DenyListObj) validateConstraints() error {
+	if obj.Versions != "" {
+		if _, err := parseVersionConstraint(obj.Versions); err != nil {
+			return err
+		}
+	}
+	if obj.Builds != "" {
+		if _, err := parseVersionConstraint(obj.Builds); err != nil {
+			return err
+		}
+	}
+	for _, p := range obj.Packages {
+		if _, _, err := parsePackageConstraint(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesBuild returns whether the build satisfies the versions, builds
+// and packages constraints of the entry. If not, whatever the entry was
+// for has been fixed, so it no longer applies. Without a build the
+// constraints can't be evaluated and the entry applies.
+func (obj *DenyListObj) matchesBuild(build *denyListBuild) (bool, error) {
+	if err := obj.validateConstraints(); err != nil {
+		return false, err
+	}
+	if obj.Versions == "" && obj.Builds == "" && len(obj.Packages) == 0 {
+		return true, nil
+	}
+	if build == nil {
+		plog.Debugf("Denylist: no build to check constraints of pattern %q against", obj.Pattern)
+		return true, nil
+	}
+
+	if obj.Versions != "" {
+		if match, _ := matchVersion(obj.Versions, build.version); !match {
+			return false, nil
+		}
+	}
+	if obj.Builds != "" {
+		if match, _ := matchVersion(obj.Builds, build.buildID); !match {
+			return false, nil
+		}
+	}
+	if len(obj.Packages) > 0 {
+		if err := build.loadPackages(); err != nil {
+			return false, err
+		}
+	}
+	for _, p := range obj.Packages {
+		name, clauses, _ := parsePackageConstraint(p)
+		found := false
+		for _, evr := range build.packages[name] {
+			found = true
+			for _, clause := range clauses {
+				if !clause.holds(rpmver.CompareEVR(evr, rpmver.ParseEVR(clause.version))) {
+					found = false
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		// a package which isn't installed doesn't match either
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// DenyListCheckOptions configures CheckDenyList.
+type DenyListCheckOptions struct {
+	// Platform the entries are evaluated against
+	Platform string
+	// KnownPlatforms are the valid values for platforms:
+	KnownPlatforms []string
+	// ExpiringWithin reports snoozes expiring within this duration
+	ExpiringWithin time.Duration
+}
+
+// DenyListEntryCheck is the result of checking one kola-denylist.yaml entry.
+type DenyListEntryCheck struct {
+	DenyListObj
+	// Problems found while validating the entry
+	Problems []string `json:"problems,omitempty"`
+	// MatchedTests is the number of registered tests the pattern matches
+	MatchedTests int `json:"matchedTests"`
+	// Applies is true if the entry is relevant to the current build,
+	// stream, arch and platform, and its version constraints hold,
+	// ignoring the snooze
+	Applies bool `json:"applies"`
+	// Action is what the entry does in a run: "skip", "warn" or "none"
+	// if the snooze expired
+	Action string `json:"action"`
+	// Expiring is true if the snooze expires within the requested period
+	Expiring bool `json:"expiring"`
+}
+
+// DenyListCheck is the result of CheckDenyList.
+type DenyListCheck struct {
+	Path      string               `json:"path"`
+	Stream    string               `json:"stream"`
+	OsVersion string               `json:"osversion"`
+	Version   string               `json:"version,omitempty"`
+	BuildID   string               `json:"buildid,omitempty"`
+	Arch      string               `json:"arch"`
+	Platform  string               `json:"platform"`
+	Errors    []string             `json:"errors,omitempty"`
+	Entries   []DenyListEntryCheck `json:"entries"`
+}
+
+// Failed returns true if the denylist has problems that should be fixed.
+func (c *DenyListCheck) Failed() bool {
+	if len(c.Errors) > 0 {
+		return true
+	}
+	for _, e := range c.Entries {
+		if len(e.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// knownStreams returns the streams defined by the manifests in the config.
+func knownStreams() ([]string, error) {
+	manifests, err := filepath.Glob(filepath.Join(Options.CosaWorkdir, "src/config/manifest*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var streams []string
+	for _, path := range manifests {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var manifest ManifestData
+		if err := yaml.Unmarshal(buf, &manifest); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if s := manifest.Variables.Stream; s != "" && !HasString(s, streams) {
+			streams = append(streams, s)
+		}
+	}
+	return streams, nil
+}
+
+// countMatchingTests returns how many registered tests match a denylist
+// pattern, using the same matching as filterDenylistedTests.
+func countMatchingTests(pattern string) (int, error) {
+	count := 0
+	for _, tests := range []map[string]*register.Test{register.Tests, register.UpgradeTests, register.Benchmarks} {
+		for name, t := range tests {
+			match, err := filepath.Match(pattern, name)
+			if err != nil {
+				return 0, err
+			}
+			if match {
+				count++
+				continue
+			}
+			if idx := strings.Index(pattern, "/"); idx > -1 {
+				if match, _ := filepath.Match(pattern[:idx], name); !match {
+					continue
+				}
+				for native := range t.NativeFuncs {
+					if match, _ := filepath.Match(pattern[idx+1:], native); match {
+						count++
+					}
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// CheckDenyList strictly validates kola-denylist.yaml and reports how each
+// entry relates to the registered tests and the current build. Tests,
+// including external ones, must be registered before calling it.
+func CheckDenyList(opts DenyListCheckOptions) (*DenyListCheck, error) {
+	check := &DenyListCheck{
+		Path:     denyListPath(),
+		Arch:     Options.CosaBuildArch,
+		Platform: opts.Platform,
+	}
+
+	buf, err := os.ReadFile(check.Path)
+	if err != nil {
+		return nil, err
+	}
+	var objs []DenyListObj
+	if err := yaml.UnmarshalStrict(buf, &objs); err != nil {
+		// Unknown keys and type errors don't stop decoding, so keep
+		// checking what we got.
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, err
+		}
+		check.Errors = append(check.Errors, typeErr.Errors...)
+	}
+
+	manifest, err := parseManifest()
+	if err != nil {
+		check.Errors = append(check.Errors, fmt.Sprintf("reading manifest: %v", err))
+	} else {
+		check.Stream = manifest.Variables.Stream
+		check.OsVersion = manifest.Variables.OsVersion
+	}
+	streams, err := knownStreams()
+	if err != nil {
+		return nil, err
+	}
+
+	build := newDenyListBuild()
+	if build != nil {
+		check.Version = build.version
+		check.BuildID = build.buildID
+	}
+
+	now := time.Now()
+	for _, obj := range objs {
+		e := DenyListEntryCheck{DenyListObj: obj}
+		if obj.Pattern == "" {
+			e.Problems = append(e.Problems, "missing pattern")
+		}
+		for _, arch := range obj.Arches {
+			if !HasString(arch, KnownArches) {
+				e.Problems = append(e.Problems, fmt.Sprintf("unknown arch %q", arch))
+			}
+		}
+		for _, pltfrm := range obj.Platforms {
+			if !HasString(pltfrm, opts.KnownPlatforms) {
+				e.Problems = append(e.Problems, fmt.Sprintf("unknown platform %q", pltfrm))
+			}
+		}
+		// Only check streams if the config has any
+		for _, stream := range obj.Streams {
+			if len(streams) > 0 && !HasString(stream, streams) {
+				e.Problems = append(e.Problems, fmt.Sprintf("unknown stream %q (known: %s)", stream, strings.Join(streams, ", ")))
+			}
+		}
+
+		if err := obj.validateConstraints(); err != nil {
+			e.Problems = append(e.Problems, err.Error())
+		}
+
+		if obj.Pattern != "" && obj.Pattern != SkipConsoleWarningsTag {
+			e.MatchedTests, err = countMatchingTests(obj.Pattern)
+			if err != nil {
+				e.Problems = append(e.Problems, fmt.Sprintf("invalid pattern: %v", err))
+			} else if e.MatchedTests == 0 {
+				e.Problems = append(e.Problems, "pattern matches no registered or external test")
+			}
+		}
+
+		e.Applies = obj.appliesTo(check.Platform, check.Arch, check.Stream, check.OsVersion)
+		if e.Applies && len(e.Problems) == 0 {
+			e.Applies, err = obj.matchesBuild(build)
+			if err != nil {
+				e.Problems = append(e.Problems, err.Error())
+			}
+		}
+		e.Action = "skip"
+		if obj.Warn {
+			e.Action = "warn"
+		}
+		if obj.SnoozeDate != "" {
+			snoozeDate, err := time.Parse(snoozeFormat, obj.SnoozeDate)
+			if err != nil {
+				e.Problems = append(e.Problems, fmt.Sprintf("invalid snooze date %q, expected YYYY-MM-DD", obj.SnoozeDate))
+			} else if now.After(snoozeDate) {
+				if !obj.Warn {
+					e.Action = "none"
+				}
+			} else {
+				// A snoozed test is skipped, even if marked warn
+				e.Action = "skip"
+				e.Expiring = snoozeDate.Sub(now) <= opts.ExpiringWithin
+			}
+		}
+
+		check.Entries = append(check.Entries, e)
+	}
+
+	sort.SliceStable(check.Entries, func(i, j int) bool {
+		return check.Entries[i].Applies && !check.Entries[j].Applies
+	})
+
+	return check, nil
+}
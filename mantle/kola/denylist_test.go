@@ -0,0 +1,287 @@
+package kola
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/lang/rpmver"
+)
+
+func TestParseVersionConstraint(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		want       []versionClause
+		err        bool
+	}{
+		{"< 39.20240301.0", []versionClause{{"<", "39.20240301.0"}}, false},
+		{"<=39.20240301.0", []versionClause{{"<=", "39.20240301.0"}}, false},
+		{"== 24.03.20240501", []versionClause{{"==", "24.03.20240501"}}, false},
+		{"= 24.03.20240501", []versionClause{{"=", "24.03.20240501"}}, false},
+		{"!= 1.0", []versionClause{{"!=", "1.0"}}, false},
+		{">= 39.20240101.0, < 39.20240301.0", []versionClause{{">=", "39.20240101.0"}, {"<", "39.20240301.0"}}, false},
+		{"  > 1 ,<= 2  ", []versionClause{{">", "1"}, {"<=", "2"}}, false},
+		{"", nil, true},
+		{"39.20240101.0", nil, true},
+		{"=> 1.0", nil, true},
+		{"<", nil, true},
+		{"< 1.0 2.0", nil, true},
+		{">= 1.0,", nil, true},
+	} {
+		clauses, err := parseVersionConstraint(tc.constraint)
+		if (err != nil) != tc.err {
+			t.Errorf("parseVersionConstraint(%q) error = %v, want error %v", tc.constraint, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(clauses, tc.want) {
+			t.Errorf("parseVersionConstraint(%q) = %v, want %v", tc.constraint, clauses, tc.want)
+		}
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"< 39.20240301.0", "39.20240115.1.0", true},
+		{"< 39.20240301.0", "39.20240301.0", false},
+		{"<= 39.20240301.0", "39.20240301.0", true},
+		{"> 39.20240301.0", "40.20240101.1.0", true},
+		{">= 39.20240301.0", "39.20240201.0", false},
+		{"== 24.03.20240501", "24.03.20240501", true},
+		{"= 24.03.20240501", "24.03.20240502", false},
+		{"!= 24.03.20240501", "24.03.20240502", true},
+		// all clauses must hold
+		{">= 39.20240101.0, < 39.20240301.0", "39.20240201.0", true},
+		{">= 39.20240101.0, < 39.20240301.0", "39.20240401.0", false},
+		{">= 39.20240101.0, < 39.20240301.0", "38.20231201.0", false},
+	} {
+		match, err := matchVersion(tc.constraint, tc.version)
+		if err != nil {
+			t.Errorf("matchVersion(%q, %q): %v", tc.constraint, tc.version, err)
+			continue
+		}
+		if match != tc.want {
+			t.Errorf("matchVersion(%q, %q) = %v, want %v", tc.constraint, tc.version, match, tc.want)
+		}
+	}
+	if _, err := matchVersion("latest", "1.0"); err == nil {
+		t.Errorf("matchVersion with an invalid constraint succeeded")
+	}
+}
+
+func TestParsePackageConstraint(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		name       string
+		clauses    []versionClause
+		err        bool
+	}{
+		{"kernel < 6.6.0-28", "kernel", []versionClause{{"<", "6.6.0-28"}}, false},
+		{"kernel <6.6.0-28", "kernel", []versionClause{{"<", "6.6.0-28"}}, false},
+		{"podman >= 2:4.9.0, < 2:5.0.0", "podman", []versionClause{{">=", "2:4.9.0"}, {"<", "2:5.0.0"}}, false},
+		{"kernel", "", nil, true},
+		{"", "", nil, true},
+		{"kernel 6.6.0", "", nil, true},
+		{"kernel < ", "", nil, true},
+	} {
+		name, clauses, err := parsePackageConstraint(tc.constraint)
+		if (err != nil) != tc.err {
+			t.Errorf("parsePackageConstraint(%q) error = %v, want error %v", tc.constraint, err, tc.err)
+			continue
+		}
+		if name != tc.name || !reflect.DeepEqual(clauses, tc.clauses) {
+			t.Errorf("parsePackageConstraint(%q) = %q, %v, want %q, %v", tc.constraint, name, clauses, tc.name, tc.clauses)
+		}
+	}
+}
+
+func TestMatchesBuild(t *testing.T) {
+	build := &denyListBuild{
+		version: "39.20240201.3.0",
+		buildID: "39.20240201.dev.0",
+		packages: map[string][]rpmver.EVR{
+			"kernel": {
+				{Version: "6.6.0", Release: "27.fc39"},
+				{Version: "6.7.0", Release: "1.fc39"},
+			},
+			"podman": {{Epoch: "5", Version: "4.9.0", Release: "1.fc39"}},
+		},
+	}
+	for _, tc := range []struct {
+		name string
+		obj  DenyListObj
+		want bool
+		err  bool
+	}{
+		{"no constraints", DenyListObj{}, true, false},
+		{"version holds", DenyListObj{Versions: "< 39.20240301.0"}, true, false},
+		{"version fixed", DenyListObj{Versions: "< 39.20240101.0"}, false, false},
+		{"build holds", DenyListObj{Builds: ">= 39.20240201.dev.0"}, true, false},
+		{"build fixed", DenyListObj{Builds: "!= 39.20240201.dev.0"}, false, false},
+		// one of the installed kernels is enough
+		{"package holds", DenyListObj{Packages: []string{"kernel >= 6.7.0"}}, true, false},
+		{"package fixed", DenyListObj{Packages: []string{"kernel < 6.6.0-27"}}, false, false},
+		{"package with epoch", DenyListObj{Packages: []string{"podman < 5:5.0.0"}}, true, false},
+		{"package not installed", DenyListObj{Packages: []string{"docker < 99"}}, false, false},
+		{"all must hold", DenyListObj{Versions: "< 39.20240301.0", Packages: []string{"kernel > 7.0"}}, false, false},
+		{"invalid version", DenyListObj{Versions: "39.20240301.0"}, false, true},
+		{"invalid package", DenyListObj{Packages: []string{"kernel"}}, false, true},
+	} {
+		match, err := tc.obj.matchesBuild(build)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: matchesBuild() error = %v, want error %v", tc.name, err, tc.err)
+			continue
+		}
+		if match != tc.want {
+			t.Errorf("%s: matchesBuild() = %v, want %v", tc.name, match, tc.want)
+		}
+	}
+
+	// without a build the constraints can't be checked, so entries apply
+	if match, err := (&DenyListObj{Versions: "< 1.0"}).matchesBuild(nil); err != nil || !match {
+		t.Errorf("matchesBuild(nil) = %v, %v, want true", match, err)
+	}
+}
+
+func TestCheckDenyList(t *testing.T) {
+	oldWorkdir, oldArch, oldBuild := Options.CosaWorkdir, Options.CosaBuildArch, CosaBuild
+	oldTests := register.Tests
+	t.Cleanup(func() {
+		Options.CosaWorkdir, Options.CosaBuildArch, CosaBuild = oldWorkdir, oldArch, oldBuild
+		register.Tests = oldTests
+	})
+	Options.CosaWorkdir = t.TempDir()
+	Options.CosaBuildArch = "x86_64"
+	CosaBuild = nil
+	register.Tests = map[string]*register.Test{
+		"basic": {Name: "basic"},
+		"ext.config.files": {Name: "ext.config.files", NativeFuncs: map[string]register.NativeFuncWrap{
+			"License": {},
+		}},
+	}
+
+	config := filepath.Join(Options.CosaWorkdir, "src/config")
+	if err := os.MkdirAll(config, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"manifest.yaml":      "variables:\n  stream: testing-devel\n  osversion: fedora-39\n",
+		"manifest-next.yaml": "variables:\n  stream: next-devel\n",
+		"kola-denylist.yaml": `
+- pattern: basic
+  streams: [stable]
+- pattern: ext.nothing.*
+- pattern: basic
+  snooze: 2000-01-01
+- pattern: basic
+  snooze: 2999-01-01
+  warn: true
+- pattern: basic
+  snooze: "next week"
+- pattern: ext.config.files/License
+  arches: [x86_64]
+  platforms: [qemu]
+- pattern: ext.config.files/Other
+  platforms: [aws]
+- pattern: basic
+  arches: [sparc]
+  platforms: [vax]
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(config, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check, err := CheckDenyList(DenyListCheckOptions{
+		Platform:       "qemu",
+		KnownPlatforms: []string{"qemu", "aws"},
+		ExpiringWithin: 7 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Stream != "testing-devel" || check.OsVersion != "fedora-39" {
+		t.Errorf("stream %q and osversion %q, want testing-devel and fedora-39", check.Stream, check.OsVersion)
+	}
+	if len(check.Errors) != 0 {
+		t.Errorf("errors: %q", check.Errors)
+	}
+	if !check.Failed() {
+		t.Errorf("check with problems didn't fail")
+	}
+
+	type entry struct {
+		applies  bool
+		action   string
+		matched  int
+		problems []string
+	}
+	want := map[string]entry{
+		"basic stable":             {false, "skip", 1, []string{`unknown stream "stable" (known: next-devel, testing-devel)`}},
+		"ext.nothing.*":            {true, "skip", 0, []string{"pattern matches no registered or external test"}},
+		"basic 2000-01-01":         {true, "none", 1, nil},
+		"basic 2999-01-01":         {true, "skip", 1, nil},
+		"basic next week":          {true, "skip", 1, []string{`invalid snooze date "next week", expected YYYY-MM-DD`}},
+		"ext.config.files/License": {true, "skip", 1, nil},
+		"ext.config.files/Other":   {false, "skip", 0, []string{"pattern matches no registered or external test"}},
+		"basic sparc":              {false, "skip", 1, []string{`unknown arch "sparc"`, `unknown platform "vax"`}},
+	}
+	if len(check.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(check.Entries), len(want))
+	}
+	for i, e := range check.Entries {
+		key := e.Pattern
+		switch {
+		case len(e.Streams) > 0:
+			key += " " + e.Streams[0]
+		case e.SnoozeDate != "":
+			key += " " + e.SnoozeDate
+		case len(e.Arches) > 0 && e.Pattern == "basic":
+			key += " " + e.Arches[0]
+		}
+		w, ok := want[key]
+		if !ok {
+			t.Errorf("unexpected entry %q", key)
+			continue
+		}
+		got := entry{e.Applies, e.Action, e.MatchedTests, e.Problems}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("entry %q = %+v, want %+v", key, got, w)
+		}
+		if e.Expiring {
+			t.Errorf("entry %q expiring", key)
+		}
+		// entries which apply come first
+		if i > 0 && e.Applies && !check.Entries[i-1].Applies {
+			t.Errorf("entry %q which applies sorted after one which doesn't", key)
+		}
+	}
+}
+
+func TestCheckDenyListUnknownKey(t *testing.T) {
+	oldWorkdir := Options.CosaWorkdir
+	t.Cleanup(func() { Options.CosaWorkdir = oldWorkdir })
+	Options.CosaWorkdir = t.TempDir()
+	config := filepath.Join(Options.CosaWorkdir, "src/config")
+	if err := os.MkdirAll(config, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config, "kola-denylist.yaml"), []byte("- pattern: basic\n  stream: [stable]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check, err := CheckDenyList(DenyListCheckOptions{Platform: "qemu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the missing manifest and the misspelt key are both reported
+	if len(check.Errors) != 2 || !check.Failed() {
+		t.Errorf("errors = %q, want the missing manifest and the unknown key", check.Errors)
+	}
+}
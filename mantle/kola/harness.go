@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -90,6 +91,10 @@ const PlatformIndependentTag = "platform-independent"
 // the test passes a rerun to allow the run to succeed.
 const AllowRerunSuccessTag = "allow-rerun-success"
 
+// SmokeTag marks the tests which always run with --affected-by, whatever
+// packages changed.
+const SmokeTag = "smoke"
+
 // defaultPlatformIndependentPlatform is the platform where we run tests that claim platform independence
 const defaultPlatformIndependentPlatform = "qemu"
 
@@ -119,9 +124,10 @@ var (
 
 	CosaBuild *util.LocalBuild // this is a parsed cosa build
 
-	TestParallelism int    //glue var to set test parallelism from main
-	TAPFile         string // if not "", write TAP results here
-	NoNet           bool   // Disable tests requiring Internet
+	TestParallelism int               //glue var to set test parallelism from main
+	ResourceBudget  harness.Resources // glue var to set the host resource budget from main
+	TAPFile         string            // if not "", write TAP results here
+	NoNet           bool              // Disable tests requiring Internet
 	// ForceRunPlatformIndependent will cause tests that claim platform-independence to run
 	ForceRunPlatformIndependent bool
 
@@ -137,16 +143,10 @@ var (
 	extTestNum  = 1 // Assigns a unique number to each non-exclusive external test
 	testResults protectedTestResults
 
-	nonexclusivePrefixMatch  = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]/`)
-	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]$`)
+	nonexclusivePrefixMatch  = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]+/`)
+	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]+$`)
 
-	consoleChecks = []struct {
-		desc              string
-		match             *regexp.Regexp
-		warnOnly          bool
-		allowRerunSuccess bool
-		skipFlag          *register.Flag
-	}{
+	consoleChecks = []consoleCheck{
 		{
 			desc:              "emergency shell",
 			match:             regexp.MustCompile("Press Enter for emergency shell|Starting Emergency Shell|You are in emergency mode"),
@@ -281,6 +281,12 @@ func (p *protectedTestResults) getResults() []*harness.H {
 	return temp
 }
 
+// machinePool is implemented by flights which keep machines booted ahead of
+// the tests asking for them.
+type machinePool interface {
+	PoolStats() qemu.PoolStats
+}
+
 // NativeRunner is a closure passed to all kola test functions and used
 // to run native go functions directly on kola machines. It is necessary
 // glue until kola does introspection.
@@ -352,14 +358,21 @@ func markTestForRerunSuccess(test *register.Test, msg string) {
 }
 
 type DenyListObj struct {
-	Pattern    string   `yaml:"pattern"`
-	Tracker    string   `yaml:"tracker"`
-	Streams    []string `yaml:"streams"`
-	Arches     []string `yaml:"arches"`
-	Platforms  []string `yaml:"platforms"`
-	SnoozeDate string   `yaml:"snooze"`
-	OsVersion  []string `yaml:"osversion"`
-	Warn       bool     `yaml:"warn"`
+	Pattern    string   `yaml:"pattern" json:"pattern"`
+	Tracker    string   `yaml:"tracker" json:"tracker,omitempty"`
+	Streams    []string `yaml:"streams" json:"streams,omitempty"`
+	Arches     []string `yaml:"arches" json:"arches,omitempty"`
+	Platforms  []string `yaml:"platforms" json:"platforms,omitempty"`
+	SnoozeDate string   `yaml:"snooze" json:"snooze,omitempty"`
+	OsVersion  []string `yaml:"osversion" json:"osversion,omitempty"`
+	Warn       bool     `yaml:"warn" json:"warn,omitempty"`
+	// Versions, Builds and Packages are optional version constraints on
+	// the ostree version, the build ID and installed packages, e.g.
+	// "<= 24.03.20240501" and "kernel < 6.6.0-28"; the entry only applies
+	// to builds satisfying all of them.
+	Versions string   `yaml:"versions" json:"versions,omitempty"`
+	Builds   string   `yaml:"builds" json:"builds,omitempty"`
+	Packages []string `yaml:"packages" json:"packages,omitempty"`
 }
 
 type ManifestData struct {
@@ -373,12 +386,78 @@ type InitConfigData struct {
 	ConfigVariant string `json:"coreos-assembler.config-variant"`
 }
 
+// denyListPath returns the path to kola-denylist.yaml in the cosa workdir.
+func denyListPath() string {
+	return filepath.Join(Options.CosaWorkdir, "src/config/kola-denylist.yaml")
+}
+
+// manifestPath returns the path to the manifest of the configured variant.
+func manifestPath() (string, error) {
+	// Look for the right manifest, taking into account the variant
+	pathToInitConfig := filepath.Join(Options.CosaWorkdir, "src/config.json")
+	initConfigFile, err := os.ReadFile(pathToInitConfig)
+	if os.IsNotExist(err) {
+		// No variant config found. Let's read the default manifest
+		return filepath.Join(Options.CosaWorkdir, "src/config/manifest.yaml"), nil
+	} else if err != nil {
+		// Unexpected error
+		return "", err
+	}
+	// Figure out the variant and read the corresponding manifests
+	var initConfig InitConfigData
+	err = json.Unmarshal(initConfigFile, &initConfig)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(Options.CosaWorkdir, fmt.Sprintf("src/config/manifest-%s.yaml", initConfig.ConfigVariant)), nil
+}
+
+// parseManifest reads the stream and osversion variables from the manifest.
+func parseManifest() (*ManifestData, error) {
+	var manifest ManifestData
+	pathToManifest, err := manifestPath()
+	if err != nil {
+		return nil, err
+	}
+	manifestFile, err := os.ReadFile(pathToManifest)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// appliesTo returns true if the entry is relevant to the given platform,
+// arch, stream and osversion. This doesn't take the snooze into account.
+func (obj *DenyListObj) appliesTo(pltfrm, arch, stream, osversion string) bool {
+	if len(obj.Arches) > 0 && !HasString(arch, obj.Arches) {
+		return false
+	}
+
+	if len(obj.Platforms) > 0 && !HasString(pltfrm, obj.Platforms) {
+		return false
+	}
+
+	if len(stream) > 0 && len(obj.Streams) > 0 && !HasString(stream, obj.Streams) {
+		return false
+	}
+
+	if len(osversion) > 0 && len(obj.OsVersion) > 0 && !HasString(osversion, obj.OsVersion) {
+		return false
+	}
+
+	return true
+}
+
 func ParseDenyListYaml(pltfrm string) error {
 	var objs []DenyListObj
+	appliedDenyList = nil
 
 	// Parse kola-denylist into structs
-	pathToDenyList := filepath.Join(Options.CosaWorkdir, "src/config/kola-denylist.yaml")
-	denyListFile, err := os.ReadFile(pathToDenyList)
+	denyListFile, err := os.ReadFile(denyListPath())
 	if os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -393,31 +472,7 @@ func ParseDenyListYaml(pltfrm string) error {
 
 	plog.Debug("Parsed kola-denylist.yaml")
 
-	// Look for the right manifest, taking into account the variant
-	var manifest ManifestData
-	var pathToManifest string
-	pathToInitConfig := filepath.Join(Options.CosaWorkdir, "src/config.json")
-	initConfigFile, err := os.ReadFile(pathToInitConfig)
-	if os.IsNotExist(err) {
-		// No variant config found. Let's read the default manifest
-		pathToManifest = filepath.Join(Options.CosaWorkdir, "src/config/manifest.yaml")
-	} else if err != nil {
-		// Unexpected error
-		return err
-	} else {
-		// Figure out the variant and read the corresponding manifests
-		var initConfig InitConfigData
-		err = json.Unmarshal(initConfigFile, &initConfig)
-		if err != nil {
-			return err
-		}
-		pathToManifest = filepath.Join(Options.CosaWorkdir, fmt.Sprintf("src/config/manifest-%s.yaml", initConfig.ConfigVariant))
-	}
-	manifestFile, err := os.ReadFile(pathToManifest)
-	if err != nil {
-		return err
-	}
-	err = yaml.Unmarshal(manifestFile, &manifest)
+	manifest, err := parseManifest()
 	if err != nil {
 		return err
 	}
@@ -431,25 +486,23 @@ func ParseDenyListYaml(pltfrm string) error {
 	today := time.Now()
 
 	plog.Debugf("Denylist: Skipping tests for stream: '%s', osversion: '%s', arch: '%s'\n", stream, osversion, arch)
+	build := newDenyListBuild()
 
 	// Accumulate patterns filtering by set policies
 	plog.Debug("Processing denial patterns from yaml...")
 	for _, obj := range objs {
-		if len(obj.Arches) > 0 && !HasString(arch, obj.Arches) {
+		if !obj.appliesTo(pltfrm, arch, stream, osversion) {
 			continue
 		}
-
-		if len(obj.Platforms) > 0 && !HasString(pltfrm, obj.Platforms) {
-			continue
-		}
-
-		if len(stream) > 0 && len(obj.Streams) > 0 && !HasString(stream, obj.Streams) {
-			continue
+		match, err := obj.matchesBuild(build)
+		if err != nil {
+			return errors.Wrapf(err, "kola-denylist.yaml pattern %q", obj.Pattern)
 		}
-
-		if len(osversion) > 0 && len(obj.OsVersion) > 0 && !HasString(osversion, obj.OsVersion) {
+		if !match {
+			fmt.Printf("✅ Not skipping kola test pattern \"%s\": build doesn't match its version constraints\n", obj.Pattern)
 			continue
 		}
+		appliedDenyList = append(appliedDenyList, obj)
 
 		// Process "special" patterns which aren't test names, but influence overall behavior
 		if obj.Pattern == SkipConsoleWarningsTag {
@@ -700,6 +753,11 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		plog.Fatal(err)
 	}
 
+	// Merge console checks from kola-console-checks.yaml
+	if err := LoadConsoleChecks(pltfrm); err != nil {
+		plog.Fatal(err)
+	}
+
 	// Make sure all given patterns by the user match at least one test
 	for _, pattern := range patterns {
 		match, err := patternMatchesTests(pattern, testsBank)
@@ -719,6 +777,13 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 	if len(tests) == 0 {
 		plog.Fatalf("There are no matching tests to run on this architecture/platform: %s %s", coreosarch.CurrentRpmArch(), pltfrm)
 	}
+	for _, test := range tests {
+		for _, req := range test.Requires {
+			if err := ValidateRequirement(req); err != nil {
+				plog.Fatalf("Test %v: %v", test.Name, err)
+			}
+		}
+	}
 
 	tests, err = filterDenylistedTests(tests)
 	if err != nil {
@@ -730,6 +795,54 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		return nil
 	}
 
+	if AffectedBy != "" {
+		changed, err := changedPackages(AffectedBy)
+		if err != nil {
+			return errors.Wrapf(err, "finding packages changed since build %s", AffectedBy)
+		}
+		tests = filterAffectedTests(tests, changed)
+		fmt.Printf("Running %d tests affected by %d packages changed since build %s\n", len(tests), len(changed), AffectedBy)
+		if len(tests) == 0 {
+			fmt.Printf("There are no tests to run. Output in %v\n", outputDir)
+			return nil
+		}
+	}
+
+	// the machines waiting in the pool aren't reserved by any test
+	budget := ResourceBudget
+	if QEMUOptions.PoolSize > 0 {
+		poolResources := testResources(&register.Test{ClusterSize: QEMUOptions.PoolSize}, pltfrm)
+		var err error
+		if budget, err = reserveResources(budget, poolResources); err != nil {
+			return errors.Wrapf(err, "machine pool of %d", QEMUOptions.PoolSize)
+		}
+	}
+
+	durations := loadTestDurations()
+	var deferredTests []*register.Test
+	if TimeBudget > 0 {
+		tests, deferredTests = selectWithinBudget(tests, TimeBudget, TestParallelism, budget, pltfrm, durations)
+		if len(deferredTests) > 0 {
+			fmt.Printf("Deferring %d tests which don't fit in the %v time budget\n", len(deferredTests), TimeBudget)
+		}
+	}
+
+	if QEMUOptions.PoolSize > 0 {
+		usesPool := false
+		for _, t := range tests {
+			if !t.HasFlag(register.NoMachinePool) {
+				usesPool = true
+			}
+		}
+		if !usesPool {
+			plog.Infof("No test can take machines from the machine pool, not booting it")
+			QEMUOptions.PoolSize = 0
+			budget = ResourceBudget
+		}
+	}
+	if QEMUOptions.PoolSize > 0 {
+		QEMUOptions.PoolDir = filepath.Join(outputDir, "machine-pool")
+	}
 	flight, err := NewFlight(pltfrm)
 	if err != nil {
 		plog.Fatalf("Flight failed: %v", err)
@@ -738,7 +851,9 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 	// Generate non-exclusive test wrapper (run multiple tests in one VM)
 	var nonExclusiveTests []*register.Test
 	for _, test := range tests {
-		if test.NonExclusive {
+		// Tests which will be skipped for missing host capabilities
+		// stay out of the buckets so they're reported on their own.
+		if test.NonExclusive && !(requirementsApply(pltfrm) && len(unmetRequirements(test)) > 0) {
 			if test.ExternalTest == "" {
 				plog.Fatalf("Tests compiled in kola must be exclusive: %v", test.Name)
 			}
@@ -752,7 +867,7 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		// so add it back to the tests map.
 		tests[nonExclusiveTests[0].Name] = nonExclusiveTests[0]
 	} else if len(nonExclusiveTests) > 0 {
-		buckets := createTestBuckets(nonExclusiveTests)
+		buckets := createTestBuckets(nonExclusiveTests, durations)
 		numBuckets := len(buckets)
 		for i := 0; i < numBuckets; {
 			// This test does not need to be registered since it is temporarily
@@ -804,13 +919,18 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		plog.Fatalf("%v", err)
 	}
 
+	jsonReporter := reporters.NewJSONReporter("report.json", pltfrm, versionStr)
+	if err := jsonReporter.SetProvenance(collectProvenance(patterns, pltfrm)); err != nil {
+		plog.Warningf("Recording run provenance: %v", err)
+	}
 	opts := harness.Options{
 		OutputDir: outputDir,
 		Parallel:  TestParallelism,
+		Resources: budget,
 		Sharding:  Sharding,
 		Verbose:   true,
 		Reporters: reporters.Reporters{
-			reporters.NewJSONReporter("report.json", pltfrm, versionStr),
+			jsonReporter,
 		},
 	}
 
@@ -822,12 +942,26 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 				// Keep track of failed tests for a rerun
 				testResults.add(h)
 			}()
+			if requirementsApply(pltfrm) {
+				if unmet := unmetRequirements(test); len(unmet) > 0 {
+					h.SetDetail("skipReason", unmet)
+					h.Skipf("Skipping: host doesn't meet the test's requirements: %s", strings.Join(unmet, "; "))
+				}
+			}
 			// We launch a seperate cluster for each kola test
 			// At the end of the test, its cluster is destroyed
 			runTest(h, test, pltfrm, flight)
 		}
 		htests.Add(test.Name, run, (test.Timeout*time.Duration(100+(Options.ExtendTimeoutPercent)))/100)
 	}
+	// Deferred tests are reported as skipped so the report lists them
+	for _, test := range deferredTests {
+		reason := deferredReason(test, TimeBudget, durations)
+		htests.Add(test.Name, func(h *harness.H) {
+			h.SetDetail("deferred", true)
+			h.Skip(reason)
+		}, 0)
+	}
 
 	handleSuiteErrors := func(outputDir string, suiteErr error) error {
 		caughtTestError := suiteErr != nil
@@ -840,7 +974,9 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 			}
 		}
 
-		if caughtTestError {
+		if suiteErr == harness.SuiteInterrupted {
+			fmt.Printf("INTERRUPTED, partial output in %v\n", outputDir)
+		} else if caughtTestError {
 			fmt.Printf("FAIL, output in %v\n", outputDir)
 		} else {
 			fmt.Printf("PASS, output in %v\n", outputDir)
@@ -851,13 +987,20 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 
 	suite := harness.NewSuite(opts, htests)
 	runErr := suite.Run()
+	if pool, ok := flight.(machinePool); ok {
+		if stats := pool.PoolStats(); stats.Size > 0 {
+			fmt.Printf("Machine pool of %d: %d machines taken from it, %d booted while it was empty, %d incompatible (%.0f%% hit rate)\n",
+				stats.Size, stats.Hits, stats.Misses, stats.Incompatible, 100*stats.HitRate())
+		}
+	}
 	runErr = handleSuiteErrors(outputDir, runErr)
 
 	detectedFailedWarnTrueTests := len(getWarnTrueFailedTests(testResults.getResults())) != 0
 
 	testsToRerun := getRerunnable(testsBank, testResults.getResults())
 	numFailedTests := len(testsToRerun)
-	if len(testsToRerun) > 0 && rerun {
+	// Don't rerun tests after an interruption, the user wants to stop
+	if len(testsToRerun) > 0 && rerun && runErr != harness.SuiteInterrupted {
 		newOutputDir := filepath.Join(outputDir, "rerun")
 		fmt.Printf("\n\n======== Re-running failed tests (flake detection) ========\n\n")
 		reRunErr := runProvidedTests(testsToRerun, []string{"*"}, multiply, false, rerunSuccessTags, pltfrm, newOutputDir)
@@ -1011,26 +1154,40 @@ func RunUpgradeTests(patterns []string, rerun bool, pltfrm, outputDir string) er
 	return runProvidedTests(register.UpgradeTests, patterns, 0, rerun, nil, pltfrm, outputDir)
 }
 
+func RunBenchmarks(patterns []string, pltfrm, outputDir string) error {
+	return runProvidedTests(register.Benchmarks, patterns, 0, false, nil, pltfrm, outputDir)
+}
+
 // externalTestMeta is parsed from kola.json in external tests
 type externalTestMeta struct {
-	Architectures             string   `json:"architectures,omitempty"             yaml:"architectures,omitempty"`
-	Platforms                 string   `json:"platforms,omitempty"                 yaml:"platforms,omitempty"`
-	Distros                   string   `json:"distros,omitempty"                   yaml:"distros,omitempty"`
-	Tags                      string   `json:"tags,omitempty"                      yaml:"tags,omitempty"`
-	RequiredTag               string   `json:"requiredTag,omitempty"               yaml:"requiredTag,omitempty"`
-	AdditionalDisks           []string `json:"additionalDisks,omitempty"           yaml:"additionalDisks,omitempty"`
-	InjectContainer           bool     `json:"injectContainer,omitempty"           yaml:"injectContainer,omitempty"`
-	MinMemory                 int      `json:"minMemory,omitempty"                 yaml:"minMemory,omitempty"`
-	MinDiskSize               int      `json:"minDisk,omitempty"                   yaml:"minDisk,omitempty"`
-	AdditionalNics            int      `json:"additionalNics,omitempty"            yaml:"additionalNics,omitempty"`
-	AppendKernelArgs          string   `json:"appendKernelArgs,omitempty"          yaml:"appendKernelArgs,omitempty"`
-	AppendFirstbootKernelArgs string   `json:"appendFirstbootKernelArgs,omitempty" yaml:"appendFirstbootKernelArgs,omitempty"`
-	Exclusive                 bool     `json:"exclusive"                           yaml:"exclusive"`
-	TimeoutMin                int      `json:"timeoutMin"                          yaml:"timeoutMin"`
-	Conflicts                 []string `json:"conflicts"                           yaml:"conflicts"`
-	AllowConfigWarnings       bool     `json:"allowConfigWarnings"                 yaml:"allowConfigWarnings"`
-	NoInstanceCreds           bool     `json:"noInstanceCreds"                     yaml:"noInstanceCreds"`
-	Description               string   `json:"description"                         yaml:"description"`
+	Architectures             string     `json:"architectures,omitempty"             yaml:"architectures,omitempty"`
+	Platforms                 string     `json:"platforms,omitempty"                 yaml:"platforms,omitempty"`
+	Distros                   string     `json:"distros,omitempty"                   yaml:"distros,omitempty"`
+	Tags                      string     `json:"tags,omitempty"                      yaml:"tags,omitempty"`
+	RequiredTag               string     `json:"requiredTag,omitempty"               yaml:"requiredTag,omitempty"`
+	AdditionalDisks           []string   `json:"additionalDisks,omitempty"           yaml:"additionalDisks,omitempty"`
+	InjectContainer           bool       `json:"injectContainer,omitempty"           yaml:"injectContainer,omitempty"`
+	MinMemory                 int        `json:"minMemory,omitempty"                 yaml:"minMemory,omitempty"`
+	MinDiskSize               int        `json:"minDisk,omitempty"                   yaml:"minDisk,omitempty"`
+	AdditionalNics            int        `json:"additionalNics,omitempty"            yaml:"additionalNics,omitempty"`
+	AppendKernelArgs          string     `json:"appendKernelArgs,omitempty"          yaml:"appendKernelArgs,omitempty"`
+	AppendFirstbootKernelArgs string     `json:"appendFirstbootKernelArgs,omitempty" yaml:"appendFirstbootKernelArgs,omitempty"`
+	Exclusive                 bool       `json:"exclusive"                           yaml:"exclusive"`
+	TimeoutMin                int        `json:"timeoutMin"                          yaml:"timeoutMin"`
+	Conflicts                 []string   `json:"conflicts"                           yaml:"conflicts"`
+	AllowConfigWarnings       bool       `json:"allowConfigWarnings"                 yaml:"allowConfigWarnings"`
+	NoInstanceCreds           bool       `json:"noInstanceCreds"                     yaml:"noInstanceCreds"`
+	FirstBootSnapshot         bool       `json:"firstBootSnapshot,omitempty"         yaml:"firstBootSnapshot,omitempty"`
+	NoHealthChecks            bool       `json:"noHealthChecks,omitempty"            yaml:"noHealthChecks,omitempty"`
+	SkipHealthChecks          []string   `json:"skipHealthChecks,omitempty"          yaml:"skipHealthChecks,omitempty"`
+	Description               string     `json:"description"                         yaml:"description"`
+	ClusterSize               int        `json:"clusterSize,omitempty"               yaml:"clusterSize,omitempty"`
+	Matrix                    testMatrix `json:"matrix,omitempty"                    yaml:"matrix,omitempty"`
+	Collect                   []string   `json:"collect,omitempty"                   yaml:"collect,omitempty"`
+	Requires                  []string   `json:"requires,omitempty"                  yaml:"requires,omitempty"`
+	Locks                     []string   `json:"locks,omitempty"                     yaml:"locks,omitempty"`
+	Priority                  int        `json:"priority,omitempty"                  yaml:"priority,omitempty"`
+	Packages                  []string   `json:"packages,omitempty"                  yaml:"packages,omitempty"`
 }
 
 // metadataFromTestBinary extracts JSON-in-comment like:
@@ -1093,7 +1250,7 @@ func metadataFromTestBinary(executable string) (*externalTestMeta, error) {
 // runExternalTest is an implementation of the "external" test framework.
 // See README-kola-ext.md as well as the comments in kolet.go for reboot
 // handling.
-func runExternalTest(c cluster.TestCluster, mach platform.Machine, testNum int) error {
+func runExternalTest(c cluster.TestCluster, mach platform.Machine, testNum int, env []string) error {
 	var previousRebootState string
 	var stdout []byte
 	for {
@@ -1111,15 +1268,19 @@ func runExternalTest(c cluster.TestCluster, mach platform.Machine, testNum int)
 			}
 		}
 
+		var envArgs string
+		for _, kv := range env {
+			envArgs += fmt.Sprintf("--env %s ", shellquote.Join(kv))
+		}
 		var cmd string
 		if testNum != 0 {
 			// This is a non-exclusive test
 			unit := fmt.Sprintf("%s-%d.service", KoletExtTestUnit, testNum)
 			// Reboot requests are disabled for non-exclusive tests
-			cmd = fmt.Sprintf("sudo ./kolet run-test-unit --deny-reboots %s", shellquote.Join(unit))
+			cmd = fmt.Sprintf("sudo ./kolet run-test-unit --deny-reboots %s%s", envArgs, shellquote.Join(unit))
 		} else {
 			unit := fmt.Sprintf("%s.service", KoletExtTestUnit)
-			cmd = fmt.Sprintf("sudo ./kolet run-test-unit %s", shellquote.Join(unit))
+			cmd = fmt.Sprintf("sudo ./kolet run-test-unit %s%s", envArgs, shellquote.Join(unit))
 		}
 		stdout, err = c.SSH(mach, cmd)
 
@@ -1167,6 +1328,57 @@ func registerExternalTest(testname, executable, dependencydir string, userdata *
 		targetMeta = &metaCopy
 	}
 
+	// Each combination of matrix: values is registered as its own test
+	variants, err := targetMeta.Matrix.variants()
+	if err != nil {
+		return errors.Wrapf(err, "Parsing matrix of %s", testname)
+	}
+	for _, variant := range variants {
+		if err := registerExternalTestVariant(testname+variant.suffix(), executable, dependencydir, userdata, *targetMeta, variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterEnv returns the environment telling the external test running on
+// machines[i] about its place in the cluster.
+func clusterEnv(machines []platform.Machine, i int) []string {
+	var ips, peers []string
+	for j, m := range machines {
+		ips = append(ips, m.PrivateIP())
+		if j != i {
+			peers = append(peers, m.PrivateIP())
+		}
+	}
+	return []string{
+		fmt.Sprintf("KOLA_NODE_INDEX=%d", i),
+		fmt.Sprintf("KOLA_CLUSTER_SIZE=%d", len(machines)),
+		fmt.Sprintf("KOLA_NODE_IP=%s", machines[i].PrivateIP()),
+		fmt.Sprintf("KOLA_NODE_IPS=%s", strings.Join(ips, " ")),
+		fmt.Sprintf("KOLA_PEER_IPS=%s", strings.Join(peers, " ")),
+	}
+}
+
+func registerExternalTestVariant(testname, executable, dependencydir string, userdata *conf.UserData, targetMeta externalTestMeta, variant matrixVariant) error {
+	clusterSize := targetMeta.ClusterSize
+	if clusterSize == 0 {
+		clusterSize = 1
+	}
+	if clusterSize > 1 && !targetMeta.Exclusive {
+		return fmt.Errorf("test %v: clusterSize > 1 requires an exclusive test", testname)
+	}
+	for _, path := range targetMeta.Collect {
+		if err := validateCollectPath(path); err != nil {
+			return errors.Wrapf(err, "test %v", testname)
+		}
+	}
+	for _, req := range targetMeta.Requires {
+		if err := ValidateRequirement(req); err != nil {
+			return errors.Wrapf(err, "test %v", testname)
+		}
+	}
+
 	warningsAction := conf.FailWarnings
 	if targetMeta.AllowConfigWarnings {
 		warningsAction = conf.IgnoreWarnings
@@ -1202,8 +1414,8 @@ Environment=KOLA_UNIT=%s
 Environment=KOLA_TEST=%s
 Environment=KOLA_TEST_EXE=%s
 Environment=%s=%s
-ExecStart=%s
-`, unitName, testname, base, kolaExtBinDataEnv, destDataDir, remotepath)
+%sExecStart=%s
+`, unitName, testname, base, kolaExtBinDataEnv, destDataDir, variant.environment(), remotepath)
 	if targetMeta.InjectContainer {
 		if CosaBuild == nil {
 			return fmt.Errorf("test %v uses injectContainer, but no cosa build found", testname)
@@ -1226,7 +1438,7 @@ ExecStart=%s
 	t := &register.Test{
 		Name:          testname,
 		Description:   targetMeta.Description,
-		ClusterSize:   1, // Hardcoded for now
+		ClusterSize:   clusterSize,
 		ExternalTest:  executable,
 		DependencyDir: destDirs,
 		Tags:          []string{"external"},
@@ -1240,13 +1452,40 @@ ExecStart=%s
 		AppendFirstbootKernelArgs: targetMeta.AppendFirstbootKernelArgs,
 		NonExclusive:              !targetMeta.Exclusive,
 		Conflicts:                 targetMeta.Conflicts,
+		CollectPaths:              targetMeta.Collect,
+		Requires:                  targetMeta.Requires,
+		Locks:                     targetMeta.Locks,
+		Priority:                  targetMeta.Priority,
+		Packages:                  targetMeta.Packages,
+		SkipHealthChecks:          targetMeta.SkipHealthChecks,
 
 		Run: func(c cluster.TestCluster) {
-			mach := c.Machines()[0]
+			machines := c.Machines()
 			plog.Debugf("Running kolet")
 
-			err := runExternalTest(c, mach, num)
-			if err != nil {
+			// On multiple machines, the test runs on all of them at once.
+			// Single machine tests get no cluster environment, which
+			// saves kolet a drop-in and a daemon-reload.
+			errs := make([]error, len(machines))
+			var wg sync.WaitGroup
+			for i, mach := range machines {
+				var env []string
+				if len(machines) > 1 {
+					env = clusterEnv(machines, i)
+				}
+				wg.Add(1)
+				go func(i int, mach platform.Machine) {
+					defer wg.Done()
+					errs[i] = runExternalTest(c, mach, num, env)
+				}(i, mach)
+			}
+			wg.Wait()
+
+			for i, err := range errs {
+				if err == nil {
+					continue
+				}
+				mach := machines[i]
 				out, stderr, suberr := mach.SSH(fmt.Sprintf("sudo systemctl status --lines=40 %s", shellquote.Join(unitName)))
 				if len(out) > 0 {
 					fmt.Printf("systemctl status %s:\n%s\n", unitName, string(out))
@@ -1259,7 +1498,13 @@ ExecStart=%s
 						plog.Errorf("failed to get terminal via ssh: %v", err)
 					}
 				}
-				c.Fatalf(errors.Wrapf(err, "kolet failed: %s", stderr).Error())
+				if len(machines) > 1 {
+					err = errors.Wrapf(err, "machine %s", mach.ID())
+				}
+				c.Errorf(errors.Wrapf(err, "kolet failed: %s", stderr).Error())
+			}
+			if c.Failed() {
+				c.FailNow()
 			}
 		},
 
@@ -1287,9 +1532,28 @@ ExecStart=%s
 	} else {
 		t.Distros = strings.Fields(targetMeta.Distros)
 	}
+	if err := variant.apply(t); err != nil {
+		return errors.Wrapf(err, "test %v", testname)
+	}
+	if clusterSize > 1 {
+		// qemu machines cannot communicate between each other
+		if HasString("qemu", t.Platforms) {
+			return fmt.Errorf("test %v: clusterSize > 1 is not supported on qemu", testname)
+		}
+		t.ExcludePlatforms = append(t.ExcludePlatforms, "qemu", "qemu-iso")
+	}
 	if targetMeta.NoInstanceCreds {
 		t.Flags = append(t.Flags, register.NoInstanceCreds)
 	}
+	if targetMeta.FirstBootSnapshot {
+		t.Flags = append(t.Flags, register.FirstBootSnapshot)
+	}
+	if targetMeta.NoHealthChecks {
+		t.Flags = append(t.Flags, register.NoHealthChecks)
+	}
+	if err := validateHealthChecks(targetMeta.SkipHealthChecks); err != nil {
+		return errors.Wrapf(err, "test %v", testname)
+	}
 	t.Tags = append(t.Tags, strings.Fields(targetMeta.Tags)...)
 	// TODO validate tags here
 	t.RequiredTag = targetMeta.RequiredTag
@@ -1487,13 +1751,145 @@ func collectLogsExternalTest(h *harness.H, t *register.Test, tcluster cluster.Te
 	}
 }
 
-func createTestBuckets(tests []*register.Test) [][]*register.Test {
+const (
+	// nonExclusiveBucketTarget is the estimated run time we aim for per
+	// non-exclusive test bucket; more buckets are used (up to the test
+	// parallelism) when the tests take longer than that in total.
+	nonExclusiveBucketTarget = 10 * time.Minute
+
+	// nonExclusiveBucketMaxMemory is the most memory in MB a bucket VM
+	// may get by merging the MinMemory of its tests. Tests needing more
+	// only share a bucket with tests needing exactly as much.
+	nonExclusiveBucketMaxMemory = 4096
+)
+
+// testBucket is a set of non-exclusive tests to be run in the same VM
+// along with the merged machine requirements of those tests.
+type testBucket struct {
+	tests    []*register.Test
+	names    map[string]bool
+	duration time.Duration
+
+	minMemory                 int
+	minDiskSize               int
+	additionalDisks           []string
+	disksOwner                string
+	appendKernelArgs          string
+	appendFirstbootKernelArgs string
+	// locks held by the tests in the bucket
+	locks map[string]bool
+}
+
+// sharesLock returns whether a test in the bucket holds one of the locks
+// of t.
+func (b *testBucket) sharesLock(t *register.Test) bool {
+	for _, lock := range t.Locks {
+		if b.locks[lock] {
+			return true
+		}
+	}
+	return false
+}
 
-	// Make an array of maps. Each entry in the array represents a
-	// test bucket. Each corresponding map is the test.Name -> *register.Test
-	// mapping for tests to be executed
-	var bucketInfo []map[string]*register.Test
+// incompatibility returns why t can't be added to the bucket, or "".
+func (b *testBucket) incompatibility(t *register.Test) string {
+	if len(b.tests) == 0 {
+		return ""
+	}
+	for _, conflict := range t.Conflicts {
+		if b.names[conflict] {
+			return fmt.Sprintf("conflicts with %s", conflict)
+		}
+	}
+	if len(t.AdditionalDisks) > 0 && len(b.additionalDisks) > 0 {
+		return fmt.Sprintf("additional disks are already used by %s", b.disksOwner)
+	}
+	if t.MinMemory != b.minMemory && (t.MinMemory > nonExclusiveBucketMaxMemory || b.minMemory > nonExclusiveBucketMaxMemory) {
+		return fmt.Sprintf("memory %dM and %dM can't be merged above the bucket limit %dM", t.MinMemory, b.minMemory, nonExclusiveBucketMaxMemory)
+	}
+	if _, err := mergeKernelArgs(b.appendKernelArgs, t.AppendKernelArgs); err != nil {
+		return err.Error()
+	}
+	if _, err := mergeKernelArgs(b.appendFirstbootKernelArgs, t.AppendFirstbootKernelArgs); err != nil {
+		return fmt.Sprintf("firstboot %v", err)
+	}
+	return ""
+}
 
+// add puts t in the bucket; t must be compatible with it.
+func (b *testBucket) add(t *register.Test, duration time.Duration) error {
+	kargs, err := mergeKernelArgs(b.appendKernelArgs, t.AppendKernelArgs)
+	if err != nil {
+		return err
+	}
+	firstbootKargs, err := mergeKernelArgs(b.appendFirstbootKernelArgs, t.AppendFirstbootKernelArgs)
+	if err != nil {
+		return fmt.Errorf("firstboot %v", err)
+	}
+	b.tests = append(b.tests, t)
+	b.names[t.Name] = true
+	b.duration += duration
+	if t.MinMemory > b.minMemory {
+		b.minMemory = t.MinMemory
+	}
+	if t.MinDiskSize > b.minDiskSize {
+		b.minDiskSize = t.MinDiskSize
+	}
+	if len(t.AdditionalDisks) > 0 {
+		b.additionalDisks = t.AdditionalDisks
+		b.disksOwner = t.Name
+	}
+	b.appendKernelArgs = kargs
+	b.appendFirstbootKernelArgs = firstbootKargs
+	for _, lock := range t.Locks {
+		if b.locks == nil {
+			b.locks = make(map[string]bool)
+		}
+		b.locks[lock] = true
+	}
+	return nil
+}
+
+// mergeKernelArgs merges space-separated kernel argument strings, each
+// from a different test. A string may repeat a key, like
+// "console=tty0 console=ttyS0"; arguments already given by an earlier
+// string are dropped. Strings conflict if one gives a key another one
+// already set with other values.
+func mergeKernelArgs(kargs ...string) (string, error) {
+	var merged []string
+	seenArgs := make(map[string]bool)
+	// the first argument with each key, for error messages
+	seenKeys := make(map[string]string)
+	for _, s := range kargs {
+		var added []string
+		for _, arg := range strings.Fields(s) {
+			if seenArgs[arg] {
+				continue
+			}
+			key := strings.SplitN(arg, "=", 2)[0]
+			if prev, ok := seenKeys[key]; ok {
+				return "", fmt.Errorf("kernel arguments %q and %q are incompatible", prev, arg)
+			}
+			added = append(added, arg)
+		}
+		for _, arg := range added {
+			key := strings.SplitN(arg, "=", 2)[0]
+			if _, ok := seenKeys[key]; !ok {
+				seenKeys[key] = arg
+			}
+			seenArgs[arg] = true
+			merged = append(merged, arg)
+		}
+	}
+	return strings.Join(merged, " "), nil
+}
+
+// createTestBuckets packs non-exclusive tests into buckets, each of which
+// is run in a single VM. Tests are only packed together if they don't
+// conflict and their machine requirements can be merged. Tests sharing
+// a lock go into the same bucket where possible. Buckets are balanced by
+// the durations of the tests in an earlier run.
+func createTestBuckets(tests []*register.Test, durations testDurations) [][]*register.Test {
 	// Get a Map of test.Name -> *register.Test
 	testMap := make(map[string]*register.Test)
 	for _, test := range tests {
@@ -1514,39 +1910,74 @@ func createTestBuckets(tests []*register.Test) [][]*register.Test {
 		}
 	}
 
-	// Distribute into buckets. Start by creating a bucket with the
-	// first test in it and then going from there.
-	bucketInfo = append(bucketInfo, map[string]*register.Test{tests[0].Name: tests[0]})
-mainloop:
-	for _, test := range tests[1:] {
-		for _, bucket := range bucketInfo {
-			// Check if this bucket is being used by a conflicting test
-			foundConflict := false
-			for _, conflict := range test.Conflicts {
-				if _, found := bucket[conflict]; found {
-					foundConflict = true
-				}
+	// Longest tests first, so the shorter ones can even out the buckets
+	sorted := make([]*register.Test, len(tests))
+	copy(sorted, tests)
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := durations.get(sorted[i].Name), durations.get(sorted[j].Name)
+		if di != dj {
+			return di > dj
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var total time.Duration
+	for _, test := range tests {
+		total += durations.get(test.Name)
+	}
+	numBuckets := int((total + nonExclusiveBucketTarget - 1) / nonExclusiveBucketTarget)
+	if numBuckets > TestParallelism {
+		numBuckets = TestParallelism
+	}
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+	plog.Debugf("Packing %d non-exclusive tests (estimated %v) into %d buckets", len(tests), total, numBuckets)
+
+	var bucketInfo []*testBucket
+	for i := 0; i < numBuckets; i++ {
+		bucketInfo = append(bucketInfo, &testBucket{names: make(map[string]bool)})
+	}
+	for _, test := range sorted {
+		// Pick the compatible bucket with the least work in it, or one
+		// with a test holding the same lock, since tests sharing a lock
+		// can't run at the same time anyway
+		var best *testBucket
+		for i, bucket := range bucketInfo {
+			if reason := bucket.incompatibility(test); reason != "" {
+				plog.Debugf("Not packing %s into bucket %d: %s", test.Name, i, reason)
+				continue
 			}
-			if !foundConflict {
-				// No Conflict here. Assign the test and continue.
-				bucket[test.Name] = test
-				continue mainloop
+			if bucket.sharesLock(test) {
+				best = bucket
+				break
+			}
+			if best == nil || bucket.duration < best.duration {
+				best = bucket
 			}
 		}
-		// No eligible buckets found for test. Create a new bucket.
-		bucketInfo = append(bucketInfo, map[string]*register.Test{test.Name: test})
+		if best == nil {
+			// No eligible buckets found for test. Create a new bucket.
+			plog.Debugf("Creating bucket %d for %s", len(bucketInfo), test.Name)
+			best = &testBucket{names: make(map[string]bool)}
+			bucketInfo = append(bucketInfo, best)
+		}
+		if err := best.add(test, durations.get(test.Name)); err != nil {
+			plog.Fatalf("Cannot pack non-exclusive test %v: %v", test.Name, err)
+		}
 	}
 
-	// Convert the bucketInfo array of maps into an two dimensional
-	// array of register.Test objects. This is the format the caller
-	// is expecting the data in.
+	// Convert the bucketInfo array into an two dimensional array of
+	// register.Test objects. This is the format the caller is expecting
+	// the data in.
 	var buckets [][]*register.Test
 	for _, bucket := range bucketInfo {
-		var bucketTests []*register.Test
-		for _, test := range bucket {
-			bucketTests = append(bucketTests, test)
+		if len(bucket.tests) == 0 {
+			continue
 		}
-		buckets = append(buckets, bucketTests)
+		plog.Infof("Bucket %d: %d tests, estimated %v, memory %dM, kernel arguments %q",
+			len(buckets), len(bucket.tests), bucket.duration, bucket.minMemory, bucket.appendKernelArgs)
+		buckets = append(buckets, bucket.tests)
 	}
 
 	return buckets
@@ -1595,12 +2026,43 @@ func shardTests(tests map[string]*register.Test, sharding string) (map[string]*r
 func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Flight) register.Test {
 	// Parse test flags and gather configs
 	internetAccess := false
+	injectContainer := false
 	var tags []string
 	var nonExclusiveTestConfs []*conf.Conf
 	dependencyDirs := make(register.DepDirMap)
 	var subtests []string
+	var collectPaths []string
+	var locks []string
+	// the tests share the machine, so skip the health checks any of them
+	// skips
+	var skipHealthChecks []string
+	noHealthChecks := false
+	// the machine is shared, so it can only start from a first boot
+	// snapshot if all the tests allow it
+	firstBootSnapshot := true
 	for _, test := range tests {
 		subtests = append(subtests, test.Name)
+		if !test.HasFlag(register.FirstBootSnapshot) {
+			firstBootSnapshot = false
+		}
+		for _, name := range test.SkipHealthChecks {
+			if !HasString(name, skipHealthChecks) {
+				skipHealthChecks = append(skipHealthChecks, name)
+			}
+		}
+		if test.HasFlag(register.NoHealthChecks) {
+			noHealthChecks = true
+		}
+		for _, path := range test.CollectPaths {
+			if !HasString(path, collectPaths) {
+				collectPaths = append(collectPaths, path)
+			}
+		}
+		for _, lock := range test.Locks {
+			if !HasString(lock, locks) {
+				locks = append(locks, lock)
+			}
+		}
 		if test.HasFlag(register.NoSSHKeyInMetadata) || test.HasFlag(register.NoSSHKeyInUserData) {
 			plog.Fatalf("Non-exclusive test %v cannot have NoSSHKeyIn* flag", test.Name)
 		}
@@ -1610,13 +2072,13 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 		if test.HasFlag(register.AllowConfigWarnings) {
 			plog.Fatalf("Non-exclusive test %v cannot have AllowConfigWarnings flag", test.Name)
 		}
-		if test.AppendKernelArgs != "" {
-			plog.Fatalf("Non-exclusive test %v cannot have AppendKernelArgs", test.Name)
-		}
 		if !internetAccess && testRequiresInternet(test) {
 			tags = append(tags, NeedsInternetTag)
 			internetAccess = true
 		}
+		if test.InjectContainer {
+			injectContainer = true
+		}
 
 		if len(test.DependencyDir) > 0 {
 			for k, v := range test.DependencyDir {
@@ -1637,6 +2099,18 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 		plog.Fatalf("Error merging configs: %v", err)
 	}
 
+	// Merge machine requirements; the tests were bucketed such that this
+	// works, so any error here is a bug.
+	merged := testBucket{names: make(map[string]bool)}
+	for _, test := range tests {
+		if reason := merged.incompatibility(test); reason != "" {
+			plog.Fatalf("Non-exclusive test %v cannot run in bucket %d: %s", test.Name, bucket, reason)
+		}
+		if err := merged.add(test, 0); err != nil {
+			plog.Fatalf("Non-exclusive test %v cannot run in bucket %d: %v", test.Name, bucket, err)
+		}
+	}
+
 	nonExclusiveWrapper := register.Test{
 		Name: fmt.Sprintf("non-exclusive-test-bucket-%v", bucket),
 		Run: func(tcluster cluster.TestCluster) {
@@ -1682,6 +2156,22 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 		ClusterSize:   1,
 		Tags:          tags,
 		DependencyDir: dependencyDirs,
+
+		InjectContainer:           injectContainer,
+		MinMemory:                 merged.minMemory,
+		MinDiskSize:               merged.minDiskSize,
+		AdditionalDisks:           merged.additionalDisks,
+		AppendKernelArgs:          merged.appendKernelArgs,
+		AppendFirstbootKernelArgs: merged.appendFirstbootKernelArgs,
+		CollectPaths:              collectPaths,
+		Locks:                     locks,
+		SkipHealthChecks:          skipHealthChecks,
+	}
+	if noHealthChecks {
+		nonExclusiveWrapper.Flags = append(nonExclusiveWrapper.Flags, register.NoHealthChecks)
+	}
+	if firstBootSnapshot {
+		nonExclusiveWrapper.Flags = append(nonExclusiveWrapper.Flags, register.FirstBootSnapshot)
 	}
 
 	return nonExclusiveWrapper
@@ -1692,6 +2182,8 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 // analysis after the test run. It should already exist.
 func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flight) {
 	h.Parallel()
+	h.AcquireLocks(t.Locks...)
+	h.AcquireResources(testResources(t, pltfrm))
 	h.SetSubtests(t.Subtests)
 
 	rconf := &platform.RuntimeConfig{
@@ -1704,6 +2196,8 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		SSHOnTestFailure:   Options.SSHOnTestFailure,
 		WarningsAction:     conf.FailWarnings,
 		EarlyRelease:       h.Release,
+		FirstBootSnapshot:  t.HasFlag(register.FirstBootSnapshot) && t.ClusterSize <= 1,
+		NoMachinePool:      t.HasFlag(register.NoMachinePool),
 	}
 	if t.HasFlag(register.AllowConfigWarnings) {
 		rconf.WarningsAction = conf.IgnoreWarnings
@@ -1716,6 +2210,14 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 	}
 	defer func() {
 		h.StopExecTimer()
+		// run the test's cleanups while its machines are still up
+		h.RunCleanups()
+		collectArtifacts(h, t, c)
+		collectCoredumps(h, c)
+		collectResourceUsage(h, c)
+		if !testSkipBaseChecks(t) {
+			runHealthChecks(h, t, c)
+		}
 		c.Destroy()
 		if h.TimedOut() {
 			// We'll allow tests that time out to succeed on rerun.
@@ -1725,17 +2227,33 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 			plog.Debugf("Skipping base checks for %s", t.Name)
 			return
 		}
+		var allFindings []ConsoleFinding
 		handleConsoleChecks := func(logtype, id, output string) {
-			warnOnly, badlines := CheckConsole([]byte(output), t)
+			warnOnly, findings := FindConsoleBadness([]byte(output), t)
 			if SkipConsoleWarnings {
 				warnOnly = true
 			}
-			for _, badline := range badlines {
+			var bootIDs []string
+			if logtype == "journal" && len(findings) > 0 {
+				var err error
+				bootIDs, err = journalBootIDs(filepath.Join(rconf.OutputDir, id, "journal-raw.txt.gz"))
+				if err != nil {
+					plog.Debugf("Reading boot IDs of machine %s: %v", id, err)
+				}
+			}
+			for _, finding := range findings {
 				if warnOnly {
-					plog.Warningf("Found %s on machine %s %s", badline, id, logtype)
+					plog.Warningf("Found %s on machine %s %s", finding, id, logtype)
 				} else {
-					h.Errorf("Found %s on machine %s %s", badline, id, logtype)
+					h.Errorf("Found %s on machine %s %s", finding, id, logtype)
 				}
+				finding.Source = logtype
+				finding.MachineID = id
+				finding.WarnOnly = warnOnly
+				if logtype == "journal" {
+					finding.setJournalBoot([]byte(output), bootIDs)
+				}
+				allFindings = append(allFindings, finding)
 			}
 		}
 		for id, output := range c.ConsoleOutput() {
@@ -1744,6 +2262,9 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		for id, output := range c.JournalOutput() {
 			handleConsoleChecks("journal", id, output)
 		}
+		if len(allFindings) > 0 {
+			h.SetDetail("consoleFindings", allFindings)
+		}
 	}()
 
 	if t.ClusterSize > 0 {
@@ -1758,6 +2279,8 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 			AppendKernelArgs:          t.AppendKernelArgs,
 			AppendFirstbootKernelArgs: t.AppendFirstbootKernelArgs,
 			SkipStartMachine:          true,
+			Firmware:                  t.Firmware,
+			Native4k:                  t.Native4k,
 		}
 
 		// Providers sometimes fail to bring up a machine within a
@@ -1777,6 +2300,11 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 			markTestForRerunSuccess(t, "Platform failed starting machines.")
 			h.Fatalf("Cluster failed starting machines: %v", err)
 		}
+		if qc, ok := c.(*qemu.Cluster); ok {
+			if n := qc.PooledMachines(); n > 0 {
+				h.SetDetail("pooledMachines", n)
+			}
+		}
 	}
 
 	// pass along all registered native functions
@@ -1862,9 +2390,75 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 	}()
 
 	// run test
+	if t.Benchmark != nil {
+		h.Benchmark(BenchmarkIterations, func(b *harness.B) {
+			t.Benchmark(b, tcluster)
+		})
+		return
+	}
 	t.Run(tcluster)
 }
 
+// testResources estimates the host resources used by the machines that
+// runTest creates for t. Only the local QEMU platforms use host
+// resources; machines created by the test itself aren't accounted for.
+// Disk sizes are upper bounds since disk images are sparse.
+func testResources(t *register.Test, pltfrm string) harness.Resources {
+	if (pltfrm != "qemu" && pltfrm != "qemu-iso") || t.ClusterSize <= 0 {
+		return harness.Resources{}
+	}
+
+	// Same precedence as in the qemu platform
+	memory := t.MinMemory
+	if QEMUOptions.Memory != "" {
+		if m, err := strconv.Atoi(QEMUOptions.Memory); err == nil {
+			memory = m
+		}
+	}
+	if memory == 0 {
+		memory = platform.DefaultMemoryMiB(Options.CosaBuildArch)
+	}
+
+	disk := t.MinDiskSize
+	for _, spec := range t.AdditionalDisks {
+		if size, _, err := util.ParseDiskSpec(spec); err == nil {
+			disk += int(size)
+		}
+	}
+
+	return harness.Resources{
+		MemoryMiB: memory * t.ClusterSize,
+		CPUs:      t.ClusterSize,
+		DiskGiB:   disk * t.ClusterSize,
+	}
+}
+
+// reserveResources returns what's left of budget for the tests once r is
+// set aside. Resources the budget doesn't limit stay unlimited.
+func reserveResources(budget, r harness.Resources) (harness.Resources, error) {
+	reserve := func(limit, used int) (int, error) {
+		if limit <= 0 {
+			return limit, nil
+		}
+		if used >= limit {
+			return 0, fmt.Errorf("resource budget %v leaves nothing for tests after %v", budget, r)
+		}
+		return limit - used, nil
+	}
+	var left harness.Resources
+	var err error
+	if left.MemoryMiB, err = reserve(budget.MemoryMiB, r.MemoryMiB); err != nil {
+		return left, err
+	}
+	if left.CPUs, err = reserve(budget.CPUs, r.CPUs); err != nil {
+		return left, err
+	}
+	if left.DiskGiB, err = reserve(budget.DiskGiB, r.DiskGiB); err != nil {
+		return left, err
+	}
+	return left, nil
+}
+
 // scpKolet searches for a kolet binary and copies it to the machine.
 func scpKolet(machines []platform.Machine) error {
 	mArch := Options.CosaBuildArch
@@ -1896,20 +2490,26 @@ func scpKolet(machines []platform.Machine) error {
 // specified, its flags are respected and tags possibly updated for
 // rerun success.
 func CheckConsole(output []byte, t *register.Test) (bool, []string) {
+	warnOnly, findings := FindConsoleBadness(output, t)
 	var badlines []string
+	for _, f := range findings {
+		badlines = append(badlines, f.String())
+	}
+	return warnOnly, badlines
+}
+
+// FindConsoleBadness is like CheckConsole, but returns a ConsoleFinding
+// with the matched text and its context for each check that matched.
+func FindConsoleBadness(output []byte, t *register.Test) (bool, []ConsoleFinding) {
+	var findings []ConsoleFinding
 	warnOnly, allowRerunSuccess := true, true
-	for _, check := range consoleChecks {
-		if check.skipFlag != nil && t != nil && t.HasFlag(*check.skipFlag) {
+	for _, check := range activeConsoleChecks() {
+		if !check.appliesTo(t) {
 			continue
 		}
-		match := check.match.FindSubmatch(output)
+		match := check.match.FindSubmatchIndex(output)
 		if match != nil {
-			badline := check.desc
-			if len(match) > 1 {
-				// include first subexpression
-				badline += fmt.Sprintf(" (%s)", match[1])
-			}
-			badlines = append(badlines, badline)
+			findings = append(findings, newConsoleFinding(check, output, match))
 			if !check.warnOnly {
 				warnOnly = false
 			}
@@ -1918,10 +2518,10 @@ func CheckConsole(output []byte, t *register.Test) (bool, []string) {
 			}
 		}
 	}
-	if len(badlines) > 0 && allowRerunSuccess && t != nil {
+	if len(findings) > 0 && allowRerunSuccess && t != nil {
 		markTestForRerunSuccess(t, "CheckConsole:")
 	}
-	return warnOnly, badlines
+	return warnOnly, findings
 }
 
 func SetupOutputDir(outputDir, platform string) (string, error) {
@@ -1946,6 +2546,12 @@ func SetupOutputDir(outputDir, platform string) (string, error) {
 		network.DefaultSSHDir = defaultBaseDirName
 	}
 
+	if defaulted {
+		savePreviousDurations(filepath.Join(defaultBaseDirName, platform+"-latest"))
+	} else {
+		savePreviousDurations(outputDir)
+	}
+
 	outputDir, err := harness.CleanOutputDir(outputDir)
 	if err != nil {
 		return "", err
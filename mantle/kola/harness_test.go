@@ -0,0 +1,234 @@
+package kola
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/util"
+	cosa "github.com/coreos/coreos-assembler/pkg/builds"
+)
+
+func TestMergeKernelArgs(t *testing.T) {
+	for _, tt := range []struct {
+		kargs []string
+		out   string
+		err   bool
+	}{
+		{[]string{"", ""}, "", false},
+		{[]string{"", "console=tty0 console=ttyS0"}, "console=tty0 console=ttyS0", false},
+		{[]string{"quiet", "quiet enforcing=0"}, "quiet enforcing=0", false},
+		{[]string{"console=tty0 console=ttyS0", "console=ttyS0"}, "console=tty0 console=ttyS0", false},
+		{[]string{"console=tty0", "console=ttyS0"}, "", true},
+		{[]string{"enforcing=0", "enforcing=1"}, "", true},
+		{[]string{"a=1", "b=2", "a=1 b=2 c"}, "a=1 b=2 c", false},
+	} {
+		out, err := mergeKernelArgs(tt.kargs...)
+		if (err != nil) != tt.err {
+			t.Errorf("mergeKernelArgs(%q) error = %v, want error %v", tt.kargs, err, tt.err)
+			continue
+		}
+		if out != tt.out {
+			t.Errorf("mergeKernelArgs(%q) = %q, want %q", tt.kargs, out, tt.out)
+		}
+	}
+}
+
+func TestTestBucketAdd(t *testing.T) {
+	b := &testBucket{names: make(map[string]bool)}
+	first := &register.Test{Name: "first", AppendKernelArgs: "console=tty0 console=ttyS0"}
+	if reason := b.incompatibility(first); reason != "" {
+		t.Fatalf("empty bucket incompatible: %s", reason)
+	}
+	if err := b.add(first, 0); err != nil {
+		t.Fatalf("adding test repeating a kernel argument: %v", err)
+	}
+	same := &register.Test{Name: "same", AppendKernelArgs: "console=ttyS0"}
+	if reason := b.incompatibility(same); reason != "" {
+		t.Errorf("test with a subset of the kernel arguments incompatible: %s", reason)
+	}
+	other := &register.Test{Name: "other", AppendKernelArgs: "console=hvc0"}
+	if reason := b.incompatibility(other); reason == "" {
+		t.Errorf("test with another console compatible")
+	}
+	if err := b.add(other, 0); err == nil {
+		t.Errorf("adding incompatible test succeeded")
+	}
+	if len(b.tests) != 1 || b.appendKernelArgs != "console=tty0 console=ttyS0" {
+		t.Errorf("bucket changed by failed add: %d tests, kernel args %q", len(b.tests), b.appendKernelArgs)
+	}
+}
+
+func TestCreateTestBucketsLocks(t *testing.T) {
+	old := TestParallelism
+	TestParallelism = 4
+	t.Cleanup(func() { TestParallelism = old })
+
+	tests := []*register.Test{
+		{Name: "ext.a", Locks: []string{"tpm"}},
+		{Name: "ext.b"},
+		{Name: "ext.c", Locks: []string{"tpm"}},
+		{Name: "ext.d"},
+	}
+	durations := testDurations{}
+	for _, test := range tests {
+		durations[test.Name] = 10 * time.Minute
+	}
+	buckets := createTestBuckets(tests, durations)
+	if len(buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(buckets))
+	}
+	for _, bucket := range buckets {
+		for _, test := range bucket {
+			if test.Name == "ext.a" && (len(bucket) != 2 || bucket[1].Name != "ext.c") {
+				t.Errorf("ext.a and ext.c sharing a lock aren't in the same bucket")
+			}
+		}
+	}
+}
+
+func TestPkgdiffNames(t *testing.T) {
+	for _, tt := range []struct {
+		diff  cosa.PackageSetDifferences
+		names []string
+		err   bool
+	}{
+		{nil, nil, false},
+		{cosa.PackageSetDifferences{
+			[]interface{}{"podman-plugins", 2.0, map[string]interface{}{}},
+			[]interface{}{"podman", 2.0, map[string]interface{}{}},
+			[]interface{}{"kernel", 1.0, map[string]interface{}{}},
+			[]interface{}{"kernel", 1.0, map[string]interface{}{}},
+		}, []string{"kernel", "podman", "podman-plugins"}, false},
+		{cosa.PackageSetDifferences{"podman"}, nil, true},
+		{cosa.PackageSetDifferences{[]interface{}{}}, nil, true},
+		{cosa.PackageSetDifferences{[]interface{}{2.0, "podman"}}, nil, true},
+	} {
+		names, err := pkgdiffNames(tt.diff)
+		if (err != nil) != tt.err {
+			t.Errorf("pkgdiffNames(%v) error = %v, want error %v", tt.diff, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("pkgdiffNames(%v) = %q, want %q", tt.diff, names, tt.names)
+		}
+	}
+}
+
+func TestChangedPackagesFromParentPkgdiff(t *testing.T) {
+	old := CosaBuild
+	t.Cleanup(func() { CosaBuild = old })
+
+	for _, tt := range []struct {
+		pkgdiff cosa.PackageSetDifferences
+		changed []string
+	}{
+		// a trivial rebuild changes no packages
+		{cosa.PackageSetDifferences{}, []string{}},
+		{cosa.PackageSetDifferences{
+			[]interface{}{"podman", 2.0, map[string]interface{}{}},
+		}, []string{"podman"}},
+	} {
+		CosaBuild = &util.LocalBuild{
+			Dir: t.TempDir(),
+			Meta: &cosa.Build{
+				BuildID:                   "39.20240102.0",
+				FedoraCoreOsParentVersion: "39.20240101.0",
+				PkgdiffAgainstParent:      tt.pkgdiff,
+			},
+		}
+		changed, err := changedPackages("39.20240101.0")
+		if err != nil {
+			t.Errorf("changedPackages() with pkgdiff %v: %v", tt.pkgdiff, err)
+			continue
+		}
+		if !reflect.DeepEqual(changed, tt.changed) {
+			t.Errorf("changedPackages() with pkgdiff %v = %q, want %q", tt.pkgdiff, changed, tt.changed)
+		}
+	}
+}
+
+func TestValidateCollectPath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		ok   bool
+	}{
+		{"/var/log/foo.log", true},
+		{"/var/lib/foo/*.json", true},
+		{"/var/lib/..foo", true},
+		{"var/log", false},
+		{"/var/log/../../etc/shadow", false},
+		{"/..", false},
+		{"/var/log/$(reboot)", false},
+		{"/var/log/[", false},
+	} {
+		if err := validateCollectPath(tt.path); (err == nil) != tt.ok {
+			t.Errorf("validateCollectPath(%q) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestReserveResources(t *testing.T) {
+	for _, tt := range []struct {
+		budget, r, left harness.Resources
+		err             bool
+	}{
+		{harness.Resources{}, harness.Resources{MemoryMiB: 4096, CPUs: 2}, harness.Resources{}, false},
+		{harness.Resources{MemoryMiB: 16384, CPUs: 8}, harness.Resources{MemoryMiB: 4096, CPUs: 2}, harness.Resources{MemoryMiB: 12288, CPUs: 6}, false},
+		{harness.Resources{MemoryMiB: 16384, DiskGiB: 100}, harness.Resources{MemoryMiB: 4096, CPUs: 2}, harness.Resources{MemoryMiB: 12288, DiskGiB: 100}, false},
+		{harness.Resources{MemoryMiB: 4096, CPUs: 8}, harness.Resources{MemoryMiB: 4096, CPUs: 2}, harness.Resources{}, true},
+		{harness.Resources{MemoryMiB: 16384, CPUs: 1}, harness.Resources{MemoryMiB: 4096, CPUs: 2}, harness.Resources{}, true},
+	} {
+		left, err := reserveResources(tt.budget, tt.r)
+		if (err != nil) != tt.err {
+			t.Errorf("reserveResources(%v, %v) error = %v, want error %v", tt.budget, tt.r, err, tt.err)
+			continue
+		}
+		if !tt.err && left != tt.left {
+			t.Errorf("reserveResources(%v, %v) = %v, want %v", tt.budget, tt.r, left, tt.left)
+		}
+	}
+}
+
+func TestConsoleCheckAppliesTo(t *testing.T) {
+	check := &consoleCheck{desc: "scoped", tests: []string{"ext.config.kdump.*"}}
+	for _, tt := range []struct {
+		test    *register.Test
+		applies bool
+	}{
+		{nil, true},
+		{&register.Test{Name: "ext.config.kdump.crash"}, true},
+		{&register.Test{Name: "basic"}, false},
+		{&register.Test{Name: "non-exclusive-test-bucket-0", Subtests: []string{"basic", "ext.config.kdump.sysctl"}}, true},
+		{&register.Test{Name: "non-exclusive-test-bucket-1", Subtests: []string{"basic", "ext.config.shared.ignition"}}, false},
+	} {
+		if applies := check.appliesTo(tt.test); applies != tt.applies {
+			t.Errorf("appliesTo(%+v) = %v, want %v", tt.test, applies, tt.applies)
+		}
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	for _, tt := range []struct {
+		meminfo     string
+		total, used uint64
+		err         bool
+	}{
+		{"MemTotal:        2010000 kB\nMemFree:          500000 kB\nMemAvailable:    1497600 kB\n", 1962, 500, false},
+		// available can't exceed total
+		{"MemTotal: 1024 kB\nMemAvailable: 4096 kB\n", 1, 0, false},
+		{"MemTotal: 1048576 kB\n", 1024, 1024, false},
+		{"MemFree: 1024 kB\n", 0, 0, true},
+	} {
+		total, used, err := parseMeminfo([]byte(tt.meminfo))
+		if (err != nil) != tt.err {
+			t.Errorf("parseMeminfo(%q) error = %v, want error %v", tt.meminfo, err, tt.err)
+			continue
+		}
+		if total != tt.total || used != tt.used {
+			t.Errorf("parseMeminfo(%q) = %d, %d, want %d, %d", tt.meminfo, total, used, tt.total, tt.used)
+		}
+	}
+}
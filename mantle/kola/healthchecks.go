@@ -0,0 +1,384 @@
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+const (
+	// healthCheckMaxProblems caps the problems kept per check and machine.
+	healthCheckMaxProblems = 10
+	// diskUsageThreshold is the percentage of a file system in use above
+	// which the disk-usage check complains.
+	diskUsageThreshold = 90
+)
+
+// HealthSeverity is what a problem found by a health check does to the
+// test.
+type HealthSeverity string
+
+const (
+	// HealthWarn problems are logged and reported
+	HealthWarn HealthSeverity = "warn"
+	// HealthFail problems also fail the test
+	HealthFail HealthSeverity = "fail"
+)
+
+// HealthCheck inspects a machine after a test.
+type HealthCheck struct {
+	// Name is used in the report and to skip the check, see
+	// register.Test.SkipHealthChecks
+	Name        string
+	Description string
+	Severity    HealthSeverity
+	// Run returns the problems found on the machine, none if it's
+	// healthy; an error means the check couldn't run.
+	Run func(m platform.Machine) ([]string, error)
+}
+
+// HealthCheckResult is the outcome of a health check on a machine, as
+// recorded in the "healthChecks" detail of the test in the report.
+type HealthCheckResult struct {
+	MachineID string `json:"machineId"`
+	Check     string `json:"check"`
+	// Status is pass, the severity of the check if it found problems,
+	// error if it couldn't run, or timeout if it didn't finish in time
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+var (
+	healthChecksMu sync.Mutex
+	healthChecks   []*HealthCheck
+)
+
+// RegisterHealthCheck adds a check run on every machine after each test.
+func RegisterHealthCheck(hc *HealthCheck) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	if hc.Severity != HealthWarn && hc.Severity != HealthFail {
+		panic(fmt.Sprintf("health check %s has invalid severity %q", hc.Name, hc.Severity))
+	}
+	for _, other := range healthChecks {
+		if other.Name == hc.Name {
+			panic(fmt.Sprintf("health check %s already registered", hc.Name))
+		}
+	}
+	healthChecks = append(healthChecks, hc)
+}
+
+// HealthCheckNames returns the names of the registered health checks.
+func HealthCheckNames() []string {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	var names []string
+	for _, hc := range healthChecks {
+		names = append(names, hc.Name)
+	}
+	return names
+}
+
+// validateHealthChecks returns an error if names has an unknown check.
+func validateHealthChecks(names []string) error {
+	known := HealthCheckNames()
+	for _, name := range names {
+		if !HasString(name, known) {
+			return fmt.Errorf("unknown health check %q; known checks: %s", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "failed-units",
+		Description: "no systemd unit failed",
+		Severity:    HealthFail,
+		Run: func(m platform.Machine) ([]string, error) {
+			return platform.SystemdUnits(m, "failed")
+		},
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "stuck-units",
+		Description: "no systemd unit is restarting in a loop",
+		Severity:    HealthFail,
+		Run:         platform.StuckSystemdUnits,
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "avc-denials",
+		Description: "SELinux denied nothing",
+		Severity:    HealthWarn,
+		Run:         checkAVCDenials,
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "tainted-kernel",
+		Description: "the kernel isn't tainted",
+		Severity:    HealthWarn,
+		Run:         checkTaintedKernel,
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "leaked-mounts",
+		Description: "nothing is left mounted in temporary or removable media locations, or from loop devices",
+		Severity:    HealthWarn,
+		Run:         checkLeakedMounts,
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "rpm-ostree-status",
+		Description: "rpm-ostree works and has no transaction in progress",
+		Severity:    HealthWarn,
+		Run:         checkRpmOstreeStatus,
+	})
+	RegisterHealthCheck(&HealthCheck{
+		Name:        "disk-usage",
+		Description: fmt.Sprintf("no file system is more than %d%% full", diskUsageThreshold),
+		Severity:    HealthWarn,
+		Run:         checkDiskUsage,
+	})
+}
+
+func checkAVCDenials(m platform.Machine) ([]string, error) {
+	// the audit messages of the journal; AVCs go there too when auditd
+	// runs
+	out, stderr, err := m.SSH("sudo journalctl -q --no-pager -o cat _TRANSPORT=audit _TRANSPORT=kernel | grep -F 'avc:  denied' || :")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return nonEmptyLines(out), nil
+}
+
+// kernelTaintFlags are the letters of the bits of /proc/sys/kernel/tainted,
+// see https://docs.kernel.org/admin-guide/tainted-kernels.html
+const kernelTaintFlags = "PFSRMBUDAWCIOELKXTN"
+
+func checkTaintedKernel(m platform.Machine) ([]string, error) {
+	out, stderr, err := m.SSH("cat /proc/sys/kernel/tainted")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return parseKernelTaint(out)
+}
+
+// parseKernelTaint returns the problem of a tainted kernel from the
+// contents of /proc/sys/kernel/tainted.
+func parseKernelTaint(out []byte) ([]string, error) {
+	taint, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if taint == 0 {
+		return nil, nil
+	}
+	var flags string
+	for i, flag := range kernelTaintFlags {
+		if taint&(1<<i) != 0 {
+			flags += string(flag)
+		}
+	}
+	return []string{fmt.Sprintf("kernel tainted: %d (%s)", taint, flags)}, nil
+}
+
+// leakedMountDirs are where tests typically mount things they should
+// unmount afterwards.
+var leakedMountDirs = []string{"/mnt", "/var/mnt", "/media", "/run/media", "/tmp", "/var/tmp"}
+
+func checkLeakedMounts(m platform.Machine) ([]string, error) {
+	out, stderr, err := m.SSH("findmnt -rn -o TARGET,SOURCE")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return parseLeakedMounts(out), nil
+}
+
+// parseLeakedMounts returns the leaked mounts of the output of
+// findmnt -rn -o TARGET,SOURCE.
+func parseLeakedMounts(out []byte) []string {
+	var leaked []string
+	for _, line := range nonEmptyLines(out) {
+		fields := strings.Fields(line)
+		target, source := fields[0], ""
+		if len(fields) > 1 {
+			source = fields[1]
+		}
+		leak := strings.HasPrefix(source, "/dev/loop")
+		for _, dir := range leakedMountDirs {
+			if strings.HasPrefix(target, dir+"/") {
+				leak = true
+			}
+		}
+		if leak {
+			leaked = append(leaked, fmt.Sprintf("%s mounted on %s", source, target))
+		}
+	}
+	return leaked
+}
+
+func checkRpmOstreeStatus(m platform.Machine) ([]string, error) {
+	out, stderr, err := m.SSH("if test -e /run/ostree-booted; then rpm-ostree status --json; fi")
+	if err != nil {
+		return []string{fmt.Sprintf("rpm-ostree status failed: %v: %s", err, stderr)}, nil
+	}
+	if len(out) == 0 {
+		// not an ostree system
+		return nil, nil
+	}
+	var status struct {
+		Transaction json.RawMessage `json:"transaction"`
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return nil, fmt.Errorf("parsing rpm-ostree status: %v", err)
+	}
+	if t := strings.TrimSpace(string(status.Transaction)); t != "" && t != "null" {
+		return []string{fmt.Sprintf("rpm-ostree transaction in progress: %s", t)}, nil
+	}
+	return nil, nil
+}
+
+func checkDiskUsage(m platform.Machine) ([]string, error) {
+	out, stderr, err := m.SSH("df --output=pcent,target -x tmpfs -x devtmpfs -x squashfs -x overlay -x iso9660 | tail -n +2")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr)
+	}
+	return parseDiskUsage(out), nil
+}
+
+// parseDiskUsage returns the file systems above diskUsageThreshold of the
+// output of df --output=pcent,target, without header.
+func parseDiskUsage(out []byte) []string {
+	var full []string
+	for _, line := range nonEmptyLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pcent, err := strconv.Atoi(strings.TrimSuffix(fields[0], "%"))
+		if err != nil {
+			continue
+		}
+		if pcent > diskUsageThreshold {
+			full = append(full, fmt.Sprintf("%s is %d%% full", fields[1], pcent))
+		}
+	}
+	return full
+}
+
+func nonEmptyLines(out []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// testHealthChecks returns the health checks to run after t.
+func testHealthChecks(t *register.Test) []*HealthCheck {
+	if t.HasFlag(register.NoHealthChecks) {
+		return nil
+	}
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	var checks []*HealthCheck
+	for _, hc := range healthChecks {
+		if !HasString(hc.Name, t.SkipHealthChecks) {
+			checks = append(checks, hc)
+		}
+	}
+	return checks
+}
+
+// runHealthChecks runs the health checks of t on the machines of c still
+// running at the end of the test. Problems of checks with fail severity
+// fail the test; all results are recorded in the report.
+func runHealthChecks(h *harness.H, t *register.Test, c platform.Cluster) {
+	if err := validateHealthChecks(t.SkipHealthChecks); err != nil {
+		plog.Warningf("Test %s: %v", t.Name, err)
+	}
+	checks := testHealthChecks(t)
+	if len(checks) == 0 {
+		return
+	}
+	// Every check starts out timed out, for those still running when
+	// withCollectTimeout gives up on a hung machine.
+	var mu sync.Mutex
+	var all []HealthCheckResult
+	severities := make(map[string]HealthSeverity)
+	machines := c.Machines()
+	for _, m := range machines {
+		for _, hc := range checks {
+			severities[hc.Name] = hc.Severity
+			all = append(all, HealthCheckResult{
+				MachineID: m.ID(),
+				Check:     hc.Name,
+				Status:    "timeout",
+				Error:     fmt.Sprintf("didn't finish within %v", collectTimeout),
+			})
+		}
+	}
+	withCollectTimeout(h, "health checks", func() {
+		i := 0
+		for _, m := range machines {
+			for _, hc := range checks {
+				result := HealthCheckResult{
+					MachineID: m.ID(),
+					Check:     hc.Name,
+					Status:    "pass",
+				}
+				problems, err := hc.Run(m)
+				switch {
+				case err != nil:
+					result.Status = "error"
+					result.Error = err.Error()
+				case len(problems) > 0:
+					result.Status = string(hc.Severity)
+					if len(problems) > healthCheckMaxProblems {
+						more := len(problems) - healthCheckMaxProblems
+						problems = append(problems[:healthCheckMaxProblems], fmt.Sprintf("and %d more", more))
+					}
+					result.Problems = problems
+				}
+				mu.Lock()
+				all[i] = result
+				mu.Unlock()
+				i++
+			}
+		}
+	})
+
+	// the checks may still be running in the background
+	mu.Lock()
+	results := append([]HealthCheckResult(nil), all...)
+	mu.Unlock()
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].MachineID < results[j].MachineID
+	})
+	for _, r := range results {
+		switch r.Status {
+		case "error":
+			plog.Warningf("Health check %s on machine %s of %s: %s", r.Check, r.MachineID, h.Name(), r.Error)
+		case "timeout":
+			// a hung machine mustn't let a fail check pass
+			if severities[r.Check] == HealthFail {
+				h.Errorf("Health check %s on machine %s: %s", r.Check, r.MachineID, r.Error)
+			} else {
+				plog.Warningf("Health check %s on machine %s of %s: %s", r.Check, r.MachineID, h.Name(), r.Error)
+			}
+		case string(HealthWarn):
+			plog.Warningf("Health check %s on machine %s: %s", r.Check, r.MachineID, strings.Join(r.Problems, "; "))
+		case string(HealthFail):
+			h.Errorf("Health check %s on machine %s: %s", r.Check, r.MachineID, strings.Join(r.Problems, "; "))
+		}
+	}
+	if len(results) > 0 {
+		h.SetDetail("healthChecks", results)
+	}
+}
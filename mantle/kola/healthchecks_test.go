@@ -0,0 +1,65 @@
+package kola
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKernelTaint(t *testing.T) {
+	for _, tt := range []struct {
+		out      string
+		problems []string
+		err      bool
+	}{
+		{"0\n", nil, false},
+		{"1\n", []string{"kernel tainted: 1 (P)"}, false},
+		// W, a warning, and O, an out-of-tree module
+		{"4608\n", []string{"kernel tainted: 4608 (WO)"}, false},
+		{"", nil, true},
+		{"tainted\n", nil, true},
+	} {
+		problems, err := parseKernelTaint([]byte(tt.out))
+		if (err != nil) != tt.err {
+			t.Errorf("parseKernelTaint(%q) error = %v, want error %v", tt.out, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(problems, tt.problems) {
+			t.Errorf("parseKernelTaint(%q) = %q, want %q", tt.out, problems, tt.problems)
+		}
+	}
+}
+
+func TestParseLeakedMounts(t *testing.T) {
+	for _, tt := range []struct {
+		out    string
+		leaked []string
+	}{
+		{"", nil},
+		{"/ /dev/vda4\n/boot /dev/vda3\n/var /dev/vda4[/ostree/deploy/fedora-coreos/var]\n/tmp tmpfs\n", nil},
+		{"/ /dev/vda4\n/var/mnt/data /dev/vdb1\n/mnt/iso /dev/loop0\n", []string{
+			"/dev/vdb1 mounted on /var/mnt/data",
+			"/dev/loop0 mounted on /mnt/iso",
+		}},
+		{"/srv/image /dev/loop3\n/tmpfoo /dev/vdc\n", []string{"/dev/loop3 mounted on /srv/image"}},
+	} {
+		if leaked := parseLeakedMounts([]byte(tt.out)); !reflect.DeepEqual(leaked, tt.leaked) {
+			t.Errorf("parseLeakedMounts(%q) = %q, want %q", tt.out, leaked, tt.leaked)
+		}
+	}
+}
+
+func TestParseDiskUsage(t *testing.T) {
+	for _, tt := range []struct {
+		out  string
+		full []string
+	}{
+		{"", nil},
+		{" 12% /\n 35% /boot\n", nil},
+		{" 90% /\n 91% /boot\n100% /var/lib/containers\n", []string{"/boot is 91% full", "/var/lib/containers is 100% full"}},
+		{"  -  /sys/firmware/efi/efivars\n 95%\n", nil},
+	} {
+		if full := parseDiskUsage([]byte(tt.out)); !reflect.DeepEqual(full, tt.full) {
+			t.Errorf("parseDiskUsage(%q) = %q, want %q", tt.out, full, tt.full)
+		}
+	}
+}
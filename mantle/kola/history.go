@@ -0,0 +1,87 @@
+package kola
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+)
+
+// defaultTestDuration is assumed for tests with no recorded history.
+const defaultTestDuration = time.Minute
+
+// DurationHistory is the path to a JSON report of an earlier run, used to
+// estimate how long tests take. If empty, the report left in the output
+// dir by the last run is used if there is one.
+var DurationHistory string
+
+// testDurations maps base test names to their duration in an earlier run.
+type testDurations map[string]time.Duration
+
+// previousDurations are the durations read from the report of the last
+// run by SetupOutputDir, before it cleans the output dir.
+var previousDurations testDurations
+
+// readTestDurations reads test durations from the JSON report at path.
+func readTestDurations(path string) (testDurations, error) {
+	report, err := reporters.DeserialiseReport(path)
+	if err != nil {
+		return nil, err
+	}
+	durations := make(testDurations)
+	for _, t := range report.Tests {
+		name := GetBaseTestName(t.Name)
+		if name == "" {
+			continue // skip non-exclusive test wrapper
+		}
+		// A test may be listed several times, e.g. after a rerun.
+		if t.Duration > durations[name] {
+			durations[name] = t.Duration
+		}
+	}
+	plog.Debugf("Loaded durations of %d tests from %s", len(durations), path)
+	return durations, nil
+}
+
+// savePreviousDurations keeps the durations from the report of the last
+// run in outputDir, which is about to be cleaned.
+func savePreviousDurations(outputDir string) {
+	if DurationHistory != "" {
+		return
+	}
+	path := filepath.Join(outputDir, "reports/report.json")
+	durations, err := readTestDurations(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			plog.Warningf("Ignoring test duration history %s: %v", path, err)
+		}
+		return
+	}
+	previousDurations = durations
+}
+
+// loadTestDurations returns the test durations from DurationHistory, or
+// from the last run in the output dir. A missing or unreadable history
+// is not fatal; all tests then get the default.
+func loadTestDurations() testDurations {
+	if DurationHistory != "" {
+		durations, err := readTestDurations(DurationHistory)
+		if err == nil {
+			return durations
+		}
+		plog.Warningf("Ignoring test duration history %s: %v", DurationHistory, err)
+	} else if previousDurations != nil {
+		return previousDurations
+	}
+	plog.Infof("No test duration history found, assuming each test takes %v", defaultTestDuration)
+	return make(testDurations)
+}
+
+// get returns the recorded duration of a test, or defaultTestDuration.
+func (d testDurations) get(name string) time.Duration {
+	if duration, ok := d[name]; ok && duration > 0 {
+		return duration
+	}
+	return defaultTestDuration
+}
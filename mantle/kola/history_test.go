@@ -0,0 +1,48 @@
+package kola
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetupOutputDirKeepsDurations(t *testing.T) {
+	oldPrevious, oldHistory := previousDurations, DurationHistory
+	t.Cleanup(func() { previousDurations, DurationHistory = oldPrevious, oldHistory })
+	previousDurations, DurationHistory = nil, ""
+
+	outputDir := filepath.Join(t.TempDir(), "kola")
+	if err := os.MkdirAll(filepath.Join(outputDir, "reports"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, ".harness_temp"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := `{"tests": [
+		{"name": "non-exclusive-test-bucket-0", "duration": 600000000000},
+		{"name": "non-exclusive-test-bucket-0/ext.foo", "duration": 120000000000},
+		{"name": "basic", "duration": 30000000000},
+		{"name": "basic", "duration": 45000000000}
+	]}`
+	if err := os.WriteFile(filepath.Join(outputDir, "reports/report.json"), []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetupOutputDir(outputDir, "qemu"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "reports/report.json")); !os.IsNotExist(err) {
+		t.Fatalf("old report not cleaned: %v", err)
+	}
+	durations := loadTestDurations()
+	for name, want := range map[string]time.Duration{
+		"ext.foo": 2 * time.Minute,
+		"basic":   45 * time.Second,
+		"unknown": defaultTestDuration,
+	} {
+		if got := durations.get(name); got != want {
+			t.Errorf("duration of %s = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package kola
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+var (
+	matrixAxisRe  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	matrixValueRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// testMatrix is the matrix: key of external test metadata. It maps axes
+// to their values and keeps the axes in the order they were declared,
+// so variant names are predictable.
+type testMatrix []matrixAxis
+
+type matrixAxis struct {
+	name   string
+	values []string
+}
+
+func (m *testMatrix) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var slice yaml.MapSlice
+	if err := unmarshal(&slice); err != nil {
+		return err
+	}
+	for _, item := range slice {
+		var axis matrixAxis
+		axis.name = fmt.Sprint(item.Key)
+		values, ok := item.Value.([]interface{})
+		if !ok {
+			return fmt.Errorf("matrix: %s must be a list of values", axis.name)
+		}
+		for _, v := range values {
+			axis.values = append(axis.values, fmt.Sprint(v))
+		}
+		*m = append(*m, axis)
+	}
+	return nil
+}
+
+func (m *testMatrix) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("matrix must be an object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		axis := matrixAxis{name: tok.(string)}
+		if err := dec.Decode(&axis.values); err != nil {
+			return fmt.Errorf("matrix: %s must be a list of values: %w", axis.name, err)
+		}
+		*m = append(*m, axis)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// matrixVariant is one combination of matrix values.
+type matrixVariant []matrixValue
+
+type matrixValue struct {
+	axis  string
+	value string
+}
+
+// suffix returns the variant's test name suffix, e.g. "@uefi-4k".
+func (v matrixVariant) suffix() string {
+	if len(v) == 0 {
+		return ""
+	}
+	var values []string
+	for _, mv := range v {
+		values = append(values, mv.value)
+	}
+	return "@" + strings.Join(values, "-")
+}
+
+// variants expands the matrix into all combinations of its values. An
+// empty matrix has a single empty variant.
+func (m testMatrix) variants() ([]matrixVariant, error) {
+	variants := []matrixVariant{nil}
+	seen := make(map[string]bool)
+	for _, axis := range m {
+		if !matrixAxisRe.MatchString(axis.name) {
+			return nil, fmt.Errorf("invalid matrix axis %q", axis.name)
+		}
+		if seen[axis.name] {
+			return nil, fmt.Errorf("duplicate matrix axis %q", axis.name)
+		}
+		seen[axis.name] = true
+		if len(axis.values) == 0 {
+			return nil, fmt.Errorf("matrix axis %q has no values", axis.name)
+		}
+		var expanded []matrixVariant
+		seenValues := make(map[string]bool)
+		for _, value := range axis.values {
+			if !matrixValueRe.MatchString(value) {
+				return nil, fmt.Errorf("invalid value %q for matrix axis %q", value, axis.name)
+			}
+			if seenValues[value] {
+				return nil, fmt.Errorf("duplicate value %q for matrix axis %q", value, axis.name)
+			}
+			seenValues[value] = true
+			for _, v := range variants {
+				nv := append(append(matrixVariant{}, v...), matrixValue{axis.name, value})
+				expanded = append(expanded, nv)
+			}
+		}
+		variants = expanded
+	}
+	return variants, nil
+}
+
+// apply sets the machine options of the variant on t. The firmware and
+// disk axes are understood by kola and only apply to QEMU; any other axis
+// is only passed to the test in a KOLA_MATRIX_<AXIS> environment variable.
+func (v matrixVariant) apply(t *register.Test) error {
+	qemuOnly := false
+	for _, mv := range v {
+		switch mv.axis {
+		case "firmware":
+			switch mv.value {
+			case "bios":
+				// BIOS is only available on x86_64
+				if len(t.Architectures) == 0 && len(t.ExcludeArchitectures) == 0 {
+					t.Architectures = []string{"x86_64"}
+				}
+			case "uefi":
+			case "uefi-secure":
+				// copy, the slice is shared with the other variants
+				t.Requires = append(append([]string{}, t.Requires...), RequireUEFISecure)
+			default:
+				return fmt.Errorf("unknown firmware %q in matrix", mv.value)
+			}
+			t.Firmware = mv.value
+			qemuOnly = true
+		case "disk":
+			switch mv.value {
+			case "default":
+			case "4k":
+				t.Native4k = true
+			case "mpath":
+				t.MultiPathDisk = true
+			default:
+				return fmt.Errorf("unknown disk %q in matrix, expected default, 4k or mpath", mv.value)
+			}
+			qemuOnly = true
+		}
+	}
+	if !qemuOnly {
+		return nil
+	}
+	if t.NonExclusive {
+		return fmt.Errorf("matrix axes firmware and disk require an exclusive test")
+	}
+	if len(t.Platforms) == 0 {
+		t.Platforms = []string{"qemu"}
+	} else if !HasString("qemu", t.Platforms) {
+		return fmt.Errorf("matrix axes firmware and disk only apply to qemu")
+	}
+	return nil
+}
+
+// environment returns the unit Environment= lines describing the variant.
+func (v matrixVariant) environment() string {
+	if len(v) == 0 {
+		return ""
+	}
+	env := fmt.Sprintf("Environment=KOLA_VARIANT=%s\n", strings.TrimPrefix(v.suffix(), "@"))
+	for _, mv := range v {
+		env += fmt.Sprintf("Environment=KOLA_MATRIX_%s=%s\n", strings.ToUpper(mv.axis), mv.value)
+	}
+	return env
+}
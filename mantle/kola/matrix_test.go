@@ -0,0 +1,180 @@
+package kola
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+func TestTestMatrixUnmarshal(t *testing.T) {
+	want := testMatrix{
+		{"firmware", []string{"bios", "uefi"}},
+		{"disk", []string{"default", "4k"}},
+	}
+	var fromYAML struct {
+		Matrix testMatrix `yaml:"matrix"`
+	}
+	if err := yaml.Unmarshal([]byte("matrix:\n  firmware: [bios, uefi]\n  disk: [default, 4k]\n"), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML.Matrix, want) {
+		t.Errorf("YAML matrix = %v, want %v", fromYAML.Matrix, want)
+	}
+	var fromJSON struct {
+		Matrix testMatrix `json:"matrix"`
+	}
+	if err := json.Unmarshal([]byte(`{"matrix": {"firmware": ["bios", "uefi"], "disk": ["default", "4k"]}}`), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON.Matrix, want) {
+		t.Errorf("JSON matrix = %v, want %v", fromJSON.Matrix, want)
+	}
+
+	if err := yaml.Unmarshal([]byte("matrix:\n  firmware: bios\n"), &fromYAML); err == nil {
+		t.Errorf("YAML matrix with a scalar axis accepted")
+	}
+	if err := json.Unmarshal([]byte(`{"matrix": {"firmware": "bios"}}`), &fromJSON); err == nil {
+		t.Errorf("JSON matrix with a scalar axis accepted")
+	}
+}
+
+func TestTestMatrixVariants(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		matrix   testMatrix
+		suffixes []string
+		err      bool
+	}{
+		{"empty", nil, []string{""}, false},
+		{"one axis", testMatrix{{"firmware", []string{"bios", "uefi"}}}, []string{"@bios", "@uefi"}, false},
+		{"two axes", testMatrix{
+			{"firmware", []string{"bios", "uefi"}},
+			{"disk", []string{"default", "4k"}},
+		}, []string{"@bios-default", "@uefi-default", "@bios-4k", "@uefi-4k"}, false},
+		{"invalid axis", testMatrix{{"fire-ware", []string{"bios"}}}, nil, true},
+		{"duplicate axis", testMatrix{{"disk", []string{"4k"}}, {"disk", []string{"mpath"}}}, nil, true},
+		{"no values", testMatrix{{"disk", nil}}, nil, true},
+		{"invalid value", testMatrix{{"disk", []string{"4k/512"}}}, nil, true},
+		{"duplicate value", testMatrix{{"disk", []string{"4k", "4k"}}}, nil, true},
+	} {
+		variants, err := tt.matrix.variants()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: variants() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		var suffixes []string
+		for _, v := range variants {
+			suffixes = append(suffixes, v.suffix())
+		}
+		if !reflect.DeepEqual(suffixes, tt.suffixes) {
+			t.Errorf("%s: variants() = %q, want %q", tt.name, suffixes, tt.suffixes)
+		}
+	}
+}
+
+func TestMatrixVariantApply(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		variant matrixVariant
+		test    register.Test
+		want    register.Test
+		err     bool
+	}{
+		{
+			name:    "other axes don't change the test",
+			variant: matrixVariant{{"runtime", "crun"}},
+			test:    register.Test{NonExclusive: true},
+			want:    register.Test{NonExclusive: true},
+		},
+		{
+			name:    "uefi 4k",
+			variant: matrixVariant{{"firmware", "uefi"}, {"disk", "4k"}},
+			want:    register.Test{Firmware: "uefi", Native4k: true, Platforms: []string{"qemu"}},
+		},
+		{
+			name:    "bios is x86_64 only",
+			variant: matrixVariant{{"firmware", "bios"}},
+			want:    register.Test{Firmware: "bios", Architectures: []string{"x86_64"}, Platforms: []string{"qemu"}},
+		},
+		{
+			name:    "bios keeps the test's architectures",
+			variant: matrixVariant{{"firmware", "bios"}},
+			test:    register.Test{ExcludeArchitectures: []string{"s390x"}},
+			want:    register.Test{Firmware: "bios", ExcludeArchitectures: []string{"s390x"}, Platforms: []string{"qemu"}},
+		},
+		{
+			name:    "uefi-secure requires the firmware",
+			variant: matrixVariant{{"firmware", "uefi-secure"}},
+			test:    register.Test{Requires: []string{RequireKVM}},
+			want:    register.Test{Firmware: "uefi-secure", Requires: []string{RequireKVM, RequireUEFISecure}, Platforms: []string{"qemu"}},
+		},
+		{
+			name:    "mpath",
+			variant: matrixVariant{{"disk", "mpath"}},
+			test:    register.Test{Platforms: []string{"qemu", "aws"}},
+			want:    register.Test{MultiPathDisk: true, Platforms: []string{"qemu", "aws"}},
+		},
+		{
+			name:    "default disk on qemu",
+			variant: matrixVariant{{"disk", "default"}},
+			want:    register.Test{Platforms: []string{"qemu"}},
+		},
+		{
+			name:    "unknown firmware",
+			variant: matrixVariant{{"firmware", "coreboot"}},
+			err:     true,
+		},
+		{
+			name:    "unknown disk",
+			variant: matrixVariant{{"disk", "nvme"}},
+			err:     true,
+		},
+		{
+			name:    "non-exclusive",
+			variant: matrixVariant{{"disk", "4k"}},
+			test:    register.Test{NonExclusive: true},
+			err:     true,
+		},
+		{
+			name:    "not on qemu",
+			variant: matrixVariant{{"firmware", "uefi"}},
+			test:    register.Test{Platforms: []string{"aws"}},
+			err:     true,
+		},
+	} {
+		test := tt.test
+		err := tt.variant.apply(&test)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: apply() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(test, tt.want) {
+			t.Errorf("%s: apply() = %+v, want %+v", tt.name, test, tt.want)
+		}
+	}
+
+	// the variants of a test share its Requires
+	requires := []string{RequireKVM}
+	first := register.Test{Requires: requires[:1:1]}
+	if err := (matrixVariant{{"firmware", "uefi-secure"}}).apply(&first); err != nil {
+		t.Fatal(err)
+	}
+	if len(requires) != 1 || requires[0] != RequireKVM {
+		t.Errorf("apply() changed the shared Requires: %q", requires)
+	}
+}
+
+func TestMatrixVariantEnvironment(t *testing.T) {
+	if env := (matrixVariant{}).environment(); env != "" {
+		t.Errorf("environment of the empty variant = %q", env)
+	}
+	v := matrixVariant{{"firmware", "uefi"}, {"disk", "4k"}}
+	want := "Environment=KOLA_VARIANT=uefi-4k\nEnvironment=KOLA_MATRIX_FIRMWARE=uefi\nEnvironment=KOLA_MATRIX_DISK=4k\n"
+	if env := v.environment(); env != want {
+		t.Errorf("environment() = %q, want %q", env, want)
+	}
+}
@@ -0,0 +1,196 @@
+package kola
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	coreosarch "github.com/coreos/stream-metadata-go/arch"
+	"golang.org/x/sys/unix"
+
+	cosa "github.com/coreos/coreos-assembler/pkg/builds"
+)
+
+// InvocationFlags holds the command line flags given to kola, by name;
+// flags which take a list have all their values. Set by cmd/kola.
+var InvocationFlags map[string][]string
+
+// appliedDenyList is the kola-denylist.yaml entries which applied to the
+// run, set by ParseDenyListYaml.
+var appliedDenyList []DenyListObj
+
+// Provenance describes a kola run well enough to reproduce it: how kola
+// was invoked, the build it tested and the host it ran on. It's stored
+// in the JSON report; see kola rerun.
+type Provenance struct {
+	Invocation InvocationProvenance `json:"invocation"`
+	Build      *BuildProvenance     `json:"build,omitempty"`
+	Host       HostProvenance       `json:"host"`
+}
+
+// InvocationProvenance is the command line and the effective options.
+type InvocationProvenance struct {
+	Args     []string            `json:"args"`
+	Flags    map[string][]string `json:"flags,omitempty"`
+	Platform string              `json:"platform"`
+	Patterns []string            `json:"patterns"`
+	Parallel int                 `json:"parallel"`
+	Sharding string              `json:"sharding,omitempty"`
+	// Denylist is the kola-denylist.yaml entries which applied
+	Denylist         []DenyListObj `json:"denylist,omitempty"`
+	DenylistedTests  []string      `json:"denylistedTests,omitempty"`
+	WarnOnErrorTests []string      `json:"warnOnErrorTests,omitempty"`
+}
+
+// BuildProvenance identifies the cosa build under test.
+type BuildProvenance struct {
+	ID           string `json:"id"`
+	Arch         string `json:"arch"`
+	OstreeCommit string `json:"ostreeCommit"`
+	// Images maps image names to their SHA256
+	Images map[string]string `json:"images,omitempty"`
+	// CosaGitRev is the git commit of the coreos-assembler container
+	// image which made the build
+	CosaGitRev string `json:"cosaGitRev,omitempty"`
+	ConfigRev  string `json:"configGitRev,omitempty"`
+	// QEMUImage is the image given with --qemu-image, if any
+	QEMUImage string `json:"qemuImage,omitempty"`
+}
+
+// HostProvenance describes the host kola ran on.
+type HostProvenance struct {
+	Kernel   string   `json:"kernel"`
+	CPUModel string   `json:"cpuModel,omitempty"`
+	QEMU     string   `json:"qemu,omitempty"`
+	OVMF     []string `json:"ovmf,omitempty"`
+	// CosaGitRev is the git commit of the running coreos-assembler,
+	// from its container image
+	CosaGitRev string `json:"cosaGitRev,omitempty"`
+}
+
+// collectProvenance gathers the provenance of a run of patterns on
+// pltfrm. Missing host details are left out rather than failing the run.
+func collectProvenance(patterns []string, pltfrm string) Provenance {
+	p := Provenance{
+		Invocation: InvocationProvenance{
+			Args:             os.Args,
+			Flags:            InvocationFlags,
+			Platform:         pltfrm,
+			Patterns:         patterns,
+			Parallel:         TestParallelism,
+			Sharding:         Sharding,
+			Denylist:         appliedDenyList,
+			DenylistedTests:  DenylistedTests,
+			WarnOnErrorTests: WarnOnErrorTests,
+		},
+		Host: hostProvenance(pltfrm),
+	}
+	if CosaBuild != nil {
+		p.Build = &BuildProvenance{
+			ID:           CosaBuild.Meta.BuildID,
+			Arch:         CosaBuild.Arch,
+			OstreeCommit: CosaBuild.Meta.OstreeCommit,
+			Images:       imageChecksums(CosaBuild.Meta),
+			ConfigRev:    CosaBuild.Meta.ConfigGitRev,
+		}
+		if git := CosaBuild.Meta.CosaContainerImageGit; git != nil {
+			p.Build.CosaGitRev = git.Commit
+		}
+	}
+	if p.Build != nil || QEMUOptions.DiskImage != "" {
+		if p.Build == nil {
+			p.Build = &BuildProvenance{Arch: Options.CosaBuildArch}
+		}
+		p.Build.QEMUImage = QEMUOptions.DiskImage
+	}
+	return p
+}
+
+// imageChecksums returns the SHA256 of each image of a build.
+func imageChecksums(meta *cosa.Build) map[string]string {
+	buf, err := json.Marshal(meta.BuildArtifacts)
+	if err != nil {
+		return nil
+	}
+	var artifacts map[string]*cosa.Artifact
+	if err := json.Unmarshal(buf, &artifacts); err != nil {
+		return nil
+	}
+	sums := make(map[string]string)
+	for name, artifact := range artifacts {
+		if artifact != nil && artifact.Sha256 != "" {
+			sums[name] = artifact.Sha256
+		}
+	}
+	return sums
+}
+
+func hostProvenance(pltfrm string) HostProvenance {
+	var h HostProvenance
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		h.Kernel = unix.ByteSliceToString(uts.Release[:])
+	}
+	h.CPUModel = cpuModel()
+	if strings.HasPrefix(pltfrm, "qemu") {
+		h.QEMU = qemuVersion()
+		for _, pattern := range []string{"/usr/share/edk2/ovmf/OVMF_*.fd", "/usr/share/edk2/aarch64/QEMU_EFI*"} {
+			paths, _ := filepath.Glob(pattern)
+			h.OVMF = append(h.OVMF, paths...)
+		}
+	}
+	// cosa's own build metadata, in its container image
+	if buf, err := os.ReadFile("/cosa/coreos-assembler-git.json"); err == nil {
+		var meta struct {
+			Git struct {
+				Commit string `json:"commit"`
+			} `json:"git"`
+		}
+		if json.Unmarshal(buf, &meta) == nil {
+			h.CosaGitRev = meta.Git.Commit
+		}
+	}
+	return h
+}
+
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// "model name" on x86_64, "cpu" on ppc64le
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if key == "model name" || key == "cpu" {
+			return strings.TrimSpace(val)
+		}
+	}
+	return ""
+}
+
+func qemuVersion() string {
+	binary := map[string]string{
+		"x86_64":  "qemu-system-x86_64",
+		"aarch64": "qemu-system-aarch64",
+		"s390x":   "qemu-system-s390x",
+		"ppc64le": "qemu-system-ppc64",
+	}[coreosarch.CurrentRpmArch()]
+	if binary == "" {
+		return ""
+	}
+	out, err := exec.Command(binary, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return line
+}
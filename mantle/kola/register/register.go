@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/coreos/coreos-assembler/mantle/harness"
 	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
 	"github.com/coreos/coreos-assembler/mantle/platform/conf"
 )
@@ -30,6 +31,9 @@ const (
 	NoInstanceCreds                   // don't grant credentials (AWS instance profile, GCP service account) to the instance
 	NoEmergencyShellCheck             // don't check console output for emergency shell invocation
 	AllowConfigWarnings               // ignore Ignition and Butane warnings instead of failing
+	FirstBootSnapshot                 // start qemu machines from a state saved after the first boot of a machine with the same userdata
+	NoHealthChecks                    // don't run the post-test health checks on the machines
+	NoMachinePool                     // don't take qemu machines from the machine pool; set for benchmarks, which time booting
 )
 
 // NativeFuncWrap is a wrapper for the NativeFunc which includes an optional string of arches and/or distributions to
@@ -53,6 +57,7 @@ type Test struct {
 	Name                 string // should be unique
 	Subtests             []string
 	Run                  func(cluster.TestCluster)
+	Benchmark            func(*harness.B, cluster.TestCluster) // set instead of Run for tests registered with RegisterBenchmark
 	NativeFuncs          map[string]NativeFuncWrap
 	UserData             *conf.UserData
 	ClusterSize          int
@@ -73,6 +78,13 @@ type Test struct {
 	// Whether the primary disk is multipathed.
 	MultiPathDisk bool
 
+	// Firmware to boot the test's machines with, overriding the default
+	// (qemu only).
+	Firmware string
+
+	// Whether the primary disk uses 4k sectors (qemu only).
+	Native4k bool
+
 	// Sizes of additional empty disks to attach to the node, followed by
 	// comma-separated list of optional options (e.g. ["1G",
 	// "5G:mpath,foo,bar"]) -- defaults to none.
@@ -112,6 +124,31 @@ type Test struct {
 	// Conflicts is non-empty iff nonexclusive is true
 	// Contains the tests that conflict with this particular test
 	Conflicts []string
+
+	// Requires lists host capabilities the test needs when run on QEMU,
+	// e.g. "kvm", "swtpm", "uefi-secure", "nested-virt", "ipv6" or
+	// "min-host-memory=8G"; the test is skipped on hosts lacking them.
+	Requires []string
+
+	// Locks names host resources the test needs to itself, e.g. a fixed
+	// host port; tests sharing a lock never run at the same time.
+	Locks []string
+
+	// Priority orders tests when a run has a time budget; tests with
+	// a higher priority are picked first. Defaults to 0.
+	Priority int
+
+	// Packages lists the packages the test covers, globs allowed; with
+	// kola run --affected-by, the test only runs if one of them changed.
+	Packages []string
+
+	// CollectPaths are absolute paths, globs allowed, copied from each
+	// machine into the test's output dir after the test, pass or fail.
+	CollectPaths []string
+
+	// SkipHealthChecks names post-test health checks not to run on the
+	// machines of the test, e.g. "avc-denials"; see also NoHealthChecks.
+	SkipHealthChecks []string
 }
 
 // Registered tests that run as part of `kola run` live here. Mapping of names
@@ -122,6 +159,10 @@ var Tests = map[string]*Test{}
 // names to tests.
 var UpgradeTests = map[string]*Test{}
 
+// Registered benchmarks that run as part of `kola bench` live here. Mapping
+// of names to tests.
+var Benchmarks = map[string]*Test{}
+
 // Register is usually called via init() functions and is how kola test
 // harnesses knows which tests it can choose from. Panics if existing name is
 // registered
@@ -144,6 +185,16 @@ func RegisterUpgradeTest(t *Test) {
 	Register(UpgradeTests, t)
 }
 
+func RegisterBenchmark(t *Test) {
+	if t.Benchmark == nil {
+		panic(fmt.Sprintf("benchmark %v has no Benchmark function", t.Name))
+	}
+	if !t.HasFlag(NoMachinePool) {
+		t.Flags = append(t.Flags, NoMachinePool)
+	}
+	Register(Benchmarks, t)
+}
+
 func (t *Test) HasFlag(flag Flag) bool {
 	for _, f := range t.Flags {
 		if f == flag {
@@ -2,6 +2,7 @@ package registry
 
 // Tests imported for registration side effects. These make up the OS test suite and is explicitly imported from the main package.
 import (
+	_ "github.com/coreos/coreos-assembler/mantle/kola/tests/bench"
 	_ "github.com/coreos/coreos-assembler/mantle/kola/tests/coretest"
 	_ "github.com/coreos/coreos-assembler/mantle/kola/tests/crio"
 	_ "github.com/coreos/coreos-assembler/mantle/kola/tests/etcd"
@@ -0,0 +1,184 @@
+package kola
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	coreosarch "github.com/coreos/stream-metadata-go/arch"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/system"
+)
+
+// Host capabilities a test can require, see register.Test.Requires.
+const (
+	RequireKVM        = "kvm"
+	RequireSwtpm      = "swtpm"
+	RequireUEFISecure = "uefi-secure"
+	RequireNestedVirt = "nested-virt"
+	RequireIPv6       = "ipv6"
+	// RequireMinHostMemory takes a size, e.g. min-host-memory=8G
+	RequireMinHostMemory = "min-host-memory"
+)
+
+// hostCapabilities is what the host running kola can provide to QEMU
+// tests, probed once per run.
+type hostCapabilities struct {
+	kvm        bool
+	swtpm      bool
+	uefiSecure bool
+	nestedVirt bool
+	ipv6       bool
+	memoryMiB  uint
+}
+
+var (
+	hostCapsOnce sync.Once
+	hostCaps     hostCapabilities
+)
+
+func getHostCapabilities() hostCapabilities {
+	hostCapsOnce.Do(func() {
+		hostCaps = probeHostCapabilities()
+		plog.Debugf("Host capabilities: %+v", hostCaps)
+	})
+	return hostCaps
+}
+
+func probeHostCapabilities() hostCapabilities {
+	var caps hostCapabilities
+	if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+		f.Close()
+		caps.kvm = true
+	}
+	if _, err := exec.LookPath("swtpm"); err == nil {
+		caps.swtpm = true
+	}
+	// see QemuBuilder.setupUefi
+	if coreosarch.CurrentRpmArch() == "x86_64" {
+		caps.uefiSecure = fileExists("/usr/share/edk2/ovmf/OVMF_CODE.secboot.fd") &&
+			fileExists("/usr/share/edk2/ovmf/OVMF_VARS.secboot.fd")
+	}
+	for _, path := range []string{
+		"/sys/module/kvm_intel/parameters/nested",
+		"/sys/module/kvm_amd/parameters/nested",
+		"/sys/module/kvm/parameters/nested",
+	} {
+		if buf, err := os.ReadFile(path); err == nil {
+			val := strings.TrimSpace(string(buf))
+			if val == "Y" || val == "1" {
+				caps.nestedVirt = true
+			}
+		}
+	}
+	if buf, err := os.ReadFile("/proc/sys/net/ipv6/conf/all/disable_ipv6"); err == nil {
+		caps.ipv6 = strings.TrimSpace(string(buf)) == "0"
+	}
+	if memory, err := system.GetTotalMemoryMiB(); err == nil {
+		caps.memoryMiB = memory
+	} else {
+		plog.Warningf("Detecting host memory: %v", err)
+	}
+	return caps
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseMemorySize parses a size in MiB, or GiB with a G suffix.
+func parseMemorySize(val string) (uint, error) {
+	mult := uint64(1)
+	if strings.HasSuffix(val, "G") {
+		mult = 1024
+		val = strings.TrimSuffix(val, "G")
+	} else {
+		val = strings.TrimSuffix(val, "M")
+	}
+	n, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+	return uint(n * mult), nil
+}
+
+// ValidateRequirement checks that req is a known host capability.
+func ValidateRequirement(req string) error {
+	name, val, hasVal := strings.Cut(req, "=")
+	switch name {
+	case RequireKVM, RequireSwtpm, RequireUEFISecure, RequireNestedVirt, RequireIPv6:
+		if hasVal {
+			return fmt.Errorf("requirement %q takes no value", name)
+		}
+	case RequireMinHostMemory:
+		if _, err := parseMemorySize(val); err != nil {
+			return fmt.Errorf("requirement %q: %w", req, err)
+		}
+	default:
+		return fmt.Errorf("unknown requirement %q", req)
+	}
+	return nil
+}
+
+// requirementsApply returns whether host requirements are checked on
+// pltfrm. Only QEMU tests run on the host; on cloud platforms the
+// requirements are up to the instance type.
+func requirementsApply(pltfrm string) bool {
+	return pltfrm == "qemu" || pltfrm == "qemu-iso"
+}
+
+// unmetRequirements returns the reasons the host can't satisfy the
+// requirements of t, if any.
+func unmetRequirements(t *register.Test) []string {
+	return UnmetRequirements(t.Requires)
+}
+
+// UnmetRequirements returns the reasons the host can't satisfy requires,
+// if any.
+func UnmetRequirements(requires []string) []string {
+	if len(requires) == 0 {
+		return nil
+	}
+	caps := getHostCapabilities()
+	var unmet []string
+	for _, req := range requires {
+		name, val, _ := strings.Cut(req, "=")
+		switch name {
+		case RequireKVM:
+			if !caps.kvm {
+				unmet = append(unmet, "/dev/kvm is not available")
+			}
+		case RequireSwtpm:
+			if !caps.swtpm {
+				unmet = append(unmet, "swtpm is not installed")
+			}
+		case RequireUEFISecure:
+			if !caps.uefiSecure {
+				unmet = append(unmet, "OVMF secure boot firmware is not available")
+			}
+		case RequireNestedVirt:
+			if !caps.nestedVirt {
+				unmet = append(unmet, "nested virtualization is not enabled")
+			}
+		case RequireIPv6:
+			if !caps.ipv6 {
+				unmet = append(unmet, "IPv6 is disabled")
+			}
+		case RequireMinHostMemory:
+			min, err := parseMemorySize(val)
+			if err != nil {
+				unmet = append(unmet, fmt.Sprintf("requirement %q: %v", req, err))
+			} else if caps.memoryMiB < min {
+				unmet = append(unmet, fmt.Sprintf("host has %d MiB of memory, %d MiB required", caps.memoryMiB, min))
+			}
+		default:
+			unmet = append(unmet, fmt.Sprintf("unknown requirement %q", req))
+		}
+	}
+	return unmet
+}
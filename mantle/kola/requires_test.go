@@ -0,0 +1,54 @@
+package kola
+
+import "testing"
+
+func TestParseMemorySize(t *testing.T) {
+	for _, tt := range []struct {
+		val  string
+		size uint
+		err  bool
+	}{
+		{"512", 512, false},
+		{"512M", 512, false},
+		{"8G", 8192, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"8T", 0, true},
+		{"-1G", 0, true},
+		{"1.5G", 0, true},
+		{"8GB", 0, true},
+	} {
+		size, err := parseMemorySize(tt.val)
+		if (err != nil) != tt.err {
+			t.Errorf("parseMemorySize(%q) error = %v, want error %v", tt.val, err, tt.err)
+			continue
+		}
+		if size != tt.size {
+			t.Errorf("parseMemorySize(%q) = %d, want %d", tt.val, size, tt.size)
+		}
+	}
+}
+
+func TestValidateRequirement(t *testing.T) {
+	for _, tt := range []struct {
+		req string
+		err bool
+	}{
+		{"kvm", false},
+		{"swtpm", false},
+		{"uefi-secure", false},
+		{"nested-virt", false},
+		{"ipv6", false},
+		{"min-host-memory=8G", false},
+		{"min-host-memory=4096", false},
+		{"kvm=1", true},
+		{"min-host-memory", true},
+		{"min-host-memory=lots", true},
+		{"tpm", true},
+		{"", true},
+	} {
+		if err := ValidateRequirement(tt.req); (err != nil) != tt.err {
+			t.Errorf("ValidateRequirement(%q) error = %v, want error %v", tt.req, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	tutil "github.com/coreos/coreos-assembler/mantle/kola/tests/util"
+)
+
+func init() {
+	register.RegisterBenchmark(&register.Test{
+		Benchmark:   benchBoot,
+		ClusterSize: 0,
+		Name:        "bench.boot",
+		Description: "Measure the time until a new machine is reachable over SSH, and its boot time by phase.",
+		Timeout:     30 * time.Minute,
+	})
+	register.RegisterBenchmark(&register.Test{
+		Benchmark:   benchRpmOstreeUpgrade,
+		ClusterSize: 1,
+		Name:        "bench.rpmostree.upgrade",
+		Description: "Measure the duration of an rpm-ostree upgrade to a local commit.",
+		Tags:        []string{"rpm-ostree", "upgrade"},
+		Timeout:     30 * time.Minute,
+	})
+	register.RegisterBenchmark(&register.Test{
+		Benchmark:   benchPodmanStart,
+		ClusterSize: 1,
+		Name:        "bench.podman.start",
+		Description: "Measure the latency of starting a container with podman.",
+		Distros:     []string{"fcos", "nestos"},
+		Timeout:     30 * time.Minute,
+	})
+}
+
+// phaseRe matches a phase of the output of systemd-analyze time, e.g.
+// "1.234s (kernel)" or "1min 3.2s (userspace)".
+var phaseRe = regexp.MustCompile(`([0-9][0-9a-z. ]*) \((\w+)\)`)
+
+// parseBootPhases parses the output of systemd-analyze time into the
+// duration of each boot phase.
+func parseBootPhases(out string) (map[string]time.Duration, error) {
+	line, _, _ := strings.Cut(out, "\n")
+	matches := phaseRe.FindAllStringSubmatch(line, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no boot phases in %q", line)
+	}
+	phases := make(map[string]time.Duration)
+	for _, m := range matches {
+		// e.g. "1min 3.2s" -> "1m3.2s"
+		s := strings.ReplaceAll(strings.ReplaceAll(m[1], "min", "m"), " ", "")
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s time %q: %w", m[2], m[1], err)
+		}
+		phases[m[2]] = d
+	}
+	return phases, nil
+}
+
+// benchBoot times creating a machine until it's reachable over SSH, and
+// reports the boot phases measured by systemd.
+func benchBoot(b *harness.B, c cluster.TestCluster) {
+	for b.Loop() {
+		m, err := c.NewMachine(nil)
+		b.StopTimer()
+		if err != nil {
+			c.Fatalf("creating machine: %v", err)
+		}
+		out := c.MustSSH(m, "systemctl is-system-running --wait >/dev/null; systemd-analyze time")
+		phases, err := parseBootPhases(string(out))
+		if err != nil {
+			c.Fatal(err)
+		}
+		var total time.Duration
+		for phase, d := range phases {
+			b.ReportMetric(d.Seconds(), phase+"-sec")
+			total += d
+		}
+		b.ReportMetric(total.Seconds(), "boot-sec")
+		m.Destroy()
+	}
+}
+
+// benchRpmOstreeUpgrade times upgrading to a new commit of a local branch,
+// as in rpmostree.upgrade-rollback.
+func benchRpmOstreeUpgrade(b *harness.B, c cluster.TestCluster) {
+	m := c.Machines()[0]
+	branch := "local-branch"
+
+	status, err := tutil.GetRpmOstreeStatusJSON(c, m)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if len(status.Deployments) < 1 {
+		c.Fatalf(`Unexpected results from "rpm-ostree status"; received: %v`, status)
+	}
+	csum := status.Deployments[0].Checksum
+	c.RunCmdSync(m, "sudo systemctl mask --now zincati")
+	c.RunCmdSyncf(m, "sudo ostree refs --create %s %s", branch, csum)
+	c.RunCmdSyncf(m, "sudo rpm-ostree rebase :%s", branch)
+
+	i := 0
+	for b.Loop() {
+		b.StopTimer()
+		i++
+		c.RunCmdSyncf(m, "sudo ostree commit -b %s --tree ref=%s --add-metadata-string version=kola-bench-%d", branch, csum, i)
+		b.StartTimer()
+		c.RunCmdSync(m, "sudo rpm-ostree upgrade")
+		b.StopTimer()
+		c.RunCmdSync(m, "sudo rpm-ostree cleanup -p")
+	}
+}
+
+// benchPodmanStart times running a command in a new container.
+func benchPodmanStart(b *harness.B, c cluster.TestCluster) {
+	m := c.Machines()[0]
+	tutil.GenPodmanScratchContainer(c, m, "echo", []string{"echo"})
+	// the first run isn't representative, it sets up container storage
+	c.RunCmdSync(m, "sudo podman run --net=none --rm echo echo 1")
+
+	for b.Loop() {
+		c.RunCmdSync(m, "sudo podman run --net=none --rm echo echo 1")
+	}
+}
@@ -43,6 +43,7 @@ func init() {
 		Description: "Verify basic functionalities like SSH, systemd services, useradd, etc.",
 		Run:         LocalTests,
 		ClusterSize: 1,
+		Tags:        []string{kola.SmokeTag},
 		NativeFuncs: map[string]register.NativeFuncWrap{
 			"PortSSH":        register.CreateNativeFuncWrap(TestPortSsh),
 			"DbusPerms":      register.CreateNativeFuncWrap(TestDbusPerms),
@@ -27,6 +27,7 @@ func init() {
 		Flags:       []register.Flag{},
 		Distros:     []string{"rhcos"},
 		Tags:        []string{"luks", "tang", kola.NeedsInternetTag, "reprovision"},
+		Packages:    []string{"clevis", "cryptsetup"},
 	})
 	register.RegisterTest(&register.Test{
 		Run:                  luksSSST1Test,
@@ -37,6 +38,8 @@ func init() {
 		Distros:              []string{"rhcos"},
 		Platforms:            []string{"qemu"},
 		ExcludeArchitectures: []string{"s390x"}, // no TPM backend support for s390x
+		Requires:             []string{kola.RequireSwtpm},
+		Packages:             []string{"clevis", "cryptsetup"},
 		Tags:                 []string{"luks", "tpm", "tang", "sss", kola.NeedsInternetTag, "reprovision"},
 	})
 	register.RegisterTest(&register.Test{
@@ -48,6 +51,8 @@ func init() {
 		Distros:              []string{"rhcos"},
 		Platforms:            []string{"qemu"},
 		ExcludeArchitectures: []string{"s390x"}, // no TPM backend support for s390x
+		Requires:             []string{kola.RequireSwtpm},
+		Packages:             []string{"clevis", "cryptsetup"},
 		Tags:                 []string{"luks", "tpm", "tang", "sss", kola.NeedsInternetTag, "reprovision"},
 	})
 }
@@ -19,6 +19,7 @@ func init() {
 		Distros:     []string{"nestos"},
 		RequiredTag: "isula",
 		Tags:        []string{"isula", kola.NeedsInternetTag},
+		Packages:    []string{"iSulad"},
 	})
 	register.RegisterTest(&register.Test{
 		Run:         isulaWorkflow,
@@ -28,6 +29,7 @@ func init() {
 		FailFast:    true,
 		RequiredTag: "isula",
 		Tags:        []string{"isula", kola.NeedsInternetTag},
+		Packages:    []string{"iSulad"},
 	})
 }
 
@@ -86,6 +86,7 @@ func init() {
 		// gets resolved.
 		ExcludeFirmwares: []string{"uefi"},
 		Tags:             []string{"boot-mirror", "luks", "raid1", "tpm2", kola.NeedsInternetTag, "reprovision"},
+		Packages:         []string{"clevis", "cryptsetup"},
 		FailFast:         true,
 		Timeout:          15 * time.Minute,
 	})
@@ -16,8 +16,12 @@ package podman
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -145,8 +149,24 @@ func podmanWorkflow(c cluster.TestCluster) {
 		out := c.MustSSH(m, cmd)
 		id = string(out)[0:64]
 
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+					return m.DialGuest(network, addr)
+				},
+			},
+			Timeout: 10 * time.Second,
+		}
 		podIsRunning := func() error {
-			b, err := c.SSH(m, `curl -f http://localhost 2>/dev/null`)
+			resp, err := client.Get("http://localhost")
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("nginx pod returned %s", resp.Status)
+			}
+			b, err := io.ReadAll(resp.Body)
 			if err != nil {
 				return err
 			}
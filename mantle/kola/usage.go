@@ -0,0 +1,88 @@
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// MachineResourceUsage is the resources a machine of a test used, as
+// recorded in the "resources" detail of the test in the report.
+type MachineResourceUsage struct {
+	MachineID string `json:"machineId"`
+	*platform.QemuResourceUsage
+	// GuestMemoryTotalMiB and GuestMemoryUsedMiB are reported by the
+	// guest at the end of the test; used memory excludes caches
+	GuestMemoryTotalMiB uint64 `json:"guestMemoryTotalMiB,omitempty"`
+	GuestMemoryUsedMiB  uint64 `json:"guestMemoryUsedMiB,omitempty"`
+}
+
+// collectResourceUsage records the resources used by each QEMU machine of
+// c still running at the end of the test. Machines the test destroyed
+// itself aren't included.
+func collectResourceUsage(h *harness.H, c platform.Cluster) {
+	var mu sync.Mutex
+	var all []MachineResourceUsage
+	withCollectTimeout(h, "resource usage", func() {
+		for _, m := range c.Machines() {
+			qm, ok := m.(platform.QEMUMachine)
+			if !ok {
+				continue
+			}
+			usage, err := qm.ResourceUsage()
+			if err != nil {
+				plog.Warningf("Collecting resource usage of machine %s: %v", m.ID(), err)
+				continue
+			}
+			entry := MachineResourceUsage{
+				MachineID:         m.ID(),
+				QemuResourceUsage: usage,
+			}
+			if total, used, err := guestMemory(m); err == nil {
+				entry.GuestMemoryTotalMiB = total
+				entry.GuestMemoryUsedMiB = used
+			} else {
+				plog.Debugf("Reading guest memory of machine %s: %v", m.ID(), err)
+			}
+			mu.Lock()
+			all = append(all, entry)
+			mu.Unlock()
+		}
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(all) > 0 {
+		h.SetDetail("resources", all)
+	}
+}
+
+// guestMemory returns the total and used memory of a machine in MiB, as
+// reported by its kernel.
+func guestMemory(m platform.Machine) (uint64, uint64, error) {
+	out, _, err := m.SSH("cat /proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseMeminfo(out)
+}
+
+// parseMeminfo returns the total and used memory in MiB of the contents
+// of /proc/meminfo.
+func parseMeminfo(meminfo []byte) (uint64, uint64, error) {
+	values, err := platform.ParseKeyValues(bytes.NewReader(meminfo))
+	if err != nil {
+		return 0, 0, err
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	available := values["MemAvailable"]
+	if total < available {
+		available = total
+	}
+	return total / 1024, (total - available) / 1024, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// rpmver compares versions the way rpm does.
+//
+// Compare implements rpmvercmp: versions are split into alternating runs
+// of digits and letters, digit runs are compared numerically and letter
+// runs lexically, a digit run is newer than a letter run, and a tilde
+// sorts before anything, even the end of the version. For example:
+//
+//	1.0~rc1 < 1.0 < 1.0a < 1.0.1 < 1.1 < 1.10
+//
+// This also gives the expected order for ostree versions and cosa build
+// IDs such as 39.20240101.3.0.
+package rpmver
+
+import (
+	"strings"
+)
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
+
+// Compare returns -1, 0 or +1 depending on whether version a is older
+// than, equal to or newer than b.
+func Compare(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for {
+		// skip separators
+		for len(a) > 0 && !isAlnum(a[0]) && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for len(b) > 0 && !isAlnum(b[0]) && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+
+		// tilde sorts before everything else
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return +1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// caret sorts after the end of the version but before anything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if len(a) == 0 {
+				return -1
+			}
+			if len(b) == 0 {
+				return +1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return +1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		var segA, segB string
+		numeric := isDigit(a[0])
+		if numeric {
+			segA, a = span(a, isDigit)
+			segB, b = span(b, isDigit)
+		} else {
+			segA, a = span(a, isAlpha)
+			segB, b = span(b, isAlpha)
+		}
+
+		// segments of different types; numeric is newer
+		if len(segB) == 0 {
+			if numeric {
+				return +1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return +1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	default:
+		return +1
+	}
+}
+
+func span(s string, f func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// EVR is an rpm epoch, version and release.
+type EVR struct {
+	Epoch   string
+	Version string
+	Release string
+}
+
+// ParseEVR parses "[epoch:]version[-release]".
+func ParseEVR(s string) EVR {
+	var evr EVR
+	if i := strings.Index(s, ":"); i > -1 {
+		evr.Epoch, s = s[:i], s[i+1:]
+	}
+	if i := strings.LastIndex(s, "-"); i > -1 {
+		evr.Version, evr.Release = s[:i], s[i+1:]
+	} else {
+		evr.Version = s
+	}
+	return evr
+}
+
+func (e EVR) String() string {
+	s := e.Version
+	if e.Epoch != "" && e.Epoch != "0" {
+		s = e.Epoch + ":" + s
+	}
+	if e.Release != "" {
+		s += "-" + e.Release
+	}
+	return s
+}
+
+// CompareEVR compares two EVRs. A missing epoch is treated as 0, and if
+// either release is empty releases are not compared, so that a
+// constraint on "6.6.0" matches every release of that version.
+func CompareEVR(a, b EVR) int {
+	epoch := func(e string) string {
+		if e == "" {
+			return "0"
+		}
+		return e
+	}
+	if c := Compare(epoch(a.Epoch), epoch(b.Epoch)); c != 0 {
+		return c
+	}
+	if c := Compare(a.Version, b.Version); c != 0 {
+		return c
+	}
+	if a.Release == "" || b.Release == "" {
+		return 0
+	}
+	return Compare(a.Release, b.Release)
+}
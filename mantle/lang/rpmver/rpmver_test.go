@@ -0,0 +1,67 @@
+// Copyright 2024 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpmver
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	// each version is older than the next
+	for _, l := range [][]string{
+		{"1.0~rc1", "1.0", "1.0^git1", "1.0a", "1.0.1", "1.1", "1.10"},
+		{"6.5.12", "6.6.0", "6.6.0.1", "6.10.0"},
+		{"a", "b", "1", "2"},
+		{"28.fc40", "29.fc39", "100.fc40"},
+		{"39.20240101.3.0", "39.20240101.3.1", "39.20240115.1.0", "40.20240101.1.0"},
+		{"24.03.20240501", "24.03.20240502", "24.09.20240101"},
+	} {
+		for i := 0; i < len(l)-1; i++ {
+			if c := Compare(l[i], l[i+1]); c != -1 {
+				t.Errorf("Compare(%q, %q) = %+d, expected -1", l[i], l[i+1], c)
+			}
+			if c := Compare(l[i+1], l[i]); c != +1 {
+				t.Errorf("Compare(%q, %q) = %+d, expected +1", l[i+1], l[i], c)
+			}
+		}
+	}
+
+	for _, p := range [][2]string{
+		{"1.0", "1.0"},
+		{"1.01", "1.1"},
+		{"1.0", "1_0"},
+	} {
+		if c := Compare(p[0], p[1]); c != 0 {
+			t.Errorf("Compare(%q, %q) = %+d, expected 0", p[0], p[1], c)
+		}
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"6.6.0-27.fc40", "6.6.0-28", -1},
+		{"6.6.0-28.fc40", "6.6.0-28", +1},
+		{"6.6.0-28.fc40", "6.6.0", 0},
+		{"1:1.0-1", "2.0-1", +1},
+		{"0:2.0-1", "2.0-1", 0},
+	} {
+		if got := CompareEVR(ParseEVR(tc.a), ParseEVR(tc.b)); got != tc.want {
+			t.Errorf("CompareEVR(%q, %q) = %+d, expected %+d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
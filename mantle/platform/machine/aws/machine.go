@@ -16,6 +16,7 @@ package aws
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -64,6 +65,14 @@ func (am *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return am.cluster.SSH(am, cmd)
 }
 
+func (am *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(am, localAddr, remoteAddr)
+}
+
+func (am *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(am, network, addr)
+}
+
 func (am *machine) IgnitionError() error {
 	return nil
 }
@@ -16,6 +16,7 @@ package do
 
 import (
 	"context"
+	"net"
 	"strconv"
 	"time"
 
@@ -61,6 +62,14 @@ func (dm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return dm.cluster.SSH(dm, cmd)
 }
 
+func (dm *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(dm, localAddr, remoteAddr)
+}
+
+func (dm *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(dm, network, addr)
+}
+
 func (dm *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package esx
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -62,6 +63,14 @@ func (em *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return em.cluster.SSH(em, cmd)
 }
 
+func (em *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(em, localAddr, remoteAddr)
+}
+
+func (em *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(em, network, addr)
+}
+
 func (em *machine) IgnitionError() error {
 	return nil
 }
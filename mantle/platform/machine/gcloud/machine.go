@@ -15,6 +15,7 @@
 package gcloud
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -62,6 +63,14 @@ func (gm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return gm.gc.SSH(gm, cmd)
 }
 
+func (gm *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(gm, localAddr, remoteAddr)
+}
+
+func (gm *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(gm, network, addr)
+}
+
 func (gm *machine) IgnitionError() error {
 	return nil
 }
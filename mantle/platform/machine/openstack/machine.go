@@ -16,6 +16,7 @@ package openstack
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -83,6 +84,14 @@ func (om *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return om.cluster.SSH(om, cmd)
 }
 
+func (om *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(om, localAddr, remoteAddr)
+}
+
+func (om *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(om, network, addr)
+}
+
 func (om *machine) IgnitionError() error {
 	return nil
 }
@@ -15,6 +15,7 @@
 package packet
 
 import (
+	"net"
 	"strings"
 	"time"
 
@@ -61,6 +62,14 @@ func (pm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return pm.cluster.SSH(pm, cmd)
 }
 
+func (pm *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(pm, localAddr, remoteAddr)
+}
+
+func (pm *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(pm, network, addr)
+}
+
 func (pm *machine) IgnitionError() error {
 	return nil
 }
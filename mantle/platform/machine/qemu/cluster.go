@@ -41,6 +41,8 @@ type Cluster struct {
 	flight *flight
 
 	mu sync.Mutex
+	// pooledMachines is the number of machines taken from the pool
+	pooledMachines int
 }
 
 func (qc *Cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
@@ -54,13 +56,6 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options platfo
 }
 
 func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options platform.QemuMachineOptions) (platform.Machine, error) {
-	id := uuid.New()
-
-	dir := filepath.Join(qc.RuntimeConf().OutputDir, id)
-	if err := os.Mkdir(dir, 0777); err != nil {
-		return nil, err
-	}
-
 	// hacky solution for cloud config ip substitution
 	// NOTE: escaping is not supported
 	qc.mu.Lock()
@@ -72,6 +67,17 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	}
 	qc.mu.Unlock()
 
+	if qm := qc.flight.pool.take(qc, conf, options); qm != nil {
+		return qm, nil
+	}
+
+	id := uuid.New()
+
+	dir := filepath.Join(qc.RuntimeConf().OutputDir, id)
+	if err := os.Mkdir(dir, 0777); err != nil {
+		return nil, err
+	}
+
 	journal, err := platform.NewJournal(dir)
 	if err != nil {
 		return nil, err
@@ -84,17 +90,6 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		consolePath: filepath.Join(dir, "console.txt"),
 	}
 
-	builder := platform.NewQemuBuilder()
-	if options.DisablePDeathSig {
-		builder.Pdeathsig = false
-	}
-
-	if qc.flight.opts.SecureExecution {
-		if err := builder.SetSecureExecution(qc.flight.opts.SecureExecutionIgnitionPubKey, qc.flight.opts.SecureExecutionHostKey, conf); err != nil {
-			return nil, err
-		}
-	}
-
 	var confPath string
 	if conf.IsIgnition() {
 		confPath = filepath.Join(dir, "ignition.json")
@@ -106,6 +101,90 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		return nil, fmt.Errorf("qemu only supports Ignition or empty configs")
 	}
 
+	// Start from the state saved by the first machine with the same
+	// config, if any; that machine saves it once it's up.
+	var incoming *firstBootState
+	firstBootKey := qc.firstBootKey(conf, options)
+	if firstBootKey != "" {
+		st, save, err := qc.flight.firstBootState(firstBootKey)
+		if err != nil {
+			return nil, err
+		}
+		if save {
+			qm.firstBootKey = firstBootKey
+			qm.firstBoot = st
+		} else {
+			incoming = st
+		}
+	}
+
+	inst, err := qc.execInstance(qm, conf, confPath, options, incoming)
+	if err != nil && incoming != nil {
+		plog.Warningf("Starting machine %s from first boot state failed, booting it: %v", qm.id, err)
+		incoming = nil
+		inst, err = qc.execInstance(qm, conf, confPath, options, nil)
+	}
+	if err != nil {
+		qm.firstBootSaved(false, nil)
+		return nil, err
+	}
+	qm.inst = inst
+	qm.restored = incoming != nil
+
+	err = util.Retry(6, 5*time.Second, func() error {
+		var err error
+		qm.ip, err = inst.SSHAddress()
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		qm.firstBootSaved(false, nil)
+		return nil, err
+	}
+
+	// Run StartMachine, which blocks on the machine being booted up enough
+	// for SSH access, but only if the caller didn't tell us not to.
+	if !options.SkipStartMachine {
+		if err := qm.Start(); err != nil {
+			qm.Destroy()
+			return nil, err
+		}
+	}
+
+	qc.AddMach(qm)
+
+	return qm, nil
+}
+
+// PooledMachines returns the number of machines of the cluster which came
+// from the flight's machine pool.
+func (qc *Cluster) PooledMachines() int {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	return qc.pooledMachines
+}
+
+func (qc *Cluster) Destroy() {
+	qc.BaseCluster.Destroy()
+	qc.flight.DelCluster(qc)
+}
+
+// execInstance starts the QEMU instance of machine qm, from the saved state
+// incoming if it's set.
+func (qc *Cluster) execInstance(qm *machine, config *conf.Conf, confPath string, options platform.QemuMachineOptions, incoming *firstBootState) (*platform.QemuInstance, error) {
+	builder := platform.NewQemuBuilder()
+	if options.DisablePDeathSig {
+		builder.Pdeathsig = false
+	}
+
+	if qc.flight.opts.SecureExecution {
+		if err := builder.SetSecureExecution(qc.flight.opts.SecureExecutionIgnitionPubKey, qc.flight.opts.SecureExecutionHostKey, config); err != nil {
+			return nil, err
+		}
+	}
+
 	builder.ConfigFile = confPath
 	defer builder.Close()
 	builder.UUID = qm.id
@@ -114,7 +193,9 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 			return nil, err
 		}
 	}
-	if qc.flight.opts.Firmware != "" {
+	if options.Firmware != "" {
+		builder.Firmware = options.Firmware
+	} else if qc.flight.opts.Firmware != "" {
 		builder.Firmware = qc.flight.opts.Firmware
 	}
 	builder.Swtpm = qc.flight.opts.Swtpm
@@ -145,7 +226,7 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		channel = "nvme"
 	}
 	sectorSize := 0
-	if qc.flight.opts.Native4k {
+	if qc.flight.opts.Native4k || options.Native4k {
 		sectorSize = 4096
 	}
 	multiPathDisk := options.MultiPathDisk || qc.flight.opts.MultiPathDisk
@@ -167,10 +248,16 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		primaryDisk.BackingFile = options.OverrideBackingFile
 	}
 
-	if err = builder.AddBootDisk(&primaryDisk); err != nil {
+	if incoming != nil {
+		// the disk SaveState wrote is itself backed by the image
+		primaryDisk.BackingFile = incoming.disk
+		builder.IncomingState = incoming.state
+	}
+
+	if err := builder.AddBootDisk(&primaryDisk); err != nil {
 		return nil, err
 	}
-	if err = builder.AddDisksFromSpecs(options.AdditionalDisks); err != nil {
+	if err := builder.AddDisksFromSpecs(options.AdditionalDisks); err != nil {
 		return nil, err
 	}
 
@@ -195,39 +282,5 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		builder.RestrictNetworking = true
 	}
 
-	inst, err := builder.Exec()
-	if err != nil {
-		return nil, err
-	}
-	qm.inst = inst
-
-	err = util.Retry(6, 5*time.Second, func() error {
-		var err error
-		qm.ip, err = inst.SSHAddress()
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// Run StartMachine, which blocks on the machine being booted up enough
-	// for SSH access, but only if the caller didn't tell us not to.
-	if !options.SkipStartMachine {
-		if err := platform.StartMachine(qm, qm.journal); err != nil {
-			qm.Destroy()
-			return nil, err
-		}
-	}
-
-	qc.AddMach(qm)
-
-	return qm, nil
-}
-
-func (qc *Cluster) Destroy() {
-	qc.BaseCluster.Destroy()
-	qc.flight.DelCluster(qc)
+	return builder.Exec()
 }
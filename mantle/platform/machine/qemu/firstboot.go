@@ -0,0 +1,103 @@
+package qemu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+)
+
+// firstBootState is the state of a machine saved after its first boot,
+// from which machines with the same config and options start.
+type firstBootState struct {
+	disk  string // the clusters written to the primary disk
+	state string // RAM and devices
+	// saved is set once the first machine saved its state; failed if
+	// that didn't work, and then the state is never used
+	saved  bool
+	failed bool
+}
+
+// firstBootKey identifies the machines which can share a first boot state:
+// their rendered config and everything the saved state depends on must
+// match. It returns "" if the machine can't use one. Only the first
+// machine of a cluster can: machines started from the same state have the
+// same hostname, machine ID and SSH host keys, which must differ within a
+// cluster.
+func (qc *Cluster) firstBootKey(config *conf.Conf, options platform.QemuMachineOptions) string {
+	opts := qc.flight.opts
+	if !qc.RuntimeConf().FirstBootSnapshot || len(qc.Machines()) > 0 ||
+		// these add disks or processes which SaveState doesn't copy, or
+		// modify the disk at start
+		len(options.AdditionalDisks) > 0 || options.MultiPathDisk || opts.MultiPathDisk ||
+		opts.NbdDisk || len(opts.BindRO) > 0 || opts.SecureExecution ||
+		// QEMU can't migrate NVMe controllers
+		opts.Nvme ||
+		options.AppendKernelArgs != "" || options.AppendFirstbootKernelArgs != "" ||
+		options.OverrideBackingFile != "" {
+		return ""
+	}
+	key, err := json.Marshal(struct {
+		Config           string
+		Options          platform.MachineOptions
+		HostForwardPorts []platform.HostForwardPort
+		InternetAccess   bool
+	}{
+		Config:           config.String(),
+		Options:          options.MachineOptions,
+		HostForwardPorts: options.HostForwardPorts,
+		InternetAccess:   qc.RuntimeConf().InternetAccess,
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// firstBootState returns the saved state for key, or, if no machine saved
+// one yet, a new one which the caller should save. It returns nil if
+// another machine is saving the state or saving it failed.
+func (qf *flight) firstBootState(key string) (st *firstBootState, save bool, err error) {
+	qf.firstBootMu.Lock()
+	defer qf.firstBootMu.Unlock()
+	if st, ok := qf.firstBootStates[key]; ok {
+		if st.saved && !st.failed {
+			return st, false, nil
+		}
+		return nil, false, nil
+	}
+	if qf.firstBootDir == "" {
+		if qf.firstBootDir, err = os.MkdirTemp("/var/tmp", "mantle-qemu-firstboot"); err != nil {
+			return nil, false, err
+		}
+	}
+	st = &firstBootState{
+		disk:  filepath.Join(qf.firstBootDir, key+".qcow2"),
+		state: filepath.Join(qf.firstBootDir, key+".state"),
+	}
+	qf.firstBootStates[key] = st
+	return st, true, nil
+}
+
+// firstBootSaved records the outcome of saving st. If the machine didn't
+// start, nothing was saved and another machine can try.
+func (qf *flight) firstBootSaved(key string, st *firstBootState, started bool, err error) {
+	qf.firstBootMu.Lock()
+	defer qf.firstBootMu.Unlock()
+	if !started {
+		delete(qf.firstBootStates, key)
+		return
+	}
+	st.saved = true
+	if err != nil {
+		plog.Warningf("Saving first boot state; machines will boot normally: %v", err)
+		st.failed = true
+		os.Remove(st.disk)
+		os.Remove(st.state)
+	}
+}
@@ -15,6 +15,9 @@
 package qemu
 
 import (
+	"os"
+	"sync"
+
 	"github.com/coreos/pkg/capnslog"
 
 	"github.com/coreos/coreos-assembler/mantle/platform"
@@ -49,6 +52,13 @@ type Options struct {
 	// Array of $hostpath
 	BindRO []string
 
+	// PoolSize is the number of machines with the default config kept
+	// booted to hand to tests
+	PoolSize int
+	// PoolDir holds the files of pool machines until they're handed to a
+	// test; a temporary directory if empty
+	PoolDir string
+
 	//IBM Secure Execution
 	SecureExecution               bool
 	SecureExecutionIgnitionPubKey string
@@ -60,6 +70,12 @@ type Options struct {
 type flight struct {
 	*platform.BaseFlight
 	opts *Options
+
+	firstBootMu     sync.Mutex
+	firstBootStates map[string]*firstBootState
+	firstBootDir    string
+
+	pool *pool
 }
 
 var (
@@ -73,8 +89,17 @@ func NewFlight(opts *Options) (platform.Flight, error) {
 	}
 
 	qf := &flight{
-		BaseFlight: bf,
-		opts:       opts,
+		BaseFlight:      bf,
+		opts:            opts,
+		firstBootStates: make(map[string]*firstBootState),
+	}
+
+	if opts.PoolSize > 0 {
+		if qf.pool, err = newPool(qf); err != nil {
+			qf.Destroy()
+			return nil, err
+		}
+		qf.pool.start()
 	}
 
 	return qf, nil
@@ -103,3 +128,21 @@ func (qf *flight) NewCluster(rconf *platform.RuntimeConfig) (platform.Cluster, e
 
 	return qc, nil
 }
+
+// Destroy destroys the machine pool and each Cluster in the Flight, and
+// removes the saved first boot states.
+func (qf *flight) Destroy() {
+	if qf.pool != nil {
+		qf.pool.close()
+	}
+	qf.BaseFlight.Destroy()
+
+	qf.firstBootMu.Lock()
+	defer qf.firstBootMu.Unlock()
+	if qf.firstBootDir != "" {
+		if err := os.RemoveAll(qf.firstBootDir); err != nil {
+			plog.Errorf("Error removing first boot states: %v", err)
+		}
+		qf.firstBootDir = ""
+	}
+}
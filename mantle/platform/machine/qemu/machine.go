@@ -17,6 +17,8 @@ package qemu
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -33,6 +35,13 @@ type machine struct {
 	consolePath string
 	console     string
 	ip          string
+
+	// firstBoot is the state to save once the machine is up, keyed by
+	// firstBootKey in the flight
+	firstBoot    *firstBootState
+	firstBootKey string
+	// restored is true if the machine started from a saved state
+	restored bool
 }
 
 func (m *machine) ID() string {
@@ -63,6 +72,14 @@ func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return m.qc.SSH(m, cmd)
 }
 
+func (m *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(m, localAddr, remoteAddr)
+}
+
+func (m *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(m, network, addr)
+}
+
 func (m *machine) IgnitionError() error {
 	ctx := context.Background()
 	buf, err := m.inst.WaitIgnitionError(ctx)
@@ -76,7 +93,21 @@ func (m *machine) IgnitionError() error {
 }
 
 func (m *machine) Start() error {
-	return platform.StartMachine(m, m.journal)
+	if err := platform.StartMachine(m, m.journal); err != nil {
+		m.firstBootSaved(false, nil)
+		return err
+	}
+	if m.restored {
+		// the guest clock stopped when the state was saved
+		m.restored = false
+		if err := m.setClock(); err != nil {
+			return err
+		}
+	}
+	if m.firstBoot != nil {
+		m.firstBootSaved(true, m.inst.SaveState(m.firstBoot.disk, m.firstBoot.state))
+	}
+	return nil
 }
 
 func (m *machine) Reboot() error {
@@ -88,6 +119,7 @@ func (m *machine) WaitForReboot(timeout time.Duration, oldBootId string) error {
 }
 
 func (m *machine) Destroy() {
+	m.firstBootSaved(false, nil)
 	m.inst.Destroy()
 
 	m.journal.Destroy()
@@ -120,3 +152,49 @@ func (m *machine) JournalOutput() string {
 func (m *machine) RemovePrimaryBlockDevice() error {
 	return m.inst.RemovePrimaryBlockDevice()
 }
+
+func (m *machine) ResourceUsage() (*platform.QemuResourceUsage, error) {
+	return m.inst.ResourceUsage()
+}
+
+func (m *machine) Pid() int {
+	return m.inst.Pid()
+}
+
+func (m *machine) ConsoleFile() string {
+	return m.consolePath
+}
+
+func (m *machine) Snapshot(name string) error {
+	return m.inst.Snapshot(name)
+}
+
+func (m *machine) Restore(name string) error {
+	if err := m.inst.Restore(name); err != nil {
+		return err
+	}
+	// the journal connection died with the state it was created in
+	if err := m.journal.Start(context.TODO(), m, ""); err != nil {
+		return err
+	}
+	return m.setClock()
+}
+
+// setClock sets the guest clock to the host's, after it was restored.
+func (m *machine) setClock() error {
+	out, stderr, err := m.SSH(fmt.Sprintf("sudo date -u -s @%d", time.Now().Unix()))
+	if err != nil {
+		return fmt.Errorf("setting clock of machine %s: %s: %v: %s", m.ID(), out, err, stderr)
+	}
+	return nil
+}
+
+// firstBootSaved reports the outcome of saving the first boot state to the
+// flight, if the machine was to save it.
+func (m *machine) firstBootSaved(started bool, err error) {
+	if m.firstBoot == nil {
+		return
+	}
+	m.qc.flight.firstBootSaved(m.firstBootKey, m.firstBoot, started, err)
+	m.firstBoot = nil
+}
@@ -0,0 +1,232 @@
+package qemu
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+)
+
+// maxPoolFailures is how many machines of the pool may fail to boot in a
+// row before it stops booting more.
+const maxPoolFailures = 3
+
+// PoolStats is how the machines of a flight's pool were used.
+type PoolStats struct {
+	// Size is the number of machines the pool keeps booted
+	Size int `json:"size"`
+	// Hits is the number of machines taken from the pool, Misses the
+	// number of compatible machines booted because the pool was empty and
+	// Incompatible the number of other machines
+	Hits         int `json:"hits"`
+	Misses       int `json:"misses"`
+	Incompatible int `json:"incompatible"`
+	// Unused is the number of pool machines destroyed at the end unused
+	Unused int `json:"unused"`
+}
+
+// HitRate is the fraction of the machines created which came from the pool.
+func (s PoolStats) HitRate() float64 {
+	total := s.Hits + s.Misses + s.Incompatible
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// pool keeps machines with the default config booted, to hand them to
+// clusters asking for one. Machines leave the pool for good; clusters
+// destroy them as usual.
+type pool struct {
+	cluster *Cluster // owns the machines waiting in the pool
+	config  string   // the rendered config of the machines
+	dir     string
+	tempDir bool // dir was created by the pool
+
+	mu       sync.Mutex
+	ready    []*machine
+	booting  int
+	failures int
+	closed   bool
+	stats    PoolStats
+}
+
+// newPool creates the pool of the flight; start starts filling it.
+func newPool(qf *flight) (*pool, error) {
+	dir := qf.opts.PoolDir
+	tempDir := false
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("/var/tmp", "mantle-qemu-pool"); err != nil {
+			return nil, err
+		}
+		tempDir = true
+	} else if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	c, err := qf.NewCluster(&platform.RuntimeConfig{OutputDir: dir})
+	if err != nil {
+		return nil, err
+	}
+	qc := c.(*Cluster)
+	config, err := qc.RenderUserData(conf.EmptyIgnition(), map[string]string{})
+	if err != nil {
+		qc.Destroy()
+		return nil, err
+	}
+	return &pool{
+		cluster: qc,
+		config:  config.String(),
+		dir:     dir,
+		tempDir: tempDir,
+		stats:   PoolStats{Size: qf.opts.PoolSize},
+	}, nil
+}
+
+func (p *pool) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fill()
+}
+
+// fill boots machines until the pool has its size. p.mu must be held.
+func (p *pool) fill() {
+	for !p.closed && p.failures < maxPoolFailures && len(p.ready)+p.booting < p.stats.Size {
+		p.booting++
+		go p.boot()
+	}
+}
+
+func (p *pool) boot() {
+	m, err := p.cluster.NewMachine(nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.booting--
+	if err != nil {
+		if p.closed {
+			p.removeDir()
+			return
+		}
+		p.failures++
+		plog.Warningf("Booting machine for the pool: %v", err)
+		if p.failures == maxPoolFailures {
+			plog.Warningf("%d machines for the pool failed to boot, not booting more", maxPoolFailures)
+		}
+		return
+	}
+	p.failures = 0
+	if p.closed {
+		p.stats.Unused++
+		p.destroy(m.(*machine))
+		p.removeDir()
+		return
+	}
+	p.ready = append(p.ready, m.(*machine))
+}
+
+// compatible returns true if a machine of the pool can serve as the machine
+// qc wants to create with config and options.
+func (p *pool) compatible(qc *Cluster, config *conf.Conf, options platform.QemuMachineOptions) bool {
+	// starting the machine or not is up to the caller
+	defaults := platform.MachineOptions{SkipStartMachine: options.SkipStartMachine}
+	return config.String() == p.config &&
+		reflect.DeepEqual(options.MachineOptions, defaults) &&
+		len(options.HostForwardPorts) == 0 && !options.DisablePDeathSig &&
+		options.OverrideBackingFile == "" &&
+		!qc.RuntimeConf().InternetAccess && !qc.RuntimeConf().NoMachinePool
+}
+
+// take hands a machine of the pool over to qc if it's compatible with
+// config and options, and starts booting a replacement. It returns nil if
+// qc must boot its machine itself.
+func (p *pool) take(qc *Cluster, config *conf.Conf, options platform.QemuMachineOptions) *machine {
+	if p == nil || qc == p.cluster {
+		return nil
+	}
+	compatible := p.compatible(qc, config, options)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !compatible {
+		p.stats.Incompatible++
+		return nil
+	}
+	if len(p.ready) == 0 {
+		p.stats.Misses++
+		p.fill()
+		return nil
+	}
+	qm := p.ready[0]
+	p.ready = p.ready[1:]
+	p.stats.Hits++
+	p.fill()
+
+	p.cluster.DelMach(qm)
+	oldDir := filepath.Dir(qm.consolePath)
+	newDir := filepath.Join(qc.RuntimeConf().OutputDir, qm.id)
+	if err := os.Rename(oldDir, newDir); err == nil {
+		qm.consolePath = filepath.Join(newDir, filepath.Base(qm.consolePath))
+	} else {
+		plog.Warningf("Moving files of machine %s from the pool: %v", qm.id, err)
+	}
+	qm.qc = qc
+	qc.mu.Lock()
+	qc.pooledMachines++
+	qc.mu.Unlock()
+	qc.AddMach(qm)
+	return qm
+}
+
+// destroy destroys a machine of the pool and removes its files. p.mu must
+// be held.
+func (p *pool) destroy(qm *machine) {
+	qm.Destroy()
+	if err := os.RemoveAll(filepath.Dir(qm.consolePath)); err != nil {
+		plog.Errorf("Error removing files of pool machine %s: %v", qm.id, err)
+	}
+}
+
+// close destroys the machines left in the pool; those still booting are
+// destroyed once up.
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.stats.Unused += len(p.ready)
+	for _, qm := range p.ready {
+		p.destroy(qm)
+	}
+	p.ready = nil
+	p.removeDir()
+}
+
+// removeDir removes the directory of the pool once the pool is done with
+// it, if the pool created it or it's empty. p.mu must be held.
+func (p *pool) removeDir() {
+	if !p.closed || p.booting > 0 {
+		return
+	}
+	if p.tempDir {
+		if err := os.RemoveAll(p.dir); err != nil {
+			plog.Errorf("Error removing pool directory: %v", err)
+		}
+	} else {
+		// files of machines which couldn't be moved stay
+		_ = os.Remove(p.dir)
+	}
+}
+
+// PoolStats returns how the machine pool of the flight was used; Size is 0
+// if it has none.
+func (qf *flight) PoolStats() PoolStats {
+	if qf.pool == nil {
+		return PoolStats{}
+	}
+	qf.pool.mu.Lock()
+	defer qf.pool.mu.Unlock()
+	return qf.pool.stats
+}
@@ -17,6 +17,7 @@ package qemuiso
 import (
 	"context"
 	"errors"
+	"net"
 	"os"
 	"time"
 
@@ -63,6 +64,14 @@ func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return m.qc.SSH(m, cmd)
 }
 
+func (m *machine) Forward(localAddr, remoteAddr string) (*platform.Tunnel, error) {
+	return platform.Forward(m, localAddr, remoteAddr)
+}
+
+func (m *machine) DialGuest(network, addr string) (net.Conn, error) {
+	return platform.DialGuest(m, network, addr)
+}
+
 func (m *machine) IgnitionError() error {
 	ctx := context.Background()
 	buf, err := m.inst.WaitIgnitionError(ctx)
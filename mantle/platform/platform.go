@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -69,6 +70,14 @@ type Machine interface {
 	// SSH runs a single command over a new SSH connection.
 	SSH(cmd string) ([]byte, []byte, error)
 
+	// Forward forwards connections to localAddr to remoteAddr on the
+	// machine over SSH, see platform.Forward.
+	Forward(localAddr, remoteAddr string) (*Tunnel, error)
+
+	// DialGuest connects to addr on the machine over SSH, see
+	// platform.DialGuest.
+	DialGuest(network, addr string) (net.Conn, error)
+
 	// Start sets up the journal and performs sanity checks via platform.StartMachine().
 	Start() error
 
@@ -161,7 +170,9 @@ type MachineOptions struct {
 	AdditionalNics            int
 	AppendKernelArgs          string
 	AppendFirstbootKernelArgs string
-	SkipStartMachine          bool // Skip platform.StartMachine on machine bringup
+	SkipStartMachine          bool   // Skip platform.StartMachine on machine bringup
+	Firmware                  string // Firmware to boot with, overriding the flight's (qemu only)
+	Native4k                  bool   // Use 4k sectors for the primary disk (qemu only)
 }
 
 // SystemdDropin is a userdata type agnostic struct representing a systemd dropin
@@ -214,6 +225,15 @@ type RuntimeConfig struct {
 
 	// whether a Manhole into a machine should be created on detected failure
 	SSHOnTestFailure bool
+
+	// FirstBootSnapshot starts machines from the state of a machine with
+	// the same userdata and options after its first boot, shared across
+	// clusters, instead of booting them (qemu only)
+	FirstBootSnapshot bool
+
+	// NoMachinePool boots the machines of the cluster instead of taking
+	// them from the flight's machine pool (qemu only)
+	NoMachinePool bool
 }
 
 // Wrap a StdoutPipe as a io.ReadCloser
@@ -412,33 +432,60 @@ func NewMachines(c Cluster, userdata *conf.UserData, n int, options MachineOptio
 	return machs, nil
 }
 
-// checkSystemdUnitFailures ensures that no system unit is in a failed state.
-func checkSystemdUnitFailures(output string) error {
-	if len(output) > 0 {
-		return fmt.Errorf("some systemd units failed: %s", output)
+// SystemdUnits returns the systemd units of m in state, e.g. failed or
+// activating.
+func SystemdUnits(m Machine, state string) ([]string, error) {
+	// check systemd version on host to see if we can use `busctl --json=short`
+	minSystemdVer := 240
+	out, stderr, err := m.SSH("rpm -q --queryformat='%{VERSION}\n' systemd")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query systemd RPM for version: %s: %v: %s", out, err, stderr)
 	}
-	return nil
+	// Fedora can use XXX.Y as a version string, so just use the major version
+	var systemdVer int
+	if len(out) >= 3 {
+		systemdVer, _ = strconv.Atoi(string(out[0:3]))
+	}
+
+	var systemdCmd string
+	if systemdVer >= minSystemdVer {
+		systemdCmd = "busctl --json=short call org.freedesktop.systemd1 /org/freedesktop/systemd1 org.freedesktop.systemd1.Manager ListUnitsFiltered as 2 state status | jq -r '.data[][][0]'"
+	} else {
+		systemdCmd = "systemctl --no-legend --state status list-units | awk '{print $1}'"
+	}
+	out, stderr, err = m.SSH(strings.Replace(systemdCmd, "status", state, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query systemd for %s units: %s: %v: %s", state, out, err, stderr)
+	}
+	var units []string
+	for _, unit := range strings.Split(string(out), "\n") {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			units = append(units, unit)
+		}
+	}
+	return units, nil
 }
 
-// checkSystemdUnitStuck ensures that no system unit stuck in activating state.
+// StuckSystemdUnits returns the units of m stuck in activating state, i.e.
+// restarted at least twice.
 // https://github.com/coreos/coreos-assembler/issues/2798
 // See https://bugzilla.redhat.com/show_bug.cgi?id=2072050
-func checkSystemdUnitStuck(output string, m Machine) error {
-	if len(output) == 0 {
-		return nil
+func StuckSystemdUnits(m Machine) ([]string, error) {
+	activating, err := SystemdUnits(m, "activating")
+	if err != nil {
+		return nil, err
 	}
-	var NRestarts int
-	for _, unit := range strings.Split(output, "\n") {
+	var stuck []string
+	for _, unit := range activating {
 		out, stderr, err := m.SSH(fmt.Sprintf("systemctl show -p NRestarts --value %s", unit))
 		if err != nil {
-			return fmt.Errorf("failed to query systemd unit NRestarts: %s: %v: %s", out, err, stderr)
+			return nil, fmt.Errorf("failed to query systemd unit NRestarts: %s: %v: %s", out, err, stderr)
 		}
-		NRestarts, _ = strconv.Atoi(string(out))
-		if NRestarts >= 2 {
-			return fmt.Errorf("systemd units %s has %v restarts", unit, NRestarts)
+		if nRestarts, _ := strconv.Atoi(string(out)); nRestarts >= 2 {
+			stuck = append(stuck, fmt.Sprintf("%s (%d restarts)", unit, nRestarts))
 		}
 	}
-	return nil
+	return stuck, nil
 }
 
 // CheckMachine tests a machine for various error conditions such as ssh
@@ -470,45 +517,24 @@ func CheckMachine(ctx context.Context, m Machine) error {
 
 	// NestOS don't want to check '/etc/os-release' to check if it is supported
 
-	// check systemd version on host to see if we can use `busctl --json=short`
-	var systemdVer int
-	var systemdCmd, failedUnitsCmd, activatingUnitsCmd string
 	var systemdFailures bool
-	minSystemdVer := 240
-	out, stderr, err := m.SSH("rpm -q --queryformat='%{VERSION}\n' systemd")
-	if err != nil {
-		return fmt.Errorf("failed to query systemd RPM for version: %s: %v: %s", out, err, stderr)
-	}
-	// Fedora can use XXX.Y as a version string, so just use the major version
-	systemdVer, _ = strconv.Atoi(string(out[0:3]))
-
-	if systemdVer >= minSystemdVer {
-		systemdCmd = "busctl --json=short call org.freedesktop.systemd1 /org/freedesktop/systemd1 org.freedesktop.systemd1.Manager ListUnitsFiltered as 2 state status | jq -r '.data[][][0]'"
-	} else {
-		systemdCmd = "systemctl --no-legend --state status list-units | awk '{print $1}'"
-	}
-	failedUnitsCmd = strings.Replace(systemdCmd, "status", "failed", -1)
-	activatingUnitsCmd = strings.Replace(systemdCmd, "status", "activating", -1)
-
 	// Ensure no systemd units failed during boot
-	out, stderr, err = m.SSH(failedUnitsCmd)
+	failed, err := SystemdUnits(m, "failed")
 	if err != nil {
-		return fmt.Errorf("failed to query systemd for failed units: %s: %v: %s", out, err, stderr)
+		return err
 	}
-	err = checkSystemdUnitFailures(string(out))
-	if err != nil {
-		plog.Error(err)
+	if len(failed) > 0 {
+		plog.Errorf("some systemd units failed: %s", strings.Join(failed, " "))
 		systemdFailures = true
 	}
 
 	// Ensure no systemd units stuck in activating state
-	out, stderr, err = m.SSH(activatingUnitsCmd)
+	stuck, err := StuckSystemdUnits(m)
 	if err != nil {
-		return fmt.Errorf("failed to query systemd for activating units: %s: %v: %s", out, err, stderr)
+		return err
 	}
-	err = checkSystemdUnitStuck(string(out), m)
-	if err != nil {
-		plog.Error(err)
+	if len(stuck) > 0 {
+		plog.Errorf("systemd units stuck activating: %s", strings.Join(stuck, " "))
 		systemdFailures = true
 	}
 
@@ -46,6 +46,7 @@ import (
 	"github.com/coreos/coreos-assembler/mantle/util"
 	coreosarch "github.com/coreos/stream-metadata-go/arch"
 	"github.com/digitalocean/go-qemu/qmp"
+	"github.com/kballard/go-shellquote"
 
 	"github.com/coreos/coreos-assembler/mantle/system"
 	"github.com/coreos/coreos-assembler/mantle/system/exec"
@@ -82,6 +83,24 @@ type QEMUMachine interface {
 	// RemovePrimaryBlockDevice removes the primary device from a given qemu
 	// instance and sets the secondary device as primary.
 	RemovePrimaryBlockDevice() error
+
+	// ResourceUsage returns the host resources used by the instance so far.
+	ResourceUsage() (*QemuResourceUsage, error)
+
+	// Snapshot saves the state of the machine as an internal snapshot
+	// called name.
+	Snapshot(name string) error
+
+	// Restore reverts the machine to the snapshot called name and
+	// reconnects to it.
+	Restore(name string) error
+
+	// Pid returns the PID of the QEMU process.
+	Pid() int
+
+	// ConsoleFile returns the path of the file the serial console is
+	// written to.
+	ConsoleFile() string
 }
 
 // Disk holds the details of a virtual disk.
@@ -98,6 +117,7 @@ type Disk struct {
 
 	attachEndPoint string   // qemuPath to attach to
 	dstFileName    string   // the prepared file
+	driveID        string   // id of the -drive, unset for nbd and multipath disks
 	nbdServCmd     exec.Cmd // command to serve the disk
 }
 
@@ -157,6 +177,9 @@ type QemuInstance struct {
 
 	qmpSocket     *qmp.SocketMonitor
 	qmpSocketPath string
+
+	// primaryDriveID is the -drive id of the primary disk, for SaveState
+	primaryDriveID string
 }
 
 // Signaled returns whether QEMU process was signaled.
@@ -462,6 +485,12 @@ type QemuBuilder struct {
 
 	InheritConsole bool
 
+	// IncomingState is a file written by QemuInstance.SaveState; if set,
+	// the instance resumes from it instead of booting. The primary disk
+	// must be backed by the disk SaveState wrote and everything else
+	// configured as for the saved instance.
+	IncomingState string
+
 	iso         *bootIso
 	isoAsDisk   bool
 	primaryDisk *Disk
@@ -1230,6 +1259,9 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 		// Default to cache=unsafe
 		builder.Append("-drive", fmt.Sprintf("if=none,id=%s,file=%s,%s",
 			id, disk.attachEndPoint, defaultDiskOpts))
+		if !disk.NbdDisk {
+			disk.driveID = id
+		}
 	}
 	return nil
 }
@@ -1290,28 +1322,33 @@ func (builder *QemuBuilder) finalize() {
 		return
 	}
 	if builder.MemoryMiB == 0 {
-		// FIXME; Required memory should really be a property of the tests, and
-		// let's try to drop these arch-specific overrides.  ARM was bumped via
-		// commit 09391907c0b25726374004669fa6c2b161e3892f
-		// Commit:     Geoff Levand <geoff@infradead.org>
-		// CommitDate: Mon Aug 21 12:39:34 2017 -0700
-		//
-		// kola: More memory for arm64 qemu guest machines
-		//
-		// arm64 guest machines seem to run out of memory with 1024 MiB of
-		// RAM, so increase to 2048 MiB.
-
-		// Then later, other non-x86_64 seemed to just copy that.
-		memory := 1024
-		switch builder.architecture {
-		case "aarch64", "s390x", "ppc64le":
-			memory = 2048
-		}
-		builder.MemoryMiB = memory
+		builder.MemoryMiB = DefaultMemoryMiB(builder.architecture)
 	}
 	builder.finalized = true
 }
 
+// DefaultMemoryMiB returns the memory given to a guest of the given
+// architecture when neither the test nor the user asked for a size.
+func DefaultMemoryMiB(arch string) int {
+	// FIXME; Required memory should really be a property of the tests, and
+	// let's try to drop these arch-specific overrides.  ARM was bumped via
+	// commit 09391907c0b25726374004669fa6c2b161e3892f
+	// Commit:     Geoff Levand <geoff@infradead.org>
+	// CommitDate: Mon Aug 21 12:39:34 2017 -0700
+	//
+	// kola: More memory for arm64 qemu guest machines
+	//
+	// arm64 guest machines seem to run out of memory with 1024 MiB of
+	// RAM, so increase to 2048 MiB.
+
+	// Then later, other non-x86_64 seemed to just copy that.
+	switch arch {
+	case "aarch64", "s390x", "ppc64le":
+		return 2048
+	}
+	return 1024
+}
+
 // Append appends additional arguments for QEMU.
 func (builder *QemuBuilder) Append(args ...string) {
 	builder.Argv = append(builder.Argv, args...)
@@ -1727,6 +1764,7 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		if builder.primaryIsBoot {
 			argv = append(argv, "-boot", "order=c,strict=on")
 		}
+		inst.primaryDriveID = builder.primaryDisk.driveID
 	}
 	// Handle Ignition if it wasn't already injected above
 	if builder.ConfigFile != "" && !builder.configInjected {
@@ -1905,6 +1943,10 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		builder.Append("-serial", "mon:stdio")
 	}
 
+	if builder.IncomingState != "" {
+		argv = append(argv, "-incoming", "exec:cat "+shellquote.Join(builder.IncomingState))
+	}
+
 	// And the custom arguments
 	argv = append(argv, builder.Argv...)
 
@@ -1957,6 +1999,13 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		return nil, fmt.Errorf("failed to connect over qmp to qemu instance")
 	}
 
+	if builder.IncomingState != "" {
+		if err := inst.waitIncoming(); err != nil {
+			inst.Destroy()
+			return nil, err
+		}
+	}
+
 	// Hacky code to test https://github.com/openshift/os/pull/1346
 	if timeout, ok := os.LookupEnv("COSA_TEST_CDROM_UNPLUG"); ok {
 		val, err := time.ParseDuration(timeout)
@@ -0,0 +1,146 @@
+package platform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc; it's 100 on all
+// architectures Linux supports.
+const clockTicks = 100
+
+// QemuResourceUsage is the host resources used by a QEMU process so far.
+type QemuResourceUsage struct {
+	// CPUTime is the user and system CPU time of the QEMU process
+	CPUTime time.Duration `json:"cpuTime"`
+	// PeakRSSKiB is the peak resident set size of the QEMU process
+	PeakRSSKiB uint64 `json:"peakRssKiB"`
+	// DiskReadBytes and DiskWrittenBytes are the bytes the guest read
+	// and wrote on all its disks, from QMP query-blockstats
+	DiskReadBytes    uint64 `json:"diskReadBytes"`
+	DiskWrittenBytes uint64 `json:"diskWrittenBytes"`
+	// HostReadBytes and HostWrittenBytes are the bytes the QEMU process
+	// read and wrote on host storage, from /proc/<pid>/io
+	HostReadBytes    uint64 `json:"hostReadBytes"`
+	HostWrittenBytes uint64 `json:"hostWrittenBytes"`
+}
+
+// ResourceUsage returns the host resources used by the QEMU process. It
+// must be called before the instance is destroyed.
+func (inst *QemuInstance) ResourceUsage() (*QemuResourceUsage, error) {
+	pid := inst.Pid()
+	var usage QemuResourceUsage
+	var err error
+	if usage.CPUTime, err = procCPUTime(pid); err != nil {
+		return nil, err
+	}
+	if usage.PeakRSSKiB, err = procStatusValue(pid, "VmHWM"); err != nil {
+		return nil, err
+	}
+	io, err := procKeyValues(fmt.Sprintf("/proc/%d/io", pid))
+	if err == nil {
+		usage.HostReadBytes = io["read_bytes"]
+		usage.HostWrittenBytes = io["write_bytes"]
+	} else {
+		// /proc/<pid>/io needs ptrace access, which may be denied
+		plog.Debugf("Reading I/O of qemu (%d): %v", pid, err)
+	}
+
+	stats, err := inst.queryBlockStats()
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range stats.Return {
+		usage.DiskReadBytes += dev.Stats.RdBytes
+		usage.DiskWrittenBytes += dev.Stats.WrBytes
+	}
+	return &usage, nil
+}
+
+// procCPUTime returns the user and system CPU time of a process.
+func procCPUTime(pid int) (time.Duration, error) {
+	buf, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	cpu, err := parseStatCPUTime(string(buf))
+	if err != nil {
+		return 0, fmt.Errorf("parsing /proc/%d/stat: %w", pid, err)
+	}
+	return cpu, nil
+}
+
+// parseStatCPUTime returns the user and system CPU time of the contents of
+// /proc/<pid>/stat.
+func parseStatCPUTime(stat string) (time.Duration, error) {
+	// the command name may contain spaces and parentheses
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("no command name")
+	}
+	fields := strings.Fields(stat[end+1:])
+	// utime and stime are the 14th and 15th fields, counting from the pid
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("too few fields")
+	}
+	var ticks uint64
+	for _, field := range fields[11:13] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += v
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+// procStatusValue returns a value of /proc/<pid>/status in kB, e.g. VmHWM.
+func procStatusValue(pid int, key string) (uint64, error) {
+	values, err := procKeyValues(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	v, ok := values[key]
+	if !ok {
+		return 0, fmt.Errorf("no %s in /proc/%d/status", key, pid)
+	}
+	return v, nil
+}
+
+// procKeyValues parses the numeric values of a file of "key: value"
+// lines such as /proc/<pid>/status; units are dropped.
+func procKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeyValues(f)
+}
+
+// ParseKeyValues parses the numeric values of "key: value" lines such as
+// those of /proc/<pid>/status or /proc/meminfo; units are dropped, lines
+// without a number skipped.
+func ParseKeyValues(r io.Reader) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(val)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			values[key] = v
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,53 @@
+package platform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatCPUTime(t *testing.T) {
+	for _, tt := range []struct {
+		stat string
+		cpu  time.Duration
+		err  bool
+	}{
+		{"4242 (qemu-system-x86) S 1 4242 4242 0 -1 4194560 1234 0 0 0 250 150 0 0 20 0 5 0 100 0 0", 4 * time.Second, false},
+		// the command name may contain spaces and parentheses
+		{"4242 (qemu (x) y) S 1 4242 4242 0 -1 4194560 1234 0 0 0 1 2 0 0 20 0 5 0", 30 * time.Millisecond, false},
+		{"4242 (qemu) S 1 4242", 0, true},
+		{"4242 (qemu) S 1 4242 4242 0 -1 4194560 1234 0 0 0 x 2 0 0", 0, true},
+		{"", 0, true},
+	} {
+		cpu, err := parseStatCPUTime(tt.stat)
+		if (err != nil) != tt.err {
+			t.Errorf("parseStatCPUTime(%q) error = %v, want error %v", tt.stat, err, tt.err)
+			continue
+		}
+		if cpu != tt.cpu {
+			t.Errorf("parseStatCPUTime(%q) = %v, want %v", tt.stat, cpu, tt.cpu)
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	for _, tt := range []struct {
+		in     string
+		values map[string]uint64
+	}{
+		{"", map[string]uint64{}},
+		{"Name:\tqemu-system-x86\nVmHWM:\t 2097152 kB\nThreads:\t5\n", map[string]uint64{"VmHWM": 2097152, "Threads": 5}},
+		{"rchar: 1024\nread_bytes: 4096\nwrite_bytes: 0\n", map[string]uint64{"rchar": 1024, "read_bytes": 4096, "write_bytes": 0}},
+		{"no colon 12\nEmpty:\nNegative: -1\n", map[string]uint64{}},
+	} {
+		values, err := ParseKeyValues(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("ParseKeyValues(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, tt.values) {
+			t.Errorf("ParseKeyValues(%q) = %v, want %v", tt.in, values, tt.values)
+		}
+	}
+}
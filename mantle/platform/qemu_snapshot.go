@@ -0,0 +1,219 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/coreos-assembler/mantle/system/exec"
+	"github.com/coreos/coreos-assembler/mantle/util"
+)
+
+const (
+	// saveStateTimeout bounds copying the disk and RAM of an instance in
+	// SaveState
+	saveStateTimeout = 5 * time.Minute
+	// saveStateNode is the QMP node and job name of the disk copy
+	saveStateNode = "kola-state"
+)
+
+// Snapshot saves the state of the instance, its RAM, devices and disks, as
+// an internal snapshot called name, replacing an existing one. Internal
+// snapshots are stored in the qcow2 disks, so every writable disk must be
+// qcow2: UEFI variables, nbd and multipath disks prevent them.
+func (inst *QemuInstance) Snapshot(name string) error {
+	if err := validSnapshotName(name); err != nil {
+		return err
+	}
+	return inst.humanMonitorCommand("savevm " + name)
+}
+
+// Restore reverts the instance to the snapshot called name taken with
+// Snapshot. Connections to the guest don't survive it, and its clock is
+// behind until it's set again.
+func (inst *QemuInstance) Restore(name string) error {
+	if err := validSnapshotName(name); err != nil {
+		return err
+	}
+	return inst.humanMonitorCommand("loadvm " + name)
+}
+
+func validSnapshotName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\"") {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// SaveState writes the state of the instance so that another instance can
+// start from it with QemuBuilder.IncomingState: the clusters the guest
+// wrote to the primary disk go to diskPath, a qcow2 file backed by the
+// same image as the primary disk, and the RAM and device state to
+// statePath. The instance is paused meanwhile and resumes afterwards.
+func (inst *QemuInstance) SaveState(diskPath, statePath string) (err error) {
+	if inst.primaryDriveID == "" {
+		return errors.New("instance has no primary disk which can be copied")
+	}
+	blkdevs, err := inst.listBlkDevices()
+	if err != nil {
+		return err
+	}
+	var nodeName, backingFile, backingFormat string
+	var size int64
+	for _, dev := range blkdevs.Return {
+		if dev.Device == inst.primaryDriveID {
+			nodeName = dev.Inserted.NodeName
+			backingFile = dev.Inserted.Image.FullBackingFilename
+			backingFormat = dev.Inserted.Image.BackingFormat
+			size = dev.Inserted.Image.VirtualSize
+		}
+	}
+	if nodeName == "" || backingFile == "" {
+		return fmt.Errorf("primary disk %s not found or has no backing file", inst.primaryDriveID)
+	}
+
+	if _, err := inst.runQmpCommand(`{ "execute": "stop" }`); err != nil {
+		return errors.Wrapf(err, "Pausing instance")
+	}
+	defer func() {
+		if _, cerr := inst.runQmpCommand(`{ "execute": "cont" }`); cerr != nil && err == nil {
+			err = errors.Wrapf(cerr, "Resuming instance")
+		}
+	}()
+
+	// Copy the top layer of the primary disk; the guest can't write to it
+	// while paused, so it matches the RAM saved below.
+	imgOpts := []string{"create", "-f", "qcow2", "-b", backingFile}
+	if backingFormat != "" {
+		imgOpts = append(imgOpts, "-F", backingFormat)
+	}
+	imgOpts = append(imgOpts, diskPath, fmt.Sprintf("%d", size))
+	qemuImg := exec.Command("qemu-img", imgOpts...)
+	qemuImg.Stderr = os.Stderr
+	if err := qemuImg.Run(); err != nil {
+		return errors.Wrapf(err, "Creating %s", diskPath)
+	}
+	if _, err := inst.runQmpJSON("blockdev-add", map[string]interface{}{
+		"node-name": saveStateNode,
+		"driver":    "qcow2",
+		"file":      map[string]string{"driver": "file", "filename": diskPath},
+	}); err != nil {
+		return errors.Wrapf(err, "Adding %s", diskPath)
+	}
+	defer func() {
+		if _, derr := inst.runQmpJSON("blockdev-del", map[string]interface{}{"node-name": saveStateNode}); derr != nil && err == nil {
+			err = errors.Wrapf(derr, "Removing %s", diskPath)
+		}
+	}()
+	if _, err := inst.runQmpJSON("blockdev-backup", map[string]interface{}{
+		"job-id":       saveStateNode,
+		"device":       nodeName,
+		"target":       saveStateNode,
+		"sync":         "top",
+		"auto-dismiss": false,
+	}); err != nil {
+		return errors.Wrapf(err, "Copying primary disk")
+	}
+	if err := inst.waitBlockJob(saveStateNode); err != nil {
+		return err
+	}
+
+	// The VM stays paused during migration, so it completes in one pass.
+	if _, err := inst.runQmpJSON("migrate", map[string]interface{}{
+		"uri": "exec:cat > " + shellquote.Join(statePath),
+	}); err != nil {
+		return errors.Wrapf(err, "Saving RAM")
+	}
+	return util.WaitUntilReady(saveStateTimeout, 100*time.Millisecond, func() (bool, error) {
+		out, err := inst.runQmpCommand(`{ "execute": "query-migrate" }`)
+		if err != nil {
+			return false, err
+		}
+		var res struct {
+			Return struct {
+				Status    string `json:"status"`
+				ErrorDesc string `json:"error-desc"`
+			} `json:"return"`
+		}
+		if err := json.Unmarshal(out, &res); err != nil {
+			return false, errors.Wrapf(err, "De-serializing QMP query-migrate output")
+		}
+		switch res.Return.Status {
+		case "completed":
+			return true, nil
+		case "failed", "cancelled":
+			return false, fmt.Errorf("saving RAM %s: %s", res.Return.Status, res.Return.ErrorDesc)
+		}
+		return false, nil
+	})
+}
+
+// waitBlockJob waits for the block job id started with auto-dismiss off to
+// conclude, then dismisses it.
+func (inst *QemuInstance) waitBlockJob(id string) error {
+	var jobErr string
+	err := util.WaitUntilReady(saveStateTimeout, 100*time.Millisecond, func() (bool, error) {
+		out, err := inst.runQmpCommand(`{ "execute": "query-jobs" }`)
+		if err != nil {
+			return false, err
+		}
+		var res struct {
+			Return []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+				Error  string `json:"error"`
+			} `json:"return"`
+		}
+		if err := json.Unmarshal(out, &res); err != nil {
+			return false, errors.Wrapf(err, "De-serializing QMP query-jobs output")
+		}
+		for _, job := range res.Return {
+			if job.ID == id {
+				jobErr = job.Error
+				return job.Status == "concluded", nil
+			}
+		}
+		return false, fmt.Errorf("job %s not found", id)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := inst.runQmpJSON("job-dismiss", map[string]interface{}{"id": id}); err != nil {
+		return errors.Wrapf(err, "Dismissing job %s", id)
+	}
+	if jobErr != "" {
+		return fmt.Errorf("job %s failed: %s", id, jobErr)
+	}
+	return nil
+}
+
+// waitIncoming waits for an instance started with IncomingState to load it
+// and resume.
+func (inst *QemuInstance) waitIncoming() error {
+	return util.WaitUntilReady(saveStateTimeout, 100*time.Millisecond, func() (bool, error) {
+		out, err := inst.runQmpCommand(`{ "execute": "query-status" }`)
+		if err != nil {
+			return false, errors.Wrapf(err, "Running QMP query-status command")
+		}
+		var res struct {
+			Return struct {
+				Status string `json:"status"`
+			} `json:"return"`
+		}
+		if err := json.Unmarshal(out, &res); err != nil {
+			return false, errors.Wrapf(err, "De-serializing QMP query-status output")
+		}
+		switch res.Return.Status {
+		case "inmigrate":
+			return false, nil
+		case "running":
+			return true, nil
+		}
+		return false, fmt.Errorf("loading saved state: instance is %s", res.Return.Status)
+	})
+}
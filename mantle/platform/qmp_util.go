@@ -19,6 +19,7 @@ package platform
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -39,10 +40,29 @@ type QOMBlkDev struct {
 		Inserted   struct {
 			BackingFileDepth int    `json:"backing_file_depth"`
 			NodeName         string `json:"node-name"`
+			Image            struct {
+				Format              string `json:"format"`
+				VirtualSize         int64  `json:"virtual-size"`
+				FullBackingFilename string `json:"full-backing-filename"`
+				BackingFormat       string `json:"backing-filename-format"`
+			} `json:"image"`
 		} `json:"inserted"`
 	} `json:"return"`
 }
 
+type QOMBlockStats struct {
+	Return []struct {
+		Device string `json:"device"`
+		QDev   string `json:"qdev"`
+		Stats  struct {
+			RdBytes      uint64 `json:"rd_bytes"`
+			WrBytes      uint64 `json:"wr_bytes"`
+			RdOperations uint64 `json:"rd_operations"`
+			WrOperations uint64 `json:"wr_operations"`
+		} `json:"stats"`
+	} `json:"return"`
+}
+
 // runQmpCommand executes a qemu command over the QMP socket.
 func (inst *QemuInstance) runQmpCommand(cmd string) ([]byte, error) {
 	if inst.qmpSocket == nil {
@@ -51,6 +71,18 @@ func (inst *QemuInstance) runQmpCommand(cmd string) ([]byte, error) {
 	return inst.qmpSocket.Run([]byte(cmd))
 }
 
+// runQmpJSON executes a qemu command with arguments over the QMP socket.
+func (inst *QemuInstance) runQmpJSON(command string, args map[string]interface{}) ([]byte, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"execute":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inst.runQmpCommand(string(cmd))
+}
+
 // listDevices used the qmp socket to query which for device and their names.
 func (inst *QemuInstance) listDevices() (*QOMDev, error) {
 	listcmd := `{ "execute": "qom-list", "arguments": { "path": "/machine/peripheral-anon" } }`
@@ -81,6 +113,42 @@ func (inst *QemuInstance) listBlkDevices() (*QOMBlkDev, error) {
 	return &devs, nil
 }
 
+// queryBlockStats returns the I/O statistics of the block devices.
+func (inst *QemuInstance) queryBlockStats() (*QOMBlockStats, error) {
+	out, err := inst.runQmpCommand(`{ "execute": "query-blockstats" }`)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Running QMP query-blockstats command")
+	}
+
+	var stats QOMBlockStats
+	if err = json.Unmarshal(out, &stats); err != nil {
+		return nil, errors.Wrapf(err, "De-serializing QMP query-blockstats output")
+	}
+	return &stats, nil
+}
+
+// humanMonitorCommand runs a command of the human monitor, for the
+// features QMP lacks such as internal snapshots.
+func (inst *QemuInstance) humanMonitorCommand(cmdline string) error {
+	out, err := inst.runQmpJSON("human-monitor-command", map[string]interface{}{
+		"command-line": cmdline,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Running monitor command %q", cmdline)
+	}
+	var res struct {
+		Return string `json:"return"`
+	}
+	if err = json.Unmarshal(out, &res); err != nil {
+		return errors.Wrapf(err, "De-serializing output of monitor command %q", cmdline)
+	}
+	// the human monitor reports errors as output
+	if msg := strings.TrimSpace(res.Return); msg != "" {
+		return fmt.Errorf("monitor command %q: %s", cmdline, msg)
+	}
+	return nil
+}
+
 // setBootIndexForDevice uses the qmp socket to the bootindex for the particular device.
 func (inst *QemuInstance) setBootIndexForDevice(device string, bootindex int) error {
 	cmd := fmt.Sprintf(`{ "execute":"qom-set", "arguments": { "path":"%s", "property":"bootindex", "value":%d } }`,
@@ -0,0 +1,451 @@
+package platform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sftpServerCmd runs the SFTP server of the machine as root, so that
+	// all files can be read
+	sftpServerCmd = `sudo sh -c 'for p in /usr/libexec/openssh/sftp-server /usr/lib/openssh/sftp-server; do [ -x $p ] && exec $p; done; echo sftp-server not found >&2; exit 1'`
+	// partSuffix is appended to the name of files being copied; a copy
+	// which was interrupted resumes from it
+	partSuffix = ".part"
+	// transferAttempts is how many times the Machine helpers try a copy,
+	// reconnecting and resuming each time
+	transferAttempts = 3
+)
+
+// TransferOptions tune copies from machines. The zero value copies
+// everything silently.
+type TransferOptions struct {
+	// Exclude lists patterns (see path.Match) matched against the full
+	// remote path and the base name of each file and directory to skip
+	Exclude []string
+	// Progress, if set, is called as data is copied
+	Progress func(TransferProgress)
+}
+
+// TransferProgress is the progress of copying a file.
+type TransferProgress struct {
+	// Path is the remote path of the file
+	Path string
+	// Done and Total are the bytes copied so far and the size of the file
+	Done  int64
+	Total int64
+}
+
+func (opts *TransferOptions) excluded(remote string) bool {
+	if opts == nil {
+		return false
+	}
+	for _, pattern := range opts.Exclude {
+		if ok, _ := path.Match(pattern, remote); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(remote)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SFTPClient is an SFTP session as root on a machine.
+type SFTPClient struct {
+	*sftp.Client
+	ssh     *ssh.Client
+	session *ssh.Session
+	// checksum returns the SHA-256 of the first n bytes of a remote file
+	checksum func(remote string, n int64) (string, error)
+}
+
+// NewSFTPClient starts an SFTP session as root over client, which must log
+// in as a user with passwordless sudo. Closing the SFTPClient closes
+// client.
+func NewSFTPClient(client *ssh.Client) (*SFTPClient, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "failed creating SSH session")
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+	if err := session.Start(sftpServerCmd); err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.Wrapf(err, "starting sftp-server")
+	}
+	c, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.Wrapf(err, "starting SFTP session")
+	}
+	sc := &SFTPClient{Client: c, ssh: client, session: session}
+	sc.checksum = sc.remoteChecksum
+	return sc, nil
+}
+
+// Close ends the SFTP session and closes the SSH connection.
+func (c *SFTPClient) Close() error {
+	err := c.Client.Close()
+	c.session.Close()
+	c.ssh.Close()
+	return err
+}
+
+// Fetch copies the remote file or directory to local. Directories are
+// copied recursively; symlinks in them are recreated, but nothing is
+// written through them, and special files are skipped. Files are first written to <local>.part, and a copy which
+// finds one resumes from it. The data copied is verified with SHA-256
+// against the remote file. Errors copying single files are collected,
+// except a lost connection, which is returned as is so that the copy can
+// be retried.
+func (c *SFTPClient) Fetch(remote, local string, opts *TransferOptions) error {
+	return c.fetch(remote, local, local, opts)
+}
+
+// fetch is Fetch, refusing to write through symlinks under root.
+func (c *SFTPClient) fetch(remote, local, root string, opts *TransferOptions) error {
+	info, err := c.Stat(remote)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := checkNoSymlinks(root, local); err != nil {
+			return err
+		}
+		return c.fetchFile(remote, local, opts)
+	}
+
+	var errs []string
+	walker := c.Walk(remote)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if isConnectionError(err) {
+				return err
+			}
+			errs = append(errs, err.Error())
+			continue
+		}
+		p := walker.Path()
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, remote), "/")
+		dest := filepath.Join(local, filepath.FromSlash(rel))
+		info := walker.Stat()
+		if opts.excluded(p) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		err := checkNoSymlinks(root, dest)
+		switch {
+		case err != nil:
+		case info.IsDir():
+			err = os.MkdirAll(dest, 0777)
+		case info.Mode()&os.ModeSymlink != 0:
+			err = c.fetchSymlink(p, dest)
+		case info.Mode().IsRegular():
+			err = c.fetchFile(p, dest, opts)
+		}
+		if err != nil {
+			if isConnectionError(err) {
+				return err
+			}
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// FetchFiles copies the remote files and directories matching patterns
+// (see path.Match) into localDir, at their full remote path under it.
+// Patterns matching nothing are skipped. It returns the remote paths of
+// the matches.
+func (c *SFTPClient) FetchFiles(patterns []string, localDir string, opts *TransferOptions) ([]string, error) {
+	var matches []string
+	var errs []string
+	for _, pattern := range patterns {
+		if !path.IsAbs(pattern) {
+			return nil, fmt.Errorf("pattern %q must be absolute", pattern)
+		}
+		paths, err := c.Glob(pattern)
+		if err != nil {
+			return matches, errors.Wrapf(err, "matching %s", pattern)
+		}
+		for _, p := range paths {
+			if opts.excluded(p) {
+				continue
+			}
+			dest, err := localPath(localDir, p)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			matches = append(matches, p)
+			if err := c.fetch(p, dest, localDir, opts); err != nil {
+				if isConnectionError(err) {
+					return matches, err
+				}
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return matches, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return matches, nil
+}
+
+// localPath returns where FetchFiles copies the remote path p, refusing
+// paths which would land outside localDir.
+func localPath(localDir, p string) (string, error) {
+	dest := filepath.Join(localDir, filepath.FromSlash(p))
+	rel, err := filepath.Rel(localDir, dest)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("remote path %q is outside of %s", p, localDir)
+	}
+	return dest, nil
+}
+
+// checkNoSymlinks returns an error if a directory between root and p is
+// a symlink, such as one fetched from the machine, which could make
+// writing p land outside of root.
+func checkNoSymlinks(root, p string) error {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", p, root)
+	}
+	dir := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("not writing %s through symlink %s", p, dir)
+		}
+	}
+	return nil
+}
+
+func (c *SFTPClient) fetchSymlink(remote, local string) error {
+	target, err := c.ReadLink(remote)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0777); err != nil {
+		return err
+	}
+	os.Remove(local)
+	return os.Symlink(target, local)
+}
+
+func (c *SFTPClient) fetchFile(remote, local string, opts *TransferOptions) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0777); err != nil {
+		return err
+	}
+	in, err := c.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	part := local + partSuffix
+	out, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// resume a previous copy, unless the remote file shrank since
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > info.Size() {
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var w io.Writer = out
+	if opts != nil && opts.Progress != nil {
+		w = &progressWriter{w: out, progress: opts.Progress, p: TransferProgress{
+			Path:  remote,
+			Done:  offset,
+			Total: info.Size(),
+		}}
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return errors.Wrapf(err, "copying %s", remote)
+	}
+
+	// Files such as logs may grow while being copied, so compare what
+	// was copied with the same number of bytes of the remote file.
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, out)
+	if err != nil {
+		return err
+	}
+	remoteSum, err := c.checksum(remote, n)
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != remoteSum {
+		// the resumed part may be stale; start over next time
+		os.Remove(part)
+		return fmt.Errorf("checksum of %s: copied %s, remote %s", remote, sum, remoteSum)
+	}
+
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(part, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(part, local)
+}
+
+// remoteChecksum returns the SHA-256 of the first n bytes of a remote file.
+func (c *SFTPClient) remoteChecksum(remote string, n int64) (string, error) {
+	session, err := c.ssh.NewSession()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed creating SSH session")
+	}
+	defer session.Close()
+	out, err := session.Output(fmt.Sprintf("sudo head -c %d %s | sha256sum", n, shellquote.Join(remote)))
+	if err != nil {
+		return "", errors.Wrapf(err, "computing checksum of %s", remote)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("computing checksum of %s: no output", remote)
+	}
+	return fields[0], nil
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress func(TransferProgress)
+	p        TransferProgress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.Done += int64(n)
+	pw.progress(pw.p)
+	return n, err
+}
+
+// CopyFromMachine copies the remote file or directory of m to local, see
+// SFTPClient.Fetch. Interrupted copies are retried, resuming where they
+// stopped.
+func CopyFromMachine(m Machine, remote, local string, opts *TransferOptions) error {
+	return withSFTP(m, func(c *SFTPClient) error {
+		return c.Fetch(remote, local, opts)
+	})
+}
+
+// CopyDirFromMachine copies the remote directory remoteDir of m to
+// localDir, the counterpart of CopyDirToMachine.
+func CopyDirFromMachine(m Machine, remoteDir, localDir string, opts *TransferOptions) error {
+	return withSFTP(m, func(c *SFTPClient) error {
+		info, err := c.Stat(remoteDir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", remoteDir)
+		}
+		return c.Fetch(remoteDir, localDir, opts)
+	})
+}
+
+// FetchFiles copies the files and directories of m matching patterns into
+// localDir, see SFTPClient.FetchFiles.
+func FetchFiles(m Machine, patterns []string, localDir string, opts *TransferOptions) ([]string, error) {
+	var matches []string
+	err := withSFTP(m, func(c *SFTPClient) error {
+		var err error
+		matches, err = c.FetchFiles(patterns, localDir, opts)
+		return err
+	})
+	return matches, err
+}
+
+// withSFTP runs f with an SFTP session on m, retrying with a new session
+// if the connection fails.
+func withSFTP(m Machine, f func(*SFTPClient) error) error {
+	var err error
+	for attempt := 1; attempt <= transferAttempts; attempt++ {
+		var client *ssh.Client
+		client, err = m.SSHClient()
+		if err != nil {
+			return errors.Wrapf(err, "failed creating SSH client")
+		}
+		var c *SFTPClient
+		c, err = NewSFTPClient(client)
+		if err != nil {
+			return err
+		}
+		err = f(c)
+		c.Close()
+		if err == nil || !isConnectionError(err) {
+			return err
+		}
+		plog.Warningf("Copying from machine %s (attempt %d/%d): %v", m.ID(), attempt, transferAttempts, err)
+	}
+	return err
+}
+
+// isConnectionError returns true if err means the SFTP session was lost.
+func isConnectionError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == io.EOF || cause == io.ErrUnexpectedEOF || cause == sftp.ErrSSHFxConnectionLost
+}
@@ -0,0 +1,278 @@
+package platform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+)
+
+func TestLocalPath(t *testing.T) {
+	for _, tt := range []struct {
+		remote string
+		local  string
+		err    bool
+	}{
+		{"/var/log/messages", "/out/var/log/messages", false},
+		{"/", "/out", false},
+		{"/var/..log", "/out/var/..log", false},
+		{"/../etc/passwd", "", true},
+		{"/var/../../etc/passwd", "", true},
+		{"/..", "", true},
+	} {
+		local, err := localPath("/out", tt.remote)
+		if (err != nil) != tt.err {
+			t.Errorf("localPath(%q) error = %v, want error %v", tt.remote, err, tt.err)
+			continue
+		}
+		if local != tt.local {
+			t.Errorf("localPath(%q) = %q, want %q", tt.remote, local, tt.local)
+		}
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		conn bool
+	}{
+		{io.EOF, true},
+		{errors.Wrapf(io.ErrUnexpectedEOF, "copying /var/log/messages"), true},
+		{errors.Wrapf(sftp.ErrSSHFxConnectionLost, "matching /var/log/*"), true},
+		{os.ErrNotExist, false},
+		{fmt.Errorf("checksum of /var/log/messages: copied 1, remote 2"), false},
+		// flattened into a message, the cause is lost
+		{fmt.Errorf("%s", io.EOF), false},
+	} {
+		if conn := isConnectionError(tt.err); conn != tt.conn {
+			t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, conn, tt.conn)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	opts := &TransferOptions{Exclude: []string{"*.gz", "/var/log/journal", "/var/lib/*/cache"}}
+	for _, tt := range []struct {
+		remote   string
+		excluded bool
+	}{
+		{"/var/log/messages", false},
+		{"/var/log/messages.1.gz", true},
+		{"/var/log/journal", true},
+		{"/var/log/journal/abc/system.journal", false},
+		{"/var/lib/foo/cache", true},
+		{"/var/lib/foo/bar/cache", false},
+		{"/gz", false},
+	} {
+		if excluded := opts.excluded(tt.remote); excluded != tt.excluded {
+			t.Errorf("excluded(%q) = %v, want %v", tt.remote, excluded, tt.excluded)
+		}
+	}
+	var none *TransferOptions
+	if none.excluded("/var/log/messages") {
+		t.Errorf("nil options exclude files")
+	}
+}
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestSFTPClient returns an SFTPClient talking to an in-process SFTP
+// server over the local file system, with the remote checksums computed
+// locally.
+func newTestSFTPClient(t *testing.T) *SFTPClient {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{serverReader, serverWriter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// the client waits for the server to hang up
+		server.Close()
+		client.Close()
+	})
+	return &SFTPClient{Client: client, checksum: localChecksum}
+}
+
+func localChecksum(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func TestFetchFile(t *testing.T) {
+	c := newTestSFTPClient(t)
+	remoteDir := t.TempDir()
+	remote := filepath.Join(remoteDir, "messages")
+	content := []byte(strings.Repeat("kernel: all is well\n", 1000))
+	if err := os.WriteFile(remote, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		part []byte // left by an interrupted copy, if set
+		err  bool
+	}{
+		{name: "fresh"},
+		{name: "resume", part: content[:len(content)/2]},
+		{name: "complete part", part: content},
+		{name: "part longer than file", part: append(append([]byte{}, content...), "more"...)},
+		{name: "stale part", part: bytes.Repeat([]byte("x"), 100), err: true},
+	} {
+		local := filepath.Join(t.TempDir(), "messages")
+		if tt.part != nil {
+			if err := os.WriteFile(local+partSuffix, tt.part, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var copied int64
+		opts := &TransferOptions{Progress: func(p TransferProgress) {
+			copied = p.Done
+		}}
+		err := c.Fetch(remote, local, opts)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: Fetch() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if _, err := os.Stat(local + partSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s: part file left: %v", tt.name, err)
+		}
+		if tt.err {
+			// the stale part was removed, so the next copy starts over
+			if err := c.Fetch(remote, local, opts); err != nil {
+				t.Errorf("%s: Fetch() after failure: %v", tt.name, err)
+				continue
+			}
+		}
+		got, err := os.ReadFile(local)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: copied %d bytes differing from the remote file", tt.name, len(got))
+		}
+		if tt.part != nil && !tt.err && len(tt.part) < len(content) && copied != int64(len(content)) {
+			t.Errorf("%s: copy ended at %d bytes, want %d", tt.name, copied, len(content))
+		}
+		if info, err := os.Stat(local); err == nil && info.Mode().Perm() != 0640 {
+			t.Errorf("%s: mode %v, want 0640", tt.name, info.Mode().Perm())
+		}
+	}
+}
+
+func TestFetchDir(t *testing.T) {
+	c := newTestSFTPClient(t)
+	remote := t.TempDir()
+	for name, content := range map[string]string{
+		"messages":          "log",
+		"old/messages.1.gz": "old log",
+		"journal/system":    "journal",
+	} {
+		path := filepath.Join(remote, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("messages", filepath.Join(remote, "current")); err != nil {
+		t.Fatal(err)
+	}
+
+	local := filepath.Join(t.TempDir(), "logs")
+	opts := &TransferOptions{Exclude: []string{"*.gz", filepath.Join(remote, "journal")}}
+	if err := c.Fetch(remote, local, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(local, "messages")); err != nil || string(got) != "log" {
+		t.Errorf("messages = %q, %v", got, err)
+	}
+	if target, err := os.Readlink(filepath.Join(local, "current")); err != nil || target != "messages" {
+		t.Errorf("current -> %q, %v", target, err)
+	}
+	for _, name := range []string{"old/messages.1.gz", "journal"} {
+		if _, err := os.Lstat(filepath.Join(local, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("excluded %s copied: %v", name, err)
+		}
+	}
+}
+
+func TestFetchFilesThroughSymlink(t *testing.T) {
+	c := newTestSFTPClient(t)
+	remote := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "y"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(remote, "x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the link is recreated by the first pattern, the second one must
+	// not be written through it
+	local := t.TempDir()
+	_, err := c.FetchFiles([]string{remote, filepath.Join(remote, "x/y")}, local, nil)
+	if err == nil || !strings.Contains(err.Error(), "through symlink") {
+		t.Errorf("FetchFiles error = %v, want a refusal to write through the symlink", err)
+	}
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "y" {
+		t.Errorf("files written outside of the local dir: %v", entries)
+	}
+}
+
+func TestCheckNoSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		err  bool
+	}{
+		{root, false},
+		{filepath.Join(root, "file"), false},
+		{filepath.Join(root, "dir/file"), false},
+		{filepath.Join(root, "new/dir/file"), false},
+		// replacing the link itself is fine
+		{filepath.Join(root, "link"), false},
+		{filepath.Join(root, "link/etc/passwd"), true},
+		{filepath.Dir(root), true},
+	} {
+		if err := checkNoSymlinks(root, tt.path); (err != nil) != tt.err {
+			t.Errorf("checkNoSymlinks(%q) error = %v, want error %v", tt.path, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package platform
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Tunnel forwards connections to a local address to an address on a
+// machine, see Machine.Forward.
+type Tunnel struct {
+	m        Machine
+	network  string // of the remote address
+	remote   string
+	listener net.Listener
+
+	mu     sync.Mutex
+	client *ssh.Client
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// addrNetwork returns the network of an address given to Forward or
+// DialGuest: unix for absolute paths, tcp otherwise.
+func addrNetwork(addr string) string {
+	if strings.HasPrefix(addr, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// Forward listens on localAddr and forwards each connection to remoteAddr,
+// as seen from m, over SSH. Addresses are host:port, or the path of a Unix
+// socket; an empty localAddr picks a free port on 127.0.0.1, see
+// Tunnel.Addr. The SSH connection is reopened if it's lost, e.g. across
+// reboots.
+func Forward(m Machine, localAddr, remoteAddr string) (*Tunnel, error) {
+	if localAddr == "" {
+		localAddr = "127.0.0.1:0"
+	}
+	client, err := m.SSHClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating SSH client")
+	}
+	listener, err := net.Listen(addrNetwork(localAddr), localAddr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	t := &Tunnel{
+		m:        m,
+		network:  addrNetwork(remoteAddr),
+		remote:   remoteAddr,
+		listener: listener,
+		client:   client,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// Addr returns the local address of the tunnel.
+func (t *Tunnel) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops listening, closes the forwarded connections and the SSH
+// connection.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.listener.Close()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	t.client.Close()
+	return err
+}
+
+func (t *Tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if !closed {
+				plog.Errorf("Forwarding to %s on machine %s: %v", t.remote, t.m.ID(), err)
+			}
+			return
+		}
+		t.wg.Add(1)
+		go t.forward(local)
+	}
+}
+
+func (t *Tunnel) forward(local net.Conn) {
+	defer t.wg.Done()
+	remote, err := t.dial()
+	if err != nil {
+		plog.Warningf("Forwarding to %s on machine %s: %v", t.remote, t.m.ID(), err)
+		local.Close()
+		return
+	}
+	if !t.track(local, remote) {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		// let the other direction see EOF, and end if it already did
+		dst.Close()
+		src.Close()
+	}
+	go pipe(remote, local)
+	go pipe(local, remote)
+	wg.Wait()
+	t.untrack(local, remote)
+}
+
+// dial opens a connection to the remote address, reopening the SSH
+// connection once if it fails.
+func (t *Tunnel) dial() (net.Conn, error) {
+	t.mu.Lock()
+	client := t.client
+	t.mu.Unlock()
+	conn, err := client.Dial(t.network, t.remote)
+	if err == nil {
+		return conn, nil
+	}
+	// an error other than a lost connection leaves the client working
+	if _, _, rerr := client.SendRequest("keepalive@openssh.com", true, nil); rerr == nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, err
+	}
+	if t.client == client {
+		newClient, cerr := t.m.SSHClient()
+		if cerr != nil {
+			return nil, errors.Wrapf(cerr, "failed recreating SSH client")
+		}
+		client.Close()
+		t.client = newClient
+	}
+	return t.client.Dial(t.network, t.remote)
+}
+
+// track records the connections of a forward so that Close closes them. It
+// returns false and closes them if the tunnel is already closed.
+func (t *Tunnel) track(conns ...net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return false
+	}
+	for _, conn := range conns {
+		t.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (t *Tunnel) untrack(conns ...net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, conn := range conns {
+		delete(t.conns, conn)
+	}
+}
+
+// guestConn is a connection opened by DialGuest, which owns its SSH
+// connection.
+type guestConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *guestConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+// DialGuest connects to addr on m over SSH, as seen from m. network is tcp,
+// tcp4, tcp6 or unix; sockets are opened as the SSH user. Each connection
+// has its own SSH connection, closed with it, so DialGuest can serve as the
+// dialer of HTTP and gRPC clients:
+//
+//	client := &http.Client{Transport: &http.Transport{
+//		DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+//			return m.DialGuest(network, addr)
+//		},
+//	}}
+func DialGuest(m Machine, network, addr string) (net.Conn, error) {
+	client, err := m.SSHClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating SSH client")
+	}
+	conn, err := client.Dial(network, addr)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "connecting to %s on machine %s", addr, m.ID())
+	}
+	return &guestConn{Conn: conn, client: client}, nil
+}
@@ -0,0 +1,252 @@
+package platform
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an SSH server accepting anyone and forwarding
+// direct-tcpip channels, like sshd does for ssh -L.
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{listener: listener, config: config}
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(newChan.ExtraData(), &target); err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			_, _ = io.Copy(ch, remote)
+			ch.CloseWrite()
+		}()
+		go func() {
+			_, _ = io.Copy(remote, ch)
+			remote.Close()
+		}()
+	}
+}
+
+// dropConnections closes the SSH connections, as a reboot of the machine
+// would.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// sshMachine is a Machine reached through a testSSHServer; only the
+// methods used by Forward and DialGuest are implemented.
+type sshMachine struct {
+	Machine
+	server *testSSHServer
+
+	mu      sync.Mutex
+	clients int
+}
+
+func (m *sshMachine) ID() string {
+	return "test"
+}
+
+func (m *sshMachine) SSHClient() (*ssh.Client, error) {
+	m.mu.Lock()
+	m.clients++
+	m.mu.Unlock()
+	return ssh.Dial("tcp", m.server.listener.Addr().String(), &ssh.ClientConfig{
+		User:            "core",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+}
+
+// startEchoServer starts a TCP server standing in for a service on the
+// machine, which echoes what it receives.
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func checkEcho(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("read %q, want %q", buf, msg)
+	}
+}
+
+func TestDialGuest(t *testing.T) {
+	m := &sshMachine{server: startTestSSHServer(t)}
+	echo := startEchoServer(t)
+
+	conn, err := DialGuest(m, "tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn, "hello")
+	if err := conn.Close(); err != nil {
+		t.Errorf("closing: %v", err)
+	}
+
+	// nothing listens on a port just closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if conn, err := DialGuest(m, "tcp", closed.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("DialGuest to a closed port succeeded")
+	}
+}
+
+func TestForward(t *testing.T) {
+	server := startTestSSHServer(t)
+	m := &sshMachine{server: server}
+	echo := startEchoServer(t)
+
+	tunnel, err := Forward(m, "", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	first, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	checkEcho(t, first, "first")
+	second, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	checkEcho(t, second, "second")
+	checkEcho(t, first, "first again")
+
+	// the tunnel reconnects once the SSH connection is lost
+	server.dropConnections()
+	third, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	checkEcho(t, third, "third")
+	m.mu.Lock()
+	if m.clients != 2 {
+		t.Errorf("%d SSH connections, want 2", m.clients)
+	}
+	m.mu.Unlock()
+
+	// closing the tunnel closes the forwarded connections
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("closing: %v", err)
+	}
+	if _, err := third.Read(make([]byte, 1)); err == nil {
+		t.Errorf("forwarded connection still open after Close")
+	}
+	if conn, err := net.Dial("tcp", tunnel.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("tunnel still listening after Close")
+	}
+}
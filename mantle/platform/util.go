@@ -33,7 +33,23 @@ import (
 // session on the Machine m. Manhole blocks until the shell session has ended.
 // If os.Stdin does not refer to a TTY, Manhole returns immediately with a nil
 // error.
-func Manhole(m Machine) (err error) {
+func Manhole(m Machine) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+
+	client, err := m.SSHClient()
+	if err != nil {
+		return fmt.Errorf("SSH client failed: %v", err)
+	}
+
+	defer client.Close()
+
+	return ManholeClient(client)
+}
+
+// ManholeClient is Manhole for an existing SSH connection.
+func ManholeClient(client *ssh.Client) (err error) {
 	fd := int(os.Stdin.Fd())
 	if !term.IsTerminal(fd) {
 		return nil
@@ -49,13 +65,6 @@ func Manhole(m Machine) (err error) {
 		}
 	}()
 
-	client, err := m.SSHClient()
-	if err != nil {
-		return fmt.Errorf("SSH client failed: %v", err)
-	}
-
-	defer client.Close()
-
 	session, err := client.NewSession()
 	if err != nil {
 		return fmt.Errorf("SSH session failed: %v", err)
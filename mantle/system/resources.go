@@ -0,0 +1,156 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetMemoryMiB returns the amount of memory in MiB that is currently
+// available to us; it is the smaller of the host's available memory and
+// the headroom left under our cgroup memory limit, if any.
+func GetMemoryMiB() (uint, error) {
+	available, err := getMeminfoAvailable()
+	if err != nil {
+		return 0, err
+	}
+
+	headroom, err := getCgroupMemoryHeadroom()
+	if err != nil {
+		return 0, err
+	}
+
+	if headroom < available {
+		return uint(headroom >> 20), nil
+	}
+	return uint(available >> 20), nil
+}
+
+// GetTotalMemoryMiB returns the amount of memory in MiB the host has,
+// or our cgroup memory limit if that is lower.
+func GetTotalMemoryMiB() (uint, error) {
+	total, err := getMeminfo("MemTotal")
+	if err != nil {
+		return 0, err
+	}
+
+	limit, err := readCgroupValue("/sys/fs/cgroup/memory.max")
+	if os.IsNotExist(err) {
+		limit, err = readCgroupValue("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	}
+	if os.IsNotExist(err) {
+		limit = math.MaxUint64
+	} else if err != nil {
+		return 0, err
+	}
+
+	if limit < total {
+		return uint(limit >> 20), nil
+	}
+	return uint(total >> 20), nil
+}
+
+// GetDiskSpaceGiB returns the amount of space in GiB available to
+// unprivileged users on the filesystem containing path.
+func GetDiskSpaceGiB(path string) (uint, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return uint((uint64(st.Bavail) * uint64(st.Bsize)) >> 30), nil
+}
+
+// getMeminfoAvailable returns MemAvailable from /proc/meminfo in bytes.
+func getMeminfoAvailable() (uint64, error) {
+	return getMeminfo("MemAvailable")
+}
+
+// getMeminfo returns a field of /proc/meminfo in bytes.
+func getMeminfo(field string) (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("opening /proc/meminfo: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != field+":" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", field, err)
+		}
+		return kb << 10, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("reading /proc/meminfo: %w", err)
+	}
+	return 0, fmt.Errorf("%s not found in /proc/meminfo", field)
+}
+
+// getCgroupMemoryHeadroom returns how many bytes may still be allocated
+// before hitting the cgroup memory limit.
+func getCgroupMemoryHeadroom() (uint64, error) {
+	// cgroups v2
+	limit, err := readCgroupValue("/sys/fs/cgroup/memory.max")
+	if err == nil {
+		if limit == math.MaxUint64 {
+			return math.MaxUint64, nil
+		}
+		usage, err := readCgroupValue("/sys/fs/cgroup/memory.current")
+		if err != nil {
+			return 0, err
+		}
+		return subFloor(limit, usage), nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	// cgroups v1; an unlimited cgroup reports a huge page-aligned value
+	// here, which is fine since we take the minimum with MemAvailable.
+	limit, err = readCgroupValue("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	if os.IsNotExist(err) {
+		return math.MaxUint64, nil
+	} else if err != nil {
+		return 0, err
+	}
+	usage, err := readCgroupValue("/sys/fs/cgroup/memory/memory.usage_in_bytes")
+	if err != nil {
+		return 0, err
+	}
+	return subFloor(limit, usage), nil
+}
+
+// readCgroupValue parses a single-value cgroup file; "max" is returned as
+// math.MaxUint64. Errors from opening the file are returned unwrapped so
+// callers can use os.IsNotExist.
+func readCgroupValue(path string) (uint64, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	val := strings.TrimSpace(string(buf))
+	if val == "max" {
+		return math.MaxUint64, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %w", path, err)
+	}
+	return n, nil
+}
+
+func subFloor(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}